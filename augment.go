@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Strength of the augmentations
+const (
+	maxRotation    = 15.0 // degrees either way
+	maxTranslation = 3    // pixels either way
+	noiseStd       = 0.1  // of the white level
+	maxBrightness  = 0.3  // fraction brighter or darker
+)
+
+// augmentation is one --augment step, applied to a sample with a probability
+type augmentation struct {
+	name        string
+	probability float64
+}
+
+// augmentations are the steps of the current run, in order
+var augmentations []augmentation
+
+// augmenters transform a 28x28 sample whose pixels range from 0 to white,
+// drawing their parameters from random
+var augmenters = map[string]func(pixels []float64, white float64, random *rand.Rand) []float64{
+	"rotate": func(pixels []float64, white float64, random *rand.Rand) []float64 {
+		angle := (random.Float64()*2 - 1) * maxRotation * math.Pi / 180
+		sin, cos := math.Sin(angle), math.Cos(angle)
+		center := float64(mnistSide-1) / 2
+		return resample(pixels, func(x, y int) (int, int) {
+			dx, dy := float64(x)-center, float64(y)-center
+			return int(math.Round(center + dx*cos + dy*sin)), int(math.Round(center - dx*sin + dy*cos))
+		})
+	},
+	"translate": func(pixels []float64, white float64, random *rand.Rand) []float64 {
+		shiftX := random.Intn(2*maxTranslation+1) - maxTranslation
+		shiftY := random.Intn(2*maxTranslation+1) - maxTranslation
+		return resample(pixels, func(x, y int) (int, int) {
+			return x - shiftX, y - shiftY
+		})
+	},
+	"noise": func(pixels []float64, white float64, random *rand.Rand) []float64 {
+		noisy := make([]float64, len(pixels))
+		for i, pixel := range pixels {
+			noisy[i] = clampPixel(pixel+random.NormFloat64()*noiseStd*white, white)
+		}
+		return noisy
+	},
+	"blur": func(pixels []float64, white float64, random *rand.Rand) []float64 {
+		// 3x3 box blur, averaging the neighbours inside the image
+		blurred := make([]float64, len(pixels))
+		for y := 0; y < mnistSide; y++ {
+			for x := 0; x < mnistSide; x++ {
+				sum, n := 0.0, 0
+				for ny := y - 1; ny <= y+1; ny++ {
+					for nx := x - 1; nx <= x+1; nx++ {
+						if nx >= 0 && nx < mnistSide && ny >= 0 && ny < mnistSide {
+							sum += pixels[ny*mnistSide+nx]
+							n++
+						}
+					}
+				}
+				blurred[y*mnistSide+x] = sum / float64(n)
+			}
+		}
+		return blurred
+	},
+	"invert": func(pixels []float64, white float64, random *rand.Rand) []float64 {
+		inverted := make([]float64, len(pixels))
+		for i, pixel := range pixels {
+			inverted[i] = white - pixel
+		}
+		return inverted
+	},
+	"brightness": func(pixels []float64, white float64, random *rand.Rand) []float64 {
+		factor := 1 + (random.Float64()*2-1)*maxBrightness
+		brightened := make([]float64, len(pixels))
+		for i, pixel := range pixels {
+			brightened[i] = clampPixel(pixel*factor, white)
+		}
+		return brightened
+	},
+}
+
+// parseAugmentations parses --augment, a comma-separated list of steps, each
+// with an optional probability ("rotate:0.5,noise"); steps without one are
+// always applied
+func parseAugmentations(spec string) ([]augmentation, error) {
+	var names []string
+	for name := range augmenters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var steps []augmentation
+	for _, field := range strings.Split(spec, ",") {
+		name, probabilityText, hasProbability := strings.Cut(strings.TrimSpace(field), ":")
+		if _, ok := augmenters[name]; !ok {
+			return nil, fmt.Errorf("unknown augmentation %q (expected %s)", name, strings.Join(names, ", "))
+		}
+		step := augmentation{name: name, probability: 1}
+		if hasProbability {
+			probability, err := strconv.ParseFloat(probabilityText, 64)
+			if err != nil || probability < 0 || probability > 1 {
+				return nil, fmt.Errorf("invalid probability %q for %s (expected 0 to 1)", probabilityText, name)
+			}
+			step.probability = probability
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// augmentSample applies each augmentation step to a copy of the sample with
+// its probability, in the pixel scale of the whole dataset (rawPixels)
+func augmentSample(sample labelledSample, steps []augmentation, random *rand.Rand) labelledSample {
+	if len(sample.pixels) != mnistSide*mnistSide {
+		return sample
+	}
+	white := 1.0
+	if rawPixels {
+		white = 255
+	}
+	for _, step := range steps {
+		if random.Float64() < step.probability {
+			sample.pixels = augmenters[step.name](sample.pixels, white, random)
+		}
+	}
+	return sample
+}
+
+// resample builds an image whose pixel (x, y) is the pixel source(x, y) of
+// the original, black outside of it
+func resample(pixels []float64, source func(x, y int) (int, int)) []float64 {
+	moved := make([]float64, len(pixels))
+	for y := 0; y < mnistSide; y++ {
+		for x := 0; x < mnistSide; x++ {
+			sx, sy := source(x, y)
+			if sx >= 0 && sx < mnistSide && sy >= 0 && sy < mnistSide {
+				moved[y*mnistSide+x] = pixels[sy*mnistSide+sx]
+			}
+		}
+	}
+	return moved
+}
+
+// clampPixel keeps a pixel between black and white
+func clampPixel(pixel, white float64) float64 {
+	return math.Max(0, math.Min(white, pixel))
+}
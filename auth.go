@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// authOptions holds the bearer token and OAuth2 client-credentials flags
+type authOptions struct {
+	bearerToken  string
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       string
+}
+
+// headerFlags collects repeated --header "Key: Value" flags
+type headerFlags http.Header
+
+func (h headerFlags) String() string {
+	var pairs []string
+	for key, values := range h {
+		for _, value := range values {
+			pairs = append(pairs, key+": "+value)
+		}
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func (h headerFlags) Set(value string) error {
+	key, val, ok := strings.Cut(value, ":")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return fmt.Errorf("header %q must be in \"Key: Value\" form", value)
+	}
+	http.Header(h).Add(key, strings.TrimSpace(val))
+	return nil
+}
+
+// extraHeaders are added to every request, from --header and --api-key
+var extraHeaders = http.Header{}
+
+// tokenSource supplies the bearer token attached to every request, if any
+var tokenSource oauth2.TokenSource
+
+// newTokenSource returns a token source for the configured authentication,
+// or nil when no token should be attached
+func newTokenSource(opts authOptions) (oauth2.TokenSource, error) {
+	clientCredentials := opts.tokenURL != "" || opts.clientID != "" || opts.clientSecret != ""
+	switch {
+	case opts.bearerToken != "" && clientCredentials:
+		return nil, fmt.Errorf("--bearer-token cannot be combined with the OAuth2 client-credentials flags")
+	case opts.bearerToken != "":
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: opts.bearerToken}), nil
+	case clientCredentials:
+		if opts.tokenURL == "" || opts.clientID == "" {
+			return nil, fmt.Errorf("--oauth-token-url and --oauth-client-id are required for the client-credentials flow")
+		}
+		config := clientcredentials.Config{
+			ClientID:     opts.clientID,
+			ClientSecret: opts.clientSecret,
+			TokenURL:     opts.tokenURL,
+		}
+		if opts.scopes != "" {
+			config.Scopes = strings.Split(opts.scopes, ",")
+		}
+		// The returned source caches the token and fetches a new one shortly
+		// before it expires, which keeps long soak runs authenticated
+		return config.TokenSource(context.Background()), nil
+	}
+	return nil, nil
+}
+
+// bearerTokenSigner attaches a token from the source as an Authorization header.
+// The oauth2 token sources cache tokens and refresh them shortly before expiry.
+func bearerTokenSigner(source oauth2.TokenSource) func(req *http.Request, payload []byte) error {
+	return func(req *http.Request, payload []byte) error {
+		token, err := source.Token()
+		if err != nil {
+			return fmt.Errorf("failed to obtain access token: %v", err)
+		}
+		token.SetAuthHeader(req)
+		return nil
+	}
+}
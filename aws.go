@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// sigV4Signer signs outgoing requests with AWS Signature Version 4 using
+// credentials from the default AWS credential chain
+type sigV4Signer struct {
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+	region      string
+	service     string
+	now         func() time.Time
+}
+
+// newSigV4Signer loads AWS credentials and returns a signer for the given
+// service. An empty region falls back to the shared AWS configuration.
+func newSigV4Signer(region, service string) (*sigV4Signer, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %v", err)
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("no AWS region configured")
+	}
+	return &sigV4Signer{
+		credentials: cfg.Credentials,
+		signer:      v4.NewSigner(),
+		region:      cfg.Region,
+		service:     service,
+		now:         time.Now,
+	}, nil
+}
+
+// sign adds the SigV4 Authorization headers to the request
+func (s *sigV4Signer) sign(req *http.Request, payload []byte) error {
+	creds, err := s.credentials.Retrieve(req.Context())
+	if err != nil {
+		return fmt.Errorf("failed to retrieve AWS credentials: %v", err)
+	}
+	hash := sha256.Sum256(payload)
+	return s.signer.SignHTTP(req.Context(), creds, req, hex.EncodeToString(hash[:]), s.service, s.region, s.now())
+}
+
+// sageMakerInvokeURL returns the InvokeEndpoint URL of a SageMaker endpoint
+func sageMakerInvokeURL(region, endpointName string) string {
+	return fmt.Sprintf("https://runtime.sagemaker.%s.amazonaws.com/endpoints/%s/invocations", region, url.PathEscape(endpointName))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// azureIMDSTokenURL is the managed identity endpoint of the Azure instance metadata service
+const azureIMDSTokenURL = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+// azureOptions holds the Azure AD authentication flags
+type azureOptions struct {
+	mode         string
+	tenantID     string
+	clientID     string
+	clientSecret string
+	scope        string
+}
+
+// newAzureTokenSource returns a refreshing Azure AD token source using either
+// a client secret or a managed identity
+func newAzureTokenSource(opts azureOptions) (oauth2.TokenSource, error) {
+	switch opts.mode {
+	case "client-secret":
+		secret := opts.clientSecret
+		if secret == "" {
+			secret = os.Getenv("AZURE_CLIENT_SECRET")
+		}
+		if opts.tenantID == "" || opts.clientID == "" || secret == "" {
+			return nil, fmt.Errorf("--azure-tenant-id, --azure-client-id and a client secret are required")
+		}
+		config := clientcredentials.Config{
+			ClientID:     opts.clientID,
+			ClientSecret: secret,
+			TokenURL:     "https://login.microsoftonline.com/" + url.PathEscape(opts.tenantID) + "/oauth2/v2.0/token",
+			Scopes:       []string{opts.scope},
+		}
+		return config.TokenSource(context.Background()), nil
+	case "managed-identity":
+		return oauth2.ReuseTokenSource(nil, managedIdentityTokenSource{
+			resource: strings.TrimSuffix(opts.scope, "/.default"),
+			clientID: opts.clientID,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown Azure auth mode %q (expected client-secret or managed-identity)", opts.mode)
+	}
+}
+
+// managedIdentityTokenSource fetches tokens for a managed identity, either
+// from App Service's identity endpoint or from the instance metadata service
+type managedIdentityTokenSource struct {
+	resource string
+	clientID string
+}
+
+func (s managedIdentityTokenSource) Token() (*oauth2.Token, error) {
+	endpoint, apiVersion := azureIMDSTokenURL, "2018-02-01"
+	header, secret := "Metadata", "true"
+	if identityEndpoint := os.Getenv("IDENTITY_ENDPOINT"); identityEndpoint != "" {
+		endpoint, apiVersion = identityEndpoint, "2019-08-01"
+		header, secret = "X-IDENTITY-HEADER", os.Getenv("IDENTITY_HEADER")
+	}
+
+	query := url.Values{"api-version": {apiVersion}, "resource": {s.resource}}
+	if s.clientID != "" {
+		query.Set("client_id", s.clientID)
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(header, secret)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("managed identity endpoint unreachable: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("managed identity endpoint returned %s", resp.Status)
+	}
+
+	// expires_on is a string of Unix seconds in both endpoint versions
+	var body struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresOn   string `json:"expires_on"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode managed identity token: %v", err)
+	}
+	token := &oauth2.Token{AccessToken: body.AccessToken, TokenType: body.TokenType}
+	if expiresOn, err := strconv.ParseInt(body.ExpiresOn, 10, 64); err == nil {
+		token.Expiry = time.Unix(expiresOn, 0)
+	}
+	return token, nil
+}
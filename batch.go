@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// batchDistribution is the --batch-size distribution each request's number of
+// samples is drawn from
+type batchDistribution struct {
+	sizes   []int
+	weights []int // cumulative
+}
+
+// batchSizes is the distribution of the current run
+var batchSizes = batchDistribution{sizes: []int{1}, weights: []int{1}}
+
+// parseBatchSizes parses --batch-size: a fixed size ("8"), a range drawn from
+// uniformly ("1-32"), a list drawn from uniformly ("1,4,8,32") or a weighted
+// list ("1:70,8:20,32:10")
+func parseBatchSizes(spec string) (batchDistribution, error) {
+	var dist batchDistribution
+	add := func(size, weight int) {
+		total := weight
+		if n := len(dist.weights); n > 0 {
+			total += dist.weights[n-1]
+		}
+		dist.sizes = append(dist.sizes, size)
+		dist.weights = append(dist.weights, total)
+	}
+
+	if low, high, ok := strings.Cut(spec, "-"); ok {
+		from, err := parseBatchSize(low)
+		if err != nil {
+			return dist, err
+		}
+		to, err := parseBatchSize(high)
+		if err != nil {
+			return dist, err
+		}
+		if to < from {
+			return dist, fmt.Errorf("empty batch size range %q", spec)
+		}
+		for size := from; size <= to; size++ {
+			add(size, 1)
+		}
+		return dist, nil
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		sizeText, weightText, weighted := strings.Cut(strings.TrimSpace(entry), ":")
+		size, err := parseBatchSize(sizeText)
+		if err != nil {
+			return dist, err
+		}
+		weight := 1
+		if weighted {
+			if weight, err = strconv.Atoi(strings.TrimSpace(weightText)); err != nil || weight <= 0 {
+				return dist, fmt.Errorf("invalid weight %q for batch size %d", weightText, size)
+			}
+		}
+		add(size, weight)
+	}
+	return dist, nil
+}
+
+// parseBatchSize parses one positive batch size
+func parseBatchSize(text string) (int, error) {
+	size, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid batch size %q", text)
+	}
+	return size, nil
+}
+
+// fixed reports whether every request carries a single sample
+func (d batchDistribution) fixed() bool {
+	return len(d.sizes) == 1 && d.sizes[0] == 1
+}
+
+// pick draws a batch size
+func (d batchDistribution) pick(random *rand.Rand) int {
+	if len(d.sizes) == 1 {
+		return d.sizes[0]
+	}
+	n := random.Intn(d.weights[len(d.weights)-1])
+	for i, weight := range d.weights {
+		if n < weight {
+			return d.sizes[i]
+		}
+	}
+	return d.sizes[len(d.sizes)-1]
+}
+
+// batchPixels returns the pixels of every sample in a batch
+func batchPixels(batch []labelledSample) [][]float64 {
+	pixels := make([][]float64, len(batch))
+	for i, sample := range batch {
+		pixels[i] = sample.pixels
+	}
+	return pixels
+}
+
+// flatten concatenates the samples of a batch, for tensors shaped [n, pixels]
+func flatten(batch [][]float64) []float64 {
+	var values []float64
+	for _, sample := range batch {
+		values = append(values, sample...)
+	}
+	return values
+}
+
+// splitRows cuts a flat [rows, n] tensor into rows
+func splitRows(values []float64, rows int) ([][]float64, error) {
+	if rows <= 0 || len(values)%rows != 0 {
+		return nil, fmt.Errorf("%d values do not split into %d rows", len(values), rows)
+	}
+	size := len(values) / rows
+	split := make([][]float64, rows)
+	for i := range split {
+		split[i] = values[i*size : (i+1)*size]
+	}
+	return split, nil
+}
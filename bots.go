@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// activeBots is the number of bots running, guarded by metricsMutex
+var activeBots int
+
+// botPool starts and stops bots while a run is going
+type botPool struct {
+	mu    sync.Mutex
+	stops []chan struct{} // one per running bot, closed to stop it
+	quit  <-chan struct{}
+	start func(index int, quit <-chan struct{})
+}
+
+// newBotPool returns a pool starting bots with start, which must run the bot
+// until its quit channel is closed
+func newBotPool(quit <-chan struct{}, start func(index int, quit <-chan struct{})) *botPool {
+	return &botPool{quit: quit, start: start}
+}
+
+// add starts one more bot. It stops with the run or when removed.
+func (p *botPool) add() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stop := make(chan struct{})
+	botQuit := make(chan struct{})
+	go func() {
+		select {
+		case <-p.quit:
+		case <-stop:
+		}
+		close(botQuit)
+	}()
+	p.start(len(p.stops), botQuit)
+	p.stops = append(p.stops, stop)
+	p.setCount()
+}
+
+// remove stops the bot started last, keeping at least one running
+func (p *botPool) remove() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.stops) <= 1 {
+		return false
+	}
+	last := len(p.stops) - 1
+	close(p.stops[last])
+	p.stops = p.stops[:last]
+	p.setCount()
+	return true
+}
+
+// setCount publishes the number of bots to the metrics table. Callers must
+// hold p.mu.
+func (p *botPool) setCount() {
+	metricsMutex.Lock()
+	activeBots = len(p.stops)
+	metricsMutex.Unlock()
+}
+
+// scaleBots adds (+1) or removes (-1) a bot from the keyboard
+func scaleBots(pool *botPool, direction int) {
+	if direction > 0 {
+		pool.add()
+	} else if !pool.remove() {
+		logToWidget("At least one bot keeps running")
+		return
+	}
+	metricsMutex.Lock()
+	count := activeBots
+	metricsMutex.Unlock()
+	logToWidget(fmt.Sprintf("Running %d bots", count))
+}
+
+// resize adds or removes bots until count are running
+func (p *botPool) resize(count int) {
+	for p.size() < count {
+		p.add()
+	}
+	for p.size() > count {
+		if !p.remove() {
+			return
+		}
+	}
+}
+
+// size is the number of bots running
+func (p *botPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.stops)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// botStats holds the per-bot breakdown shown with --per-bot
+type botStats struct {
+	success    int
+	failed     int
+	latencySum float64
+	maxLatency float64
+	inFlight   int
+	lastAnswer time.Time
+}
+
+var (
+	// perBot shows a metrics row, and Prometheus series, per bot
+	perBot bool
+	// botMetrics is indexed by bot and guarded by metricsMutex
+	botMetrics []botStats
+)
+
+// statsForBot returns the stats of a bot, growing botMetrics as bots are
+// added. Callers must hold metricsMutex.
+func statsForBot(bot int) *botStats {
+	for len(botMetrics) <= bot {
+		botMetrics = append(botMetrics, botStats{})
+	}
+	return &botMetrics[bot]
+}
+
+// recordBotSent counts a request of a bot being sent. Replayed requests
+// (bot -1) belong to no bot.
+func recordBotSent(bot int) {
+	if bot < 0 {
+		return
+	}
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	statsForBot(bot).inFlight++
+}
+
+// recordBotResult counts a finished request of a bot
+func recordBotResult(bot int, success bool, latency float64) {
+	if bot < 0 {
+		return
+	}
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	stats := statsForBot(bot)
+	stats.inFlight--
+	stats.lastAnswer = time.Now()
+	if !success {
+		stats.failed++
+		return
+	}
+	stats.success++
+	stats.latencySum += latency
+	stats.maxLatency = max(stats.maxLatency, latency)
+}
+
+// botRows renders one metrics row per bot, with how long ago it last got an
+// answer so a wedged bot stands out. Callers must hold metricsMutex.
+func botRows() [][]string {
+	if !perBot {
+		return nil
+	}
+	var rows [][]string
+	for bot := range botMetrics {
+		stats := &botMetrics[bot]
+		average := 0.0
+		if stats.success > 0 {
+			average = stats.latencySum / float64(stats.success)
+		}
+		last := "no answer yet"
+		if !stats.lastAnswer.IsZero() {
+			last = fmt.Sprintf("last answer %.1fs ago", time.Since(stats.lastAnswer).Seconds())
+		}
+		rows = append(rows, []string{fmt.Sprintf("Bot %d", bot+1), fmt.Sprintf("ok %d / failed %d, avg %.2f ms, max %.2f ms, %d in flight, %s",
+			stats.success, stats.failed, average, stats.maxLatency, stats.inFlight, last)})
+	}
+	return rows
+}
+
+// writeBotPrometheus writes the per-bot series. Callers must hold
+// metricsMutex.
+func writeBotPrometheus(w io.Writer) {
+	if !perBot {
+		return
+	}
+	fmt.Fprintln(w, "# HELP mnist_bot_bot_requests_total Requests finished, by bot and result.")
+	fmt.Fprintln(w, "# TYPE mnist_bot_bot_requests_total counter")
+	for bot, stats := range botMetrics {
+		fmt.Fprintf(w, "mnist_bot_bot_requests_total{bot=\"%d\",result=\"success\"} %d\n", bot+1, stats.success)
+		fmt.Fprintf(w, "mnist_bot_bot_requests_total{bot=\"%d\",result=\"failure\"} %d\n", bot+1, stats.failed)
+	}
+	fmt.Fprintln(w, "# HELP mnist_bot_bot_latency_seconds_total Total latency of successful requests, by bot.")
+	fmt.Fprintln(w, "# TYPE mnist_bot_bot_latency_seconds_total counter")
+	for bot, stats := range botMetrics {
+		fmt.Fprintf(w, "mnist_bot_bot_latency_seconds_total{bot=\"%d\"} %g\n", bot+1, stats.latencySum/1000)
+	}
+	fmt.Fprintln(w, "# HELP mnist_bot_bot_in_flight Requests being sent, by bot.")
+	fmt.Fprintln(w, "# TYPE mnist_bot_bot_in_flight gauge")
+	for bot, stats := range botMetrics {
+		fmt.Fprintf(w, "mnist_bot_bot_in_flight{bot=\"%d\"} %d\n", bot+1, stats.inFlight)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import "fmt"
+
+// minErrorRateRequests is how many requests must have finished before
+// --max-error-rate is checked, so one early failure doesn't end the run
+const minErrorRateRequests = 20
+
+var (
+	// maxRequests, maxErrors and maxErrorRate end the run once reached (0
+	// means no limit)
+	maxRequests, maxErrors int
+	maxErrorRate           float64
+	// budgetExhausted is closed once a limit is reached, with budgetReason
+	// saying which
+	budgetExhausted = make(chan struct{})
+	budgetReason    string
+)
+
+// checkBudgetLimits validates --max-requests, --max-errors and
+// --max-error-rate
+func checkBudgetLimits() error {
+	if maxRequests < 0 || maxErrors < 0 {
+		return fmt.Errorf("--max-requests and --max-errors must not be negative")
+	}
+	if maxErrorRate < 0 || maxErrorRate > 1 {
+		return fmt.Errorf("--max-error-rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// checkBudget closes budgetExhausted once a limit is reached. Callers must
+// hold metricsMutex.
+func checkBudget() {
+	if budgetReason != "" {
+		return
+	}
+	finished := successRequests + failedRequests
+	switch {
+	case maxRequests > 0 && finished >= maxRequests:
+		budgetReason = fmt.Sprintf("%d requests finished (--max-requests)", finished)
+	case maxErrors > 0 && failedRequests >= maxErrors:
+		budgetReason = fmt.Sprintf("%d requests failed (--max-errors)", failedRequests)
+	case maxErrorRate > 0 && finished >= minErrorRateRequests && float64(failedRequests)/float64(finished) > maxErrorRate:
+		budgetReason = fmt.Sprintf("error rate of %.2f%% over %.2f%% (--max-error-rate)", 100*float64(failedRequests)/float64(finished), 100*maxErrorRate)
+	default:
+		return
+	}
+	close(budgetExhausted)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// burst is a number of requests each bot sends back to back, every period,
+// to exercise server-side batching and queueing
+type burst struct {
+	size   int
+	period time.Duration
+}
+
+// botBurst, when set, replaces the one request per --interval of each bot
+var botBurst *burst
+
+// parseBurst parses --bursts: SIZE/PERIOD, e.g. "20/10s" for 20 requests
+// every 10 seconds
+func parseBurst(spec string) (*burst, error) {
+	sizeText, periodText, ok := strings.Cut(spec, "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid burst %q (expected SIZE/PERIOD, e.g. 20/10s)", spec)
+	}
+	size, err := strconv.Atoi(sizeText)
+	if err != nil || size < 1 {
+		return nil, fmt.Errorf("invalid burst size %q (expected a positive number)", sizeText)
+	}
+	period, err := time.ParseDuration(periodText)
+	if err != nil || period <= 0 {
+		return nil, fmt.Errorf("invalid burst period %q (expected a positive duration)", periodText)
+	}
+	return &burst{size: size, period: period}, nil
+}
+
+// requestsPerTick is how many requests a bot sends each time it's due: the
+// --bursts size, else one
+func requestsPerTick() int {
+	if botBurst == nil {
+		return 1
+	}
+	return botBurst.size
+}
+
+// String describes the burst for the log
+func (b *burst) String() string {
+	return fmt.Sprintf("%d requests every %s", b.size, b.period)
+}
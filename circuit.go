@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	// circuitErrorRate opens an endpoint's circuit once this share of its
+	// last circuitWindow requests failed (0 disables the breaker)
+	circuitErrorRate float64
+	circuitWindow    int
+	// circuitCooldown is how long an open circuit waits before letting a
+	// probe request through
+	circuitCooldown time.Duration
+
+	// circuits and skippedRequests are guarded by metricsMutex
+	circuits        = map[string]*circuitState{}
+	skippedRequests int
+)
+
+// circuitState is the breaker of one endpoint. It is closed (sending) while
+// openedAt is zero, open (paused) until the cooldown is over, and then
+// half-open while one probe is in flight. A probe that never comes back is
+// replaced after another cooldown.
+type circuitState struct {
+	results  []bool // outcomes of the last circuitWindow requests, oldest first
+	openedAt time.Time
+	probeAt  time.Time
+}
+
+// checkCircuitOptions validates the --circuit-* flags
+func checkCircuitOptions() error {
+	if circuitErrorRate < 0 || circuitErrorRate > 1 {
+		return fmt.Errorf("--circuit-error-rate must be between 0 and 1")
+	}
+	if circuitWindow <= 0 || circuitCooldown <= 0 {
+		return fmt.Errorf("--circuit-window and --circuit-cooldown must be positive")
+	}
+	return nil
+}
+
+// allowRequest reports whether a request may be sent to the endpoint: always
+// while its circuit is closed, and once per cooldown as a probe while it is
+// open. Skipped requests are counted.
+func allowRequest(endpoint string) bool {
+	if circuitErrorRate == 0 {
+		return true
+	}
+
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	circuit, ok := circuits[endpoint]
+	if !ok || circuit.openedAt.IsZero() {
+		return true
+	}
+	if time.Since(circuit.openedAt) >= circuitCooldown && (circuit.probeAt.IsZero() || time.Since(circuit.probeAt) >= circuitCooldown) {
+		circuit.probeAt = time.Now()
+		logToWidget(fmt.Sprintf("Circuit of %s half-open, sending a probe", endpoint))
+		return true
+	}
+	skippedRequests++
+	return false
+}
+
+// noteCircuit records a result in the endpoint's circuit, opening it when
+// the error rate crosses the threshold and closing it when a probe succeeds.
+// Callers must hold metricsMutex.
+func noteCircuit(endpoint string, ok bool) {
+	if circuitErrorRate == 0 {
+		return
+	}
+
+	circuit, found := circuits[endpoint]
+	if !found {
+		circuit = &circuitState{}
+		circuits[endpoint] = circuit
+	}
+	if !circuit.openedAt.IsZero() {
+		if circuit.probeAt.IsZero() {
+			return // sent before the circuit opened
+		}
+		circuit.probeAt = time.Time{}
+		if ok {
+			circuit.openedAt = time.Time{}
+			circuit.results = nil
+			logToWidget(fmt.Sprintf("Probe of %s succeeded, circuit closed", endpoint))
+		} else {
+			circuit.openedAt = time.Now()
+			logToWidget(fmt.Sprintf("Probe of %s failed, circuit stays open for %s", endpoint, circuitCooldown))
+		}
+		return
+	}
+
+	circuit.results = append(circuit.results, ok)
+	if len(circuit.results) > circuitWindow {
+		circuit.results = circuit.results[1:]
+	}
+	if len(circuit.results) < circuitWindow {
+		return
+	}
+	failed := 0
+	for _, result := range circuit.results {
+		if !result {
+			failed++
+		}
+	}
+	if rate := float64(failed) / float64(circuitWindow); rate > circuitErrorRate {
+		circuit.openedAt = time.Now()
+		logToWidget(fmt.Sprintf("%.0f%% of the last %d requests to %s failed, circuit open for %s", 100*rate, circuitWindow, endpoint, circuitCooldown))
+	}
+}
+
+// circuitRows renders the state of every circuit. Callers must hold
+// metricsMutex.
+func circuitRows() [][]string {
+	if circuitErrorRate == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(circuits))
+	for endpoint := range circuits {
+		names = append(names, endpoint)
+	}
+	sort.Strings(names)
+
+	var rows [][]string
+	for _, endpoint := range names {
+		circuit := circuits[endpoint]
+		state := "closed"
+		switch {
+		case !circuit.probeAt.IsZero():
+			state = "half-open"
+		case !circuit.openedAt.IsZero():
+			wait := max(0, circuitCooldown-time.Since(circuit.openedAt))
+			state = fmt.Sprintf("open, probing in %s", wait.Round(time.Second))
+		}
+		name := "Circuit"
+		if len(allEndpoints()) > 1 {
+			name += " " + endpoint
+		}
+		rows = append(rows, []string{name, state})
+	}
+	if skippedRequests > 0 {
+		rows = append(rows, []string{"Skipped (Circuit Open)", fmt.Sprintf("%d", skippedRequests)})
+	}
+	return rows
+}
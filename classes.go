@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parseClasses parses --classes, a comma-separated list of digits
+func parseClasses(spec string) (map[int]bool, error) {
+	classes := map[int]bool{}
+	for _, field := range strings.Split(spec, ",") {
+		digit, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || digit < 0 || digit > 9 {
+			return nil, fmt.Errorf("invalid class %q (expected a digit from 0 to 9)", field)
+		}
+		classes[digit] = true
+	}
+	return classes, nil
+}
+
+// filterClasses returns the indexes of the samples labelled with one of the
+// classes
+func filterClasses(labels []int, classes map[int]bool) ([]int, error) {
+	if labels == nil {
+		return nil, fmt.Errorf("--classes needs labelled data")
+	}
+	var kept []int
+	for i, label := range labels {
+		if classes[label] {
+			kept = append(kept, i)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("no samples labelled %s", joinDigits(sortedClasses(classes)))
+	}
+	return kept, nil
+}
+
+// sortedClasses lists the classes in order
+func sortedClasses(classes map[int]bool) []int {
+	var digits []int
+	for digit := range classes {
+		digits = append(digits, digit)
+	}
+	sort.Ints(digits)
+	return digits
+}
+
+// groupByClass splits the positions of a pool by the label of their sample,
+// in class order
+func groupByClass(size int, index func(position int) int) [][]int {
+	byLabel := map[int][]int{}
+	for position := 0; position < size; position++ {
+		label := mnistLabels[index(position)]
+		byLabel[label] = append(byLabel[label], position)
+	}
+	var labels []int
+	for label := range byLabel {
+		labels = append(labels, label)
+	}
+	sort.Ints(labels)
+	groups := make([][]int, len(labels))
+	for i, label := range labels {
+		groups[i] = byLabel[label]
+	}
+	return groups
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// requestCompression compresses REST request bodies: none, gzip or zstd
+var requestCompression string
+
+var (
+	// zstdEncoder is shared by all bots; EncodeAll is safe for concurrent use
+	zstdEncoder *zstd.Encoder
+
+	// request body sizes before and after compression, and response body
+	// sizes, under metricsMutex
+	uncompressedBytes int64
+	compressedBytes   int64
+	responseBytes     int64
+)
+
+// setupCompression validates --compress-requests and prepares its encoder
+func setupCompression() error {
+	switch requestCompression {
+	case "none", "gzip":
+		return nil
+	case "zstd":
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		zstdEncoder = encoder
+		return nil
+	}
+	return fmt.Errorf("unknown --compress-requests mode %q (expected none, gzip or zstd)", requestCompression)
+}
+
+// compressBody compresses a request body, returning it unchanged when
+// compression is off
+func compressBody(body []byte) ([]byte, error) {
+	switch requestCompression {
+	case "gzip":
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(body); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "zstd":
+		return zstdEncoder.EncodeAll(body, nil), nil
+	}
+	return body, nil
+}
+
+// recordBodyBytes counts the size of a request body before and after
+// compression
+func recordBodyBytes(uncompressed, compressed int) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	uncompressedBytes += int64(uncompressed)
+	compressedBytes += int64(compressed)
+}
+
+// recordResponseBytes counts the size of a response body
+func recordResponseBytes(size int) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	responseBytes += int64(size)
+}
+
+// bandwidthRows are the metrics rows of the bytes sent and received, and
+// their rate over the run. Callers must hold metricsMutex.
+func bandwidthRows() [][]string {
+	if compressedBytes == 0 && responseBytes == 0 {
+		return nil
+	}
+	sent := fmt.Sprintf("%d", compressedBytes)
+	if requestCompression != "none" && uncompressedBytes > 0 {
+		ratio := 100 * float64(compressedBytes) / float64(uncompressedBytes)
+		sent = fmt.Sprintf("%d (%d uncompressed, %.1f%%)", compressedBytes, uncompressedBytes, ratio)
+	}
+	rows := [][]string{{"Request Bytes", sent}, {"Response Bytes", fmt.Sprintf("%d", responseBytes)}}
+	if !runStarted.IsZero() {
+		seconds := runEnd().Sub(runStarted).Seconds()
+		rows = append(rows, []string{"Bandwidth", fmt.Sprintf("%.2f MB/s sent, %.2f MB/s received", float64(compressedBytes)/seconds/1e6, float64(responseBytes)/seconds/1e6)})
+	}
+	return rows
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// registerDataFlags registers the options of the dataset loaders, shared by
+// the bot and the convert command
+func registerDataFlags(flags *flag.FlagSet) {
+	flags.StringVar(&dataCacheDir, "data-cache", "", "Directory caching datasets fetched from http(s)://, s3:// or gs:// URLs (defaults to the user cache directory)")
+	flags.BoolVar(&csvLabelColumn, "csv-label-column", false, "The first column of CSV data is the digit label")
+	flags.StringVar(&labelsFile, "labels", "", "File with the label of each sample (whitespace- or comma-separated, or a JSON array)")
+	flags.StringVar(&h5Dataset, "h5-dataset", "", "HDF5 dataset holding the images (defaults to x_test, x, images or x_train)")
+	flags.StringVar(&h5Labels, "h5-labels", "", "HDF5 dataset holding the labels (defaults to the y_* or labels dataset matching the images)")
+	flags.StringVar(&parquetColumn, "parquet-column", "", "Parquet column holding the pixels (defaults to the first list column, or every numeric column)")
+	flags.StringVar(&parquetLabelColumn, "parquet-label-column", parquetLabelColumnName, "Parquet column holding the labels, if present")
+	flags.StringVar(&npzKey, "npz-key", "", "Array in an .npz archive holding the samples (defaults to x_test, x, images or x_train)")
+	flags.BoolVar(&resizeImages, "resize-images", false, "Resize images in a data directory to 28x28")
+	flags.BoolVar(&invertImages, "invert-images", false, "Invert images in a data directory (for dark digits on a light background)")
+	flags.StringVar(&normalizeMode, "normalize", "none", "Rescale pixels after loading (none, raw for 0-255, scaled for 0-1, or standardize)")
+	flags.Float64Var(&normalizeMean, "normalize-mean", 0.1307, "Pixel mean subtracted by --normalize standardize (on the 0-1 scale)")
+	flags.Float64Var(&normalizeStd, "normalize-std", 0.3081, "Pixel standard deviation divided by in --normalize standardize")
+	flags.StringVar(&inputScale, "input-scale", "auto", "Scale of the loaded pixels (raw for 0-255, scaled for 0-1, or auto to detect it once per dataset)")
+}
+
+// runConvert implements the convert subcommand: it loads a dataset with any
+// of the bot's loaders and writes it as JSON, JSONL, CSV or Parquet
+func runConvert(args []string) {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	from := flags.String("from", "", "Input format (csv, json, jsonl, idx, numpy, parquet, hdf5 or images; detected from the file name by default)")
+	to := flags.String("to", "json", "Output format (json, jsonl, csv or parquet)")
+	in := flags.String("in", "", "Dataset to convert (a path or an http(s)://, s3:// or gs:// URL)")
+	out := flags.String("out", "", "Converted file (defaults to the input name with the output format's extension)")
+	registerDataFlags(flags)
+	flags.Parse(args)
+
+	if *in == "" {
+		logger.Fatalf("--in is required")
+	}
+	if *out == "" {
+		base := *in
+		if u, err := url.Parse(base); err == nil && isRemoteData(base) {
+			// write to the working directory, not to a URL-shaped path
+			base = path.Base("/" + u.Path)
+		}
+		base = strings.TrimSuffix(strings.TrimSuffix(base, ".gz"), "/")
+		if dot := strings.LastIndexByte(base, '.'); dot > strings.LastIndexByte(base, '/') {
+			base = base[:dot]
+		}
+		*out = base + "." + *to
+	}
+	if sameFile(*in, *out) {
+		// e.g. "--in x.json --to json", which would overwrite the input
+		logger.Fatalf("--out %s is the input file; give another --out", *out)
+	}
+
+	if err := checkNormalizeMode(); err != nil {
+		logger.Fatalf("%v", err)
+	}
+
+	local, err := resolveDataFile(*in)
+	if err != nil {
+		logger.Fatalf("Failed to fetch %s: %v", *in, err)
+	}
+	samples, labels, err := loadDataset(local, *from)
+	if err != nil {
+		logger.Fatalf("Failed to load %s: %v", *in, err)
+	}
+	normalizeSamples(samples)
+
+	switch *to {
+	case "json":
+		err = writeJSONSamples(*out, samples)
+	case "jsonl":
+		err = writeJSONLSamples(*out, samples)
+	case "csv":
+		err = writeCSVSamples(*out, samples, labels)
+	case "parquet":
+		err = writeParquetSamples(*out, samples, labels)
+	default:
+		logger.Fatalf("Unknown output format %q (expected json, jsonl, csv or parquet)", *to)
+	}
+	if err != nil {
+		logger.Fatalf("Failed to write %s: %v", *out, err)
+	}
+	logger.Infof("Wrote %d samples to %s", len(samples), *out)
+
+	if labels != nil && (*to == "json" || *to == "jsonl") {
+		// JSON samples have no room for labels, so they go next to them
+		labelsOut := strings.TrimSuffix(*out, "."+*to) + "-labels.json"
+		if err := writeJSONLabels(labelsOut, labels); err != nil {
+			logger.Fatalf("Failed to write %s: %v", labelsOut, err)
+		}
+		logger.Infof("Wrote the labels to %s; load them with --labels %s", labelsOut, labelsOut)
+	}
+}
+
+// sameFile reports whether two paths name the same file
+func sameFile(a, b string) bool {
+	if filepath.Clean(a) == filepath.Clean(b) {
+		return true
+	}
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+// formatPixel formats a pixel value for the text formats
+func formatPixel(pixel float64) string {
+	return strconv.FormatFloat(pixel, 'g', 8, 64)
+}
+
+// writeTextFile creates path and fills it through a buffered writer
+func writeTextFile(path string, write func(w *bufio.Writer)) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	write(writer)
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// writeJSONSamples writes samples as a JSON array of pixel arrays, the format
+// of Assets/Data/data.json
+func writeJSONSamples(path string, samples [][]float64) error {
+	return writeTextFile(path, func(w *bufio.Writer) {
+		w.WriteString("[\n")
+		for i, sample := range samples {
+			w.WriteByte('\t')
+			writeJSONArray(w, sample)
+			if i < len(samples)-1 {
+				w.WriteByte(',')
+			}
+			w.WriteByte('\n')
+		}
+		w.WriteString("]\n")
+	})
+}
+
+// writeJSONLSamples writes one JSON pixel array per line
+func writeJSONLSamples(path string, samples [][]float64) error {
+	return writeTextFile(path, func(w *bufio.Writer) {
+		for _, sample := range samples {
+			writeJSONArray(w, sample)
+			w.WriteByte('\n')
+		}
+	})
+}
+
+// writeJSONArray writes the pixels of one sample as a JSON array
+func writeJSONArray(w *bufio.Writer, sample []float64) {
+	w.WriteByte('[')
+	for i, pixel := range sample {
+		if i > 0 {
+			w.WriteString(", ")
+		}
+		w.WriteString(formatPixel(pixel))
+	}
+	w.WriteByte(']')
+}
+
+// writeJSONLabels writes labels as a JSON array, readable with --labels
+func writeJSONLabels(path string, labels []int) error {
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// writeCSVSamples writes one sample per row. Labelled samples get the label
+// in the first column under a "label,pixel0,..." header, which the loader
+// recognizes.
+func writeCSVSamples(path string, samples [][]float64, labels []int) error {
+	return writeTextFile(path, func(w *bufio.Writer) {
+		if labels != nil && len(samples) > 0 {
+			w.WriteString("label")
+			for i := range samples[0] {
+				fmt.Fprintf(w, ",pixel%d", i)
+			}
+			w.WriteByte('\n')
+		}
+		for i, sample := range samples {
+			if labels != nil {
+				w.WriteString(strconv.Itoa(labels[i]))
+				w.WriteByte(',')
+			}
+			for j, pixel := range sample {
+				if j > 0 {
+					w.WriteByte(',')
+				}
+				w.WriteString(formatPixel(pixel))
+			}
+			w.WriteByte('\n')
+		}
+	})
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cronSchedule is a standard five-field cron expression: minute, hour, day of
+// month, month and day of week (0 or 7 is Sunday)
+type cronSchedule struct {
+	minutes, hours, days, months, weekdays map[int]bool
+	// with both day fields restricted, either one matching is enough
+	anyDay bool
+}
+
+// parseCron parses a cron expression such as "0 2 * * 1-5" or "*/30 * * * *"
+func parseCron(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q (expected 5 fields)", spec)
+	}
+	ranges := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var sets [5]map[int]bool
+	for i, field := range fields {
+		set, err := parseCronField(field, ranges[i][0], ranges[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", spec, err)
+		}
+		sets[i] = set
+	}
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+	schedule := &cronSchedule{
+		minutes:  sets[0],
+		hours:    sets[1],
+		days:     sets[2],
+		months:   sets[3],
+		weekdays: sets[4],
+		anyDay:   fields[2] != "*" && fields[4] != "*",
+	}
+	// e.g. "0 0 30 2 *", as February never has a 30th
+	if schedule.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("invalid cron expression %q: it never fires", spec)
+	}
+	return schedule, nil
+}
+
+// parseCronField parses one field: "*", numbers, ranges ("1-5") and steps
+// ("*/15", "0-30/10"), separated by commas
+func parseCronField(field string, low, high int) (map[int]bool, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		rangeText, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step %q", part)
+			}
+		}
+		first, last := low, high
+		if rangeText != "*" {
+			fromText, toText, isRange := strings.Cut(rangeText, "-")
+			from, err := strconv.Atoi(fromText)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			first, last = from, from
+			if isRange {
+				if last, err = strconv.Atoi(toText); err != nil {
+					return nil, fmt.Errorf("invalid range %q", part)
+				}
+			} else if hasStep {
+				last = high
+			}
+		}
+		if first < low || last > high || first > last {
+			return nil, fmt.Errorf("%q is out of range %d-%d", part, low, high)
+		}
+		for value := first; value <= last; value += step {
+			set[value] = true
+		}
+	}
+	return set, nil
+}
+
+// next returns the first time after t the schedule fires, to the minute
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// every schedule fires within a few years, e.g. on February 29
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		if !c.months[int(t.Month())] || !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// matchesDay checks the day of month and day of week fields
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	day, weekday := c.days[t.Day()], c.weekdays[int(t.Weekday())]
+	if c.anyDay {
+		return day || weekday
+	}
+	return day && weekday
+}
+
+// parseStartAt parses --start-at: "HH:MM" for the next time the clock shows
+// it, or an RFC 3339 time
+func parseStartAt(spec string, now time.Time) (time.Time, error) {
+	if at, err := time.Parse(time.RFC3339, spec); err == nil {
+		return at, nil
+	}
+	clock, err := time.Parse("15:04", spec)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --start-at %q (expected HH:MM or an RFC 3339 time)", spec)
+	}
+	at := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at, nil
+}
+
+// waitUntil sleeps until the start of a run
+func waitUntil(at time.Time) {
+	if wait := time.Until(at); wait > 0 {
+		fmt.Printf("Waiting until %s to start (in %s)...\n", at.Format("2006-01-02 15:04"), wait.Round(time.Second))
+		time.Sleep(wait)
+	}
+}
+
+// runRecurring starts a run every time the cron schedule fires, each in a
+// child process with the same flags, archiving its results and summary in a
+// directory of archiveDir named after its start time. It returns once
+// interrupted with Ctrl+C.
+func runRecurring(schedule *cronSchedule, spec, archiveDir string) {
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, syscall.SIGINT, syscall.SIGTERM)
+	for run := 1; ; run++ {
+		at := schedule.next(time.Now())
+		if at.IsZero() {
+			logger.Errorf("Cron expression %q never fires again, stopping", spec)
+			return
+		}
+		fmt.Printf("Next run (%s) at %s\n", spec, at.Format("2006-01-02 15:04"))
+		select {
+		case <-time.After(time.Until(at)):
+		case <-interrupted:
+			return
+		}
+
+		dir := filepath.Join(archiveDir, at.Format("20060102-150405"))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			logger.Errorf("Failed to create archive directory: %v", err)
+			continue
+		}
+		args := append(childArgs(os.Args[1:]),
+			"--results", filepath.Join(dir, "results.jsonl"),
+			"--summary-file", filepath.Join(dir, "summary.txt"))
+		cmd := exec.Command(os.Args[0], args...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		err := cmd.Run()
+		if err != nil {
+			fmt.Printf("Run %d ended with %v, archived in %s\n", run, err, dir)
+		} else {
+			fmt.Printf("Run %d archived in %s\n", run, dir)
+		}
+
+		// Ctrl+C during a run stops the run and the schedule
+		select {
+		case <-interrupted:
+			return
+		default:
+		}
+	}
+}
+
+// childArgs drops the scheduling flags, and --results and --summary-file
+// which are set per run, from the command line
+func childArgs(args []string) []string {
+	scheduling := map[string]bool{"cron": true, "start-at": true, "archive": true, "results": true, "summary-file": true}
+	var kept []string
+	for i := 0; i < len(args); i++ {
+		name := strings.TrimLeft(args[i], "-")
+		name, _, hasValue := strings.Cut(name, "=")
+		if !strings.HasPrefix(args[i], "-") || !scheduling[name] {
+			kept = append(kept, args[i])
+			continue
+		}
+		if !hasValue {
+			i++ // skip the value
+		}
+	}
+	return kept
+}
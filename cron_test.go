@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	schedule, err := parseCron("0 0 29 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	if got, want := schedule.next(from), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestParseCronNeverFires(t *testing.T) {
+	for _, spec := range []string{"0 0 30 2 *", "0 0 31 4,6,9,11 *"} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parsing %q, which never fires, succeeded", spec)
+		}
+	}
+}
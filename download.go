@@ -0,0 +1,213 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/md5"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// datasetSource is a dataset published as gzip-compressed IDX files
+type datasetSource struct {
+	baseURL string
+	// checksums maps every file to its MD5 checksum
+	checksums map[string]string
+}
+
+// datasetSources are the IDX datasets the download command knows about
+var datasetSources = map[string]datasetSource{
+	"mnist": {
+		baseURL: "https://ossci-datasets.s3.amazonaws.com/mnist/",
+		checksums: map[string]string{
+			"train-images-idx3-ubyte.gz": "f68b3c2dcbeaaa9fbdd348bbdeb94873",
+			"train-labels-idx1-ubyte.gz": "d53e105ee54ea40749a09fcbcd1e9432",
+			"t10k-images-idx3-ubyte.gz":  "9fb629c4189551a2d022fa330f9573f3",
+			"t10k-labels-idx1-ubyte.gz":  "ec29112dd5afa0611ce80d1b7f02629c",
+		},
+	},
+	"fashion": {
+		baseURL: "http://fashion-mnist.s3-website.eu-central-1.amazonaws.com/",
+		checksums: map[string]string{
+			"train-images-idx3-ubyte.gz": "8d4fb7e6c68d591d4c3dfef9ec88bf0d",
+			"train-labels-idx1-ubyte.gz": "25c81989df183df01b3e8a0aad5dffbe",
+			"t10k-images-idx3-ubyte.gz":  "bef4ecab320f06d8554ea6380940ec79",
+			"t10k-labels-idx1-ubyte.gz":  "bb300cfdad3c16e7a12a480ee83cd310",
+		},
+	},
+}
+
+// EMNIST ships every split in one archive of gzip-compressed IDX files
+const (
+	emnistURL = "https://biometrics.nist.gov/cs_links/EMNIST/gzip.zip"
+	emnistMD5 = "58c8d27c78d21e728a6bc7b3cc06412e"
+)
+
+// emnistSplits are the splits of the EMNIST archive
+var emnistSplits = map[string]bool{
+	"digits": true, "mnist": true, "letters": true,
+	"balanced": true, "byclass": true, "bymerge": true,
+}
+
+// runDownload implements the download subcommand: it fetches a dataset,
+// verifies its checksums and converts it into a labelled CSV file the bot
+// can load with --data
+func runDownload(args []string) {
+	flags := flag.NewFlagSet("download", flag.ExitOnError)
+	dataset := flags.String("dataset", "mnist", "Dataset to download (mnist, fashion or emnist)")
+	split := flags.String("split", "digits", "EMNIST split (digits, mnist, letters, balanced, byclass or bymerge)")
+	set := flags.String("set", "test", "Which part of the dataset to convert (test or train)")
+	dir := flags.String("dir", "./Assets/Data", "Directory the archives and the converted file are written to")
+	out := flags.String("out", "", "Converted CSV file (defaults to <dir>/<dataset>-<set>.csv)")
+	flags.Parse(args)
+
+	prefix := map[string]string{"test": "t10k", "train": "train"}[*set]
+	if prefix == "" {
+		logger.Fatalf("Unknown set %q (expected test or train)", *set)
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		logger.Fatalf("Failed to create %s: %v", *dir, err)
+	}
+
+	var imagesFile, name string
+	switch *dataset {
+	case "mnist", "fashion":
+		source := datasetSources[*dataset]
+		for _, kind := range []string{"images-idx3", "labels-idx1"} {
+			file := fmt.Sprintf("%s-%s-ubyte.gz", prefix, kind)
+			path := filepath.Join(*dir, *dataset+"-"+file)
+			if err := fetchFile(source.baseURL+file, path, source.checksums[file]); err != nil {
+				logger.Fatalf("Failed to download %s: %v", file, err)
+			}
+		}
+		imagesFile = filepath.Join(*dir, fmt.Sprintf("%s-%s-images-idx3-ubyte.gz", *dataset, prefix))
+		name = fmt.Sprintf("%s-%s", *dataset, *set)
+	case "emnist":
+		if !emnistSplits[*split] {
+			logger.Fatalf("Unknown EMNIST split %q", *split)
+		}
+		archive := filepath.Join(*dir, "emnist-gzip.zip")
+		if err := fetchFile(emnistURL, archive, emnistMD5); err != nil {
+			logger.Fatalf("Failed to download EMNIST: %v", err)
+		}
+		for _, kind := range []string{"images-idx3", "labels-idx1"} {
+			file := fmt.Sprintf("emnist-%s-%s-%s-ubyte.gz", *split, *set, kind)
+			if err := extractZipFile(archive, "gzip/"+file, filepath.Join(*dir, file)); err != nil {
+				logger.Fatalf("Failed to extract %s: %v", file, err)
+			}
+		}
+		imagesFile = filepath.Join(*dir, fmt.Sprintf("emnist-%s-%s-images-idx3-ubyte.gz", *split, *set))
+		name = fmt.Sprintf("emnist-%s-%s", *split, *set)
+	default:
+		logger.Fatalf("Unknown dataset %q (expected mnist, fashion or emnist)", *dataset)
+	}
+
+	samples, labels, err := loadIDXDataset(imagesFile)
+	if err != nil {
+		logger.Fatalf("Failed to read %s: %v", imagesFile, err)
+	}
+	if *dataset == "emnist" {
+		// EMNIST images are stored transposed relative to MNIST
+		for _, sample := range samples {
+			transposeImage(sample)
+		}
+	}
+
+	if *out == "" {
+		*out = filepath.Join(*dir, name+".csv")
+	}
+	if err := writeCSVSamples(*out, samples, labels); err != nil {
+		logger.Fatalf("Failed to write %s: %v", *out, err)
+	}
+	logger.Infof("Wrote %d labelled samples to %s; start the bot with --data %s", len(samples), *out, *out)
+}
+
+// fetchFile downloads url to path unless a file with the expected MD5
+// checksum is already there
+func fetchFile(url, path, checksum string) error {
+	if sum, err := fileMD5(path); err == nil && sum == checksum {
+		logger.Infof("Using cached %s", path)
+		return nil
+	}
+
+	logger.Infof("Downloading %s", url)
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	partial := path + ".part"
+	file, err := os.Create(partial)
+	if err != nil {
+		return err
+	}
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partial)
+		return err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != checksum {
+		os.Remove(partial)
+		return fmt.Errorf("checksum mismatch for %s: got %s, expected %s", url, sum, checksum)
+	}
+	return os.Rename(partial, path)
+}
+
+// fileMD5 returns the hex MD5 checksum of a file
+func fileMD5(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// extractZipFile copies one member of a zip archive to path
+func extractZipFile(archive, member, path string) error {
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	src, err := reader.Open(member)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// transposeImage swaps the rows and columns of a square image in place
+func transposeImage(sample []float64) {
+	for y := 0; y < mnistSide; y++ {
+		for x := y + 1; x < mnistSide; x++ {
+			sample[y*mnistSide+x], sample[x*mnistSide+y] = sample[x*mnistSide+y], sample[y*mnistSide+x]
+		}
+	}
+}
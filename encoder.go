@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// Encoder turns a batch of samples into a request body
+type Encoder interface {
+	Encode(ctx context.Context, batch [][]float64) ([]byte, error)
+	ContentType() string
+}
+
+// encoder builds the request bodies of the current run
+var encoder Encoder
+
+// formField is the form field holding the image in multipart uploads
+var formField string
+
+// payloadFormats lists the --payload-format values
+var payloadFormats = map[string]func() (Encoder, error){
+	"target": func() (Encoder, error) {
+		return adapterEncoder{target}, nil
+	},
+	"v2": func() (Encoder, error) {
+		return adapterEncoder{targetAdapters["triton"]}, nil
+	},
+	"b64": func() (Encoder, error) {
+		return b64ImageEncoder{}, nil
+	},
+	"multipart": func() (Encoder, error) {
+		if formField == "" {
+			return nil, fmt.Errorf("--form-field must not be empty")
+		}
+		return multipartEncoder{field: formField, boundary: multipart.NewWriter(nil).Boundary()}, nil
+	},
+	"envelope": func() (Encoder, error) {
+		if requestEnvelope == nil {
+			return nil, fmt.Errorf("the envelope payload format needs --envelope")
+		}
+		return envelopeEncoder{requestEnvelope}, nil
+	},
+	"template": func() (Encoder, error) {
+		if bodyTemplate == nil {
+			return nil, fmt.Errorf("the template payload format needs --body-template")
+		}
+		return templateEncoder{bodyTemplate}, nil
+	},
+}
+
+// newEncoder returns the Encoder for a --payload-format value
+func newEncoder(format string) (Encoder, error) {
+	newFormat, ok := payloadFormats[format]
+	if !ok {
+		names := make([]string, 0, len(payloadFormats))
+		for name := range payloadFormats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown payload format %q (expected %s)", format, strings.Join(names, ", "))
+	}
+	return newFormat()
+}
+
+// adapterEncoder encodes samples in a target type's request format
+type adapterEncoder struct {
+	adapter targetAdapter
+}
+
+func (e adapterEncoder) Encode(_ context.Context, batch [][]float64) ([]byte, error) {
+	return e.adapter.buildPayload(batch)
+}
+
+func (e adapterEncoder) ContentType() string {
+	return e.adapter.contentType
+}
+
+// b64ImageEncoder sends each sample as a base64-encoded PNG in the TF Serving
+// {"instances": [{"b64": ...}]} format, for signatures taking encoded images
+type b64ImageEncoder struct{}
+
+// b64Instance is a binary value in the TF Serving REST API
+type b64Instance struct {
+	B64 string `json:"b64"`
+}
+
+func (b64ImageEncoder) Encode(_ context.Context, batch [][]float64) ([]byte, error) {
+	instances := make([]b64Instance, len(batch))
+	for i, sample := range batch {
+		image, err := encodeSamplePNG(sample, rawPixels)
+		if err != nil {
+			return nil, err
+		}
+		instances[i].B64 = base64.StdEncoding.EncodeToString(image)
+	}
+	return json.Marshal(struct {
+		SignatureName string        `json:"signature_name,omitempty"`
+		Instances     []b64Instance `json:"instances"`
+	}{signatureName, instances})
+}
+
+func (b64ImageEncoder) ContentType() string {
+	return "application/json"
+}
+
+// multipartEncoder uploads each sample as a PNG file in a multipart/form-data
+// body, like a browser form would. Batches repeat the field once per sample.
+type multipartEncoder struct {
+	field    string
+	boundary string
+}
+
+func (e multipartEncoder) Encode(_ context.Context, batch [][]float64) ([]byte, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err := form.SetBoundary(e.boundary); err != nil {
+		return nil, err
+	}
+	for i, sample := range batch {
+		image, err := encodeSamplePNG(sample, rawPixels)
+		if err != nil {
+			return nil, err
+		}
+		filename := "digit.png"
+		if len(batch) > 1 {
+			filename = fmt.Sprintf("digit-%d.png", i)
+		}
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, e.field, filename))
+		header.Set("Content-Type", "image/png")
+		part, err := form.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		part.Write(image)
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+func (e multipartEncoder) ContentType() string {
+	return "multipart/form-data; boundary=" + e.boundary
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// bodyMarshalers re-encode decoded JSON bodies for binary content types
+var bodyMarshalers = map[string]func(buf []byte, value interface{}) []byte{
+	"application/msgpack":     appendMsgpack,
+	"application/x-msgpack":   appendMsgpack,
+	"application/vnd.msgpack": appendMsgpack,
+	"application/cbor":        appendCBOR,
+}
+
+// isBinaryContentType reports whether bodies of a content type are binary
+func isBinaryContentType(contentType string) bool {
+	_, ok := bodyMarshalers[contentType]
+	return ok || strings.HasPrefix(contentType, "multipart/")
+}
+
+// withContentType wraps e so its bodies are sent as contentType, re-encoded
+// when it is a binary format
+func withContentType(e Encoder, contentType string) Encoder {
+	if contentType == "" || contentType == e.ContentType() {
+		return e
+	}
+	return contentTypeEncoder{Encoder: e, contentType: contentType, marshal: bodyMarshalers[contentType]}
+}
+
+// contentTypeEncoder sends another encoder's bodies under a different
+// Content-Type
+type contentTypeEncoder struct {
+	Encoder
+	contentType string
+	marshal     func(buf []byte, value interface{}) []byte // nil to send the body as is
+}
+
+func (e contentTypeEncoder) ContentType() string {
+	return e.contentType
+}
+
+// Encode re-encodes the JSON body of the wrapped encoder
+func (e contentTypeEncoder) Encode(ctx context.Context, batch [][]float64) ([]byte, error) {
+	body, err := e.Encoder.Encode(ctx, batch)
+	if err != nil || e.marshal == nil {
+		return body, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("failed to re-encode body as %s: %v", e.contentType, err)
+	}
+	return e.marshal(nil, value), nil
+}
+
+// appendMsgpack appends decoded JSON encoded as MessagePack.
+// Map keys are sorted so bodies are reproducible.
+func appendMsgpack(buf []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, 0xc0)
+	case bool:
+		if v {
+			return append(buf, 0xc3)
+		}
+		return append(buf, 0xc2)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			switch {
+			case n >= 0 && n < 128:
+				return append(buf, byte(n))
+			case n >= -32 && n < 0:
+				return append(buf, byte(n))
+			}
+			return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(n))
+		}
+		f, _ := v.Float64()
+		return binary.BigEndian.AppendUint64(append(buf, 0xcb), math.Float64bits(f))
+	case string:
+		switch n := len(v); {
+		case n < 32:
+			buf = append(buf, 0xa0|byte(n))
+		case n < 256:
+			buf = append(buf, 0xd9, byte(n))
+		case n < 65536:
+			buf = binary.BigEndian.AppendUint16(append(buf, 0xda), uint16(n))
+		default:
+			buf = binary.BigEndian.AppendUint32(append(buf, 0xdb), uint32(n))
+		}
+		return append(buf, v...)
+	case []interface{}:
+		buf = msgpackHeader(buf, len(v), 0x90, 0xdc)
+		for _, item := range v {
+			buf = appendMsgpack(buf, item)
+		}
+		return buf
+	case map[string]interface{}:
+		buf = msgpackHeader(buf, len(v), 0x80, 0xde)
+		for _, key := range sortedKeys(v) {
+			buf = appendMsgpack(buf, key)
+			buf = appendMsgpack(buf, v[key])
+		}
+		return buf
+	}
+	return append(buf, 0xc0)
+}
+
+// msgpackHeader writes an array or map header: the fix form for up to 15
+// items, otherwise the 16- or 32-bit form (code and code+1)
+func msgpackHeader(buf []byte, n int, fix, code byte) []byte {
+	switch {
+	case n < 16:
+		return append(buf, fix|byte(n))
+	case n < 65536:
+		return binary.BigEndian.AppendUint16(append(buf, code), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(buf, code+1), uint32(n))
+}
+
+// appendCBOR appends decoded JSON encoded as CBOR (RFC 8949).
+// Map keys are sorted so bodies are reproducible.
+func appendCBOR(buf []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, 0xf6)
+	case bool:
+		if v {
+			return append(buf, 0xf5)
+		}
+		return append(buf, 0xf4)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			if n >= 0 {
+				return cborHeader(buf, 0, uint64(n))
+			}
+			return cborHeader(buf, 1, uint64(-1-n))
+		}
+		f, _ := v.Float64()
+		return binary.BigEndian.AppendUint64(append(buf, 0xfb), math.Float64bits(f))
+	case string:
+		return append(cborHeader(buf, 3, uint64(len(v))), v...)
+	case []interface{}:
+		buf = cborHeader(buf, 4, uint64(len(v)))
+		for _, item := range v {
+			buf = appendCBOR(buf, item)
+		}
+		return buf
+	case map[string]interface{}:
+		buf = cborHeader(buf, 5, uint64(len(v)))
+		for _, key := range sortedKeys(v) {
+			buf = appendCBOR(buf, key)
+			buf = appendCBOR(buf, v[key])
+		}
+		return buf
+	}
+	return append(buf, 0xf6)
+}
+
+// cborHeader writes a CBOR major type with its argument in the shortest form
+func cborHeader(buf []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(buf, major|byte(n))
+	case n < 1<<8:
+		return append(buf, major|24, byte(n))
+	case n < 1<<16:
+		return binary.BigEndian.AppendUint16(append(buf, major|25), uint16(n))
+	case n < 1<<32:
+		return binary.BigEndian.AppendUint32(append(buf, major|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, major|27), n)
+}
+
+// sortedKeys returns the keys of a JSON object in order
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
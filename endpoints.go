@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// stringList collects a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ", ") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+var (
+	// endpoints are the API endpoints requests are distributed across
+	endpoints    []string
+	nextEndpoint atomic.Uint64
+
+	// endpointWeights, when set, splits traffic across endpoints in proportion
+	// to each weight instead of evenly
+	endpointWeights []int
+	currentWeights  []int
+	weightMutex     sync.Mutex
+)
+
+// endpointStats holds the per-endpoint breakdown shown in the metrics table
+type endpointStats struct {
+	success    int
+	failed     int
+	latencySum float64
+}
+
+// endpointMetrics is guarded by metricsMutex
+var endpointMetrics = map[string]*endpointStats{}
+
+// pickEndpoint returns the next endpoint in round-robin order, honouring
+// endpoint weights when they are set
+func pickEndpoint() string {
+	if endpointWeights != nil {
+		return pickWeightedEndpoint()
+	}
+	n := nextEndpoint.Add(1) - 1
+	return endpoints[n%uint64(len(endpoints))]
+}
+
+// pickWeightedEndpoint implements smooth weighted round-robin, which hits
+// the exact split over every cycle of sum(weights) requests while spreading
+// the picks of each endpoint evenly through the cycle
+func pickWeightedEndpoint() string {
+	weightMutex.Lock()
+	defer weightMutex.Unlock()
+
+	total, best := 0, 0
+	for i, weight := range endpointWeights {
+		currentWeights[i] += weight
+		total += weight
+		if currentWeights[i] > currentWeights[best] {
+			best = i
+		}
+	}
+	currentWeights[best] -= total
+	return endpoints[best]
+}
+
+// parseWeights parses a comma-separated weight list such as "90,10", one
+// weight per endpoint
+func parseWeights(value string, count int) ([]int, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != count {
+		return nil, fmt.Errorf("got %d weights for %d endpoints", len(parts), count)
+	}
+	weights := make([]int, len(parts))
+	total := 0
+	for i, part := range parts {
+		weight, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight %q", part)
+		}
+		weights[i] = weight
+		total += weight
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("at least one weight must be positive")
+	}
+	return weights, nil
+}
+
+// setEndpointWeights enables weighted distribution across the endpoints
+func setEndpointWeights(weights []int) {
+	endpointWeights = weights
+	currentWeights = make([]int, len(weights))
+}
+
+// loadTargetsFile reads one endpoint URL per line, skipping blank lines and
+// # comments. A line may end with a weight ("http://canary:8501 10"); the
+// weights are returned comma-separated when every line has one.
+func loadTargetsFile(filename string) ([]string, string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open targets file: %v", err)
+	}
+	defer file.Close()
+
+	var urls, weights []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		urls = append(urls, fields[0])
+		if len(fields) > 1 {
+			weights = append(weights, fields[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to read targets file: %v", err)
+	}
+	if len(weights) == 0 {
+		return urls, "", nil
+	}
+	if len(weights) != len(urls) {
+		return nil, "", fmt.Errorf("either every target or none must have a weight")
+	}
+	return urls, strings.Join(weights, ","), nil
+}
+
+// statsFor returns the stats of an endpoint. Callers must hold metricsMutex.
+func statsFor(endpoint string) *endpointStats {
+	stats, ok := endpointMetrics[endpoint]
+	if !ok {
+		stats = &endpointStats{}
+		endpointMetrics[endpoint] = stats
+	}
+	return stats
+}
+
+// endpointRows renders one metrics row per endpoint, with each endpoint's
+// share of the traffic so a weighted split can be checked against its
+// target. Callers must hold metricsMutex.
+func endpointRows() [][]string {
+	sent := 0
+	for _, endpoint := range allEndpoints() {
+		stats := statsFor(endpoint)
+		sent += stats.success + stats.failed
+	}
+
+	var rows [][]string
+	for _, endpoint := range allEndpoints() {
+		stats := statsFor(endpoint)
+		average, share := 0.0, 0.0
+		if stats.success > 0 {
+			average = stats.latencySum / float64(stats.success)
+		}
+		if sent > 0 {
+			share = 100 * float64(stats.success+stats.failed) / float64(sent)
+		}
+		rows = append(rows, []string{endpoint, fmt.Sprintf("%.1f%% | ok %d / failed %d, avg %.2f ms", share, stats.success, stats.failed, average)})
+	}
+	return rows
+}
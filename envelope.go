@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Placeholders an --envelope is filled with on every request
+const (
+	envelopeInstances = "$instances"  // the batch, as a list of pixel lists
+	envelopeRequestID = "$request_id" // the request's UUID
+)
+
+// requestEnvelope is the --envelope used by the envelope payload format
+var requestEnvelope interface{}
+
+// loadEnvelope parses an --envelope: a JSON object given inline or in a file,
+// with "$instances" where the samples go. Everything else is sent as is.
+func loadEnvelope(spec string) (interface{}, error) {
+	text := []byte(spec)
+	if !strings.HasPrefix(strings.TrimSpace(spec), "{") {
+		var err error
+		if text, err = os.ReadFile(spec); err != nil {
+			return nil, fmt.Errorf("failed to read envelope: %v", err)
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(text))
+	decoder.UseNumber()
+	var envelope map[string]interface{}
+	if err := decoder.Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("invalid envelope: %v", err)
+	}
+	if !hasPlaceholder(envelope, envelopeInstances) {
+		return nil, fmt.Errorf("envelope has no %q placeholder", envelopeInstances)
+	}
+	return envelope, nil
+}
+
+// hasPlaceholder reports whether a decoded JSON value contains placeholder
+func hasPlaceholder(value interface{}, placeholder string) bool {
+	switch v := value.(type) {
+	case string:
+		return v == placeholder
+	case []interface{}:
+		for _, item := range v {
+			if hasPlaceholder(item, placeholder) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			if hasPlaceholder(item, placeholder) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// envelopeEncoder wraps the samples in a configured JSON object
+type envelopeEncoder struct {
+	envelope interface{}
+}
+
+func (e envelopeEncoder) Encode(ctx context.Context, batch [][]float64) ([]byte, error) {
+	return json.Marshal(fillEnvelope(e.envelope, batch, requestInfoFrom(ctx).id))
+}
+
+func (e envelopeEncoder) ContentType() string {
+	return "application/json"
+}
+
+// fillEnvelope returns a copy of value with the placeholders replaced
+func fillEnvelope(value interface{}, batch [][]float64, requestID string) interface{} {
+	switch v := value.(type) {
+	case string:
+		switch v {
+		case envelopeInstances:
+			return batch
+		case envelopeRequestID:
+			return requestID
+		}
+	case []interface{}:
+		filled := make([]interface{}, len(v))
+		for i, item := range v {
+			filled[i] = fillEnvelope(item, batch, requestID)
+		}
+		return filled
+	case map[string]interface{}:
+		filled := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			filled[key] = fillEnvelope(item, batch, requestID)
+		}
+		return filled
+	}
+	return value
+}
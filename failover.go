@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+var (
+	// backupEndpoint receives the traffic of endpoints that are failing
+	backupEndpoint    string
+	failoverThreshold int
+	failbackAfter     time.Duration
+
+	// endpointHealth and failoverEvents are guarded by metricsMutex
+	endpointHealth = map[string]*healthState{}
+	failoverEvents int
+)
+
+// healthState tracks consecutive failures of one primary endpoint
+type healthState struct {
+	consecutiveErrors int
+	downSince         time.Time
+}
+
+// routeEndpoint returns the endpoint a request should go to: the backup if
+// the chosen endpoint has failed over, otherwise the endpoint itself. After
+// failbackAfter the primary is tried again.
+func routeEndpoint(endpoint string) string {
+	if backupEndpoint == "" {
+		return endpoint
+	}
+
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	health, ok := endpointHealth[endpoint]
+	if !ok || health.downSince.IsZero() {
+		return endpoint
+	}
+	if time.Since(health.downSince) >= failbackAfter {
+		health.downSince = time.Time{}
+		health.consecutiveErrors = 0
+		logToWidget(fmt.Sprintf("Failing back to %s", endpoint))
+		return endpoint
+	}
+	return backupEndpoint
+}
+
+// noteResult updates the health of a primary endpoint and fails it over once
+// it reaches the consecutive error threshold. Callers must hold metricsMutex.
+func noteResult(endpoint string, ok bool) {
+	if backupEndpoint == "" || endpoint == backupEndpoint {
+		return
+	}
+
+	health, found := endpointHealth[endpoint]
+	if !found {
+		health = &healthState{}
+		endpointHealth[endpoint] = health
+	}
+	if ok {
+		health.consecutiveErrors = 0
+		return
+	}
+
+	health.consecutiveErrors++
+	if health.consecutiveErrors >= failoverThreshold && health.downSince.IsZero() {
+		health.downSince = time.Now()
+		failoverEvents++
+		logToWidget(fmt.Sprintf("%s failed %d times in a row, failing over to %s", endpoint, failoverThreshold, backupEndpoint))
+	}
+}
+
+// allEndpoints returns the primary endpoints followed by the backup, if any
+func allEndpoints() []string {
+	if backupEndpoint == "" {
+		return endpoints
+	}
+	return append(append([]string{}, endpoints...), backupEndpoint)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"syscall"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// failureCounts counts failed requests per failureKind
+var failureCounts = map[string]int{}
+
+// failureKind classifies why a request failed: timeout, abandoned,
+// connection refused, connection reset, connection, dns, tls, 4xx, 5xx or
+// other. gRPC statuses count as the closest REST kind. ctx is
+// the context the request was sent with.
+func failureKind(ctx context.Context, err error) string {
+	var statusErr *statusError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certificateErr x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &statusErr) && statusErr.code >= 500:
+		return "5xx"
+	case errors.As(err, &statusErr) && statusErr.code >= 400:
+		return "4xx"
+	case ctx.Err() != nil && runCtx.Err() != nil:
+		return "abandoned"
+	case ctx.Err() != nil, errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case grpcStatus(err) != nil:
+		return grpcFailureKind(grpcStatus(err))
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection reset"
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &verifyErr),
+		errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &certificateErr):
+		return "tls"
+	default:
+		return "other"
+	}
+}
+
+// grpcStatus is the gRPC status err wraps, or nil if it wraps none
+func grpcStatus(err error) *status.Status {
+	if st, ok := status.FromError(err); ok && st.Code() != codes.OK {
+		return st
+	}
+	return nil
+}
+
+// grpcFailureKind maps a gRPC status onto the failure kinds of REST. gRPC
+// does not keep the network error behind Unavailable, so connection refused,
+// DNS and TLS failures are told apart by the status message.
+func grpcFailureKind(st *status.Status) string {
+	message := st.Message()
+	switch st.Code() {
+	case codes.DeadlineExceeded:
+		return "timeout"
+	case codes.Unavailable:
+		switch {
+		case strings.Contains(message, "connection refused"):
+			return "connection refused"
+		case strings.Contains(message, "connection reset"):
+			return "connection reset"
+		case strings.Contains(message, "no such host"), strings.Contains(message, "produced zero addresses"):
+			return "dns"
+		case strings.Contains(message, "authentication handshake failed"), strings.Contains(message, "tls:"),
+			strings.Contains(message, "x509:"):
+			return "tls"
+		default:
+			return "connection"
+		}
+	case codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.PermissionDenied,
+		codes.Unauthenticated, codes.ResourceExhausted, codes.FailedPrecondition, codes.OutOfRange:
+		return "4xx"
+	case codes.Internal, codes.Unimplemented, codes.DataLoss:
+		return "5xx"
+	default:
+		return "other"
+	}
+}
+
+// recordFailureKind counts a failed request by kind
+func recordFailureKind(kind string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	failureCounts[kind]++
+}
+
+// failureRows is the metrics row of the failures by kind. Callers must hold
+// metricsMutex.
+func failureRows() [][]string {
+	if len(failureCounts) == 0 {
+		return nil
+	}
+	kinds := make([]string, 0, len(failureCounts))
+	for kind, count := range failureCounts {
+		kinds = append(kinds, fmt.Sprintf("%s: %d", kind, count))
+	}
+	sort.Strings(kinds)
+	return [][]string{{"Failures", strings.Join(kinds, ", ")}}
+}
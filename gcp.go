@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jws"
+)
+
+// cloudPlatformScope is the OAuth2 scope needed to call Vertex AI
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// vertexPredictURL returns the online prediction URL of a Vertex AI endpoint
+func vertexPredictURL(project, region, endpointID string) string {
+	return fmt.Sprintf("https://%s-aiplatform.googleapis.com/v1/projects/%s/locations/%s/endpoints/%s:predict",
+		region, project, region, endpointID)
+}
+
+// newADCTokenSource returns an access token source backed by application
+// default credentials
+func newADCTokenSource() (oauth2.TokenSource, error) {
+	creds, err := google.FindDefaultCredentials(context.Background(), cloudPlatformScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find application default credentials: %v", err)
+	}
+	return creds.TokenSource, nil
+}
+
+// gcpAudience returns the default ID token audience for a URL, which is its
+// scheme and host as expected by Cloud Run and IAP
+func gcpAudience(apiURL string) (string, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return "", err
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+// newIDTokenSource returns a Google-signed ID token source for the audience.
+// Service account keys and gcloud user credentials found through application
+// default credentials are used first, then the metadata server when running
+// on Google Cloud.
+func newIDTokenSource(audience string) (oauth2.TokenSource, error) {
+	ctx := context.Background()
+	creds, err := google.FindDefaultCredentials(ctx, cloudPlatformScope)
+	if err == nil && len(creds.JSON) > 0 {
+		var file struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(creds.JSON, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse credentials file: %v", err)
+		}
+		switch file.Type {
+		case "service_account":
+			config, err := google.JWTConfigFromJSON(creds.JSON)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse service account key: %v", err)
+			}
+			config.PrivateClaims = map[string]interface{}{"target_audience": audience}
+			config.UseIDToken = true
+			return oauth2.ReuseTokenSource(nil, jwtIDTokenSource{config.TokenSource(ctx)}), nil
+		case "authorized_user":
+			return oauth2.ReuseTokenSource(nil, userIDTokenSource{creds.TokenSource}), nil
+		default:
+			return nil, fmt.Errorf("credentials of type %q cannot mint ID tokens", file.Type)
+		}
+	}
+
+	if !metadata.OnGCE() {
+		if err != nil {
+			return nil, fmt.Errorf("failed to find application default credentials: %v", err)
+		}
+		return nil, fmt.Errorf("no credentials file found and not running on Google Cloud")
+	}
+	return oauth2.ReuseTokenSource(nil, metadataIDTokenSource{audience}), nil
+}
+
+// jwtIDTokenSource passes through ID tokens minted from a service account key
+type jwtIDTokenSource struct {
+	source oauth2.TokenSource
+}
+
+func (s jwtIDTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	token.TokenType = "Bearer"
+	return token, nil
+}
+
+// userIDTokenSource extracts the ID token returned alongside a gcloud user
+// credential's access token
+type userIDTokenSource struct {
+	source oauth2.TokenSource
+}
+
+func (s userIDTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	idToken, ok := token.Extra("id_token").(string)
+	if !ok || idToken == "" {
+		return nil, fmt.Errorf("user credentials did not return an ID token")
+	}
+	return idTokenWithExpiry(idToken)
+}
+
+// metadataIDTokenSource fetches ID tokens from the GCE metadata server
+type metadataIDTokenSource struct {
+	audience string
+}
+
+func (s metadataIDTokenSource) Token() (*oauth2.Token, error) {
+	idToken, err := metadata.Get("instance/service-accounts/default/identity?format=full&audience=" + url.QueryEscape(s.audience))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ID token from metadata server: %v", err)
+	}
+	return idTokenWithExpiry(idToken)
+}
+
+// idTokenWithExpiry wraps a raw ID token, reading its expiry from the JWT
+// claims so it is refreshed before it lapses
+func idTokenWithExpiry(idToken string) (*oauth2.Token, error) {
+	claims, err := jws.Decode(idToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode ID token: %v", err)
+	}
+	return &oauth2.Token{
+		AccessToken: idToken,
+		TokenType:   "Bearer",
+		Expiry:      time.Unix(claims.Exp, 0),
+	}, nil
+}
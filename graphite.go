@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+var (
+	// graphiteAddr, when set, is the Carbon host:port the metrics are sent
+	// to in the Graphite plaintext protocol every graphiteInterval
+	graphiteAddr     string
+	graphitePrefix   string
+	graphiteInterval time.Duration
+	// graphiteHistogram records the latencies since the last flush
+	graphiteHistogram *hdrhistogram.Histogram
+)
+
+// graphiteCounts are the cumulative counts at the last flush, to send the
+// differences
+var graphiteCounts struct {
+	success, failed int
+	at              time.Time
+}
+
+// startGraphite sends the metrics every --graphite-interval until stop is
+// closed, and a last time then. The returned channel is closed after it.
+func startGraphite(stop <-chan struct{}) <-chan struct{} {
+	metricsMutex.Lock()
+	graphiteHistogram = newIntervalHistogram()
+	graphiteCounts.at = time.Now()
+	metricsMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(graphiteInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-stop:
+				if err := writeGraphite(graphiteLines()); err != nil {
+					logger.Errorf("Failed to send the last metrics to --graphite: %v", err)
+				}
+				return
+			}
+			if err := writeGraphite(graphiteLines()); err != nil {
+				logToWidget(fmt.Sprintf("Failed to send to --graphite: %v", err))
+			}
+		}
+	}()
+	return done
+}
+
+// graphiteLines are the metrics of the interval since the last flush: the
+// requests, failures and rate, the gauges, and the latency percentiles in ms
+func graphiteLines() string {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	now := time.Now()
+	success, failed := successRequests-graphiteCounts.success, failedRequests-graphiteCounts.failed
+	rate := float64(success+failed) / now.Sub(graphiteCounts.at).Seconds()
+	graphiteCounts.success, graphiteCounts.failed, graphiteCounts.at = successRequests, failedRequests, now
+
+	var lines strings.Builder
+	line := func(name string, value any) {
+		fmt.Fprintf(&lines, "%s%s %v %d\n", graphitePrefix, name, value, now.Unix())
+	}
+	line("requests", success+failed)
+	line("failures", failed)
+	line("rps", fmt.Sprintf("%.3f", rate))
+	line("in_flight", busyWorkers)
+	line("queued", len(sendQueue))
+	line("bots", activeBots)
+	if graphiteHistogram.TotalCount() > 0 {
+		for _, percentile := range []float64{50, 90, 95, 99} {
+			line(fmt.Sprintf("latency.p%g", percentile), fmt.Sprintf("%.3f", float64(graphiteHistogram.ValueAtPercentile(percentile))/1000))
+		}
+		line("latency.max", fmt.Sprintf("%.3f", float64(graphiteHistogram.Max())/1000))
+		line("latency.mean", fmt.Sprintf("%.3f", graphiteHistogram.Mean()/1000))
+	}
+	graphiteHistogram.Reset()
+	return lines.String()
+}
+
+// writeGraphite sends lines to Carbon over a new TCP connection, so a
+// restarted Carbon only costs the flushes meanwhile
+func writeGraphite(lines string) error {
+	conn, err := net.DialTimeout("tcp", graphiteAddr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte(lines)); err != nil {
+		conn.Close()
+		return err
+	}
+	return conn.Close()
+}
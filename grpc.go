@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// predictMethod is the fully qualified TF Serving PredictionService.Predict method
+const predictMethod = "/tensorflow.serving.PredictionService/Predict"
+
+// Values of the tensorflow.DataType enum
+const (
+	dtFloat  = 1
+	dtDouble = 2
+	dtInt32  = 3
+	dtInt64  = 9
+)
+
+var (
+	// grpcConns holds one client connection per endpoint
+	grpcConns    = map[string]*grpc.ClientConn{}
+	grpcDeadline time.Duration
+)
+
+// rawCodec passes pre-encoded protobuf messages straight through to gRPC,
+// so the TF Serving protos don't need to be generated and vendored
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("rawCodec: unexpected type %T", v)
+	}
+	return *b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("rawCodec: unexpected type %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string { return "proto" }
+
+// dialGRPC opens the client connection to an endpoint, shared by all bots.
+// The connection is plaintext unless a TLS configuration is given.
+func dialGRPC(target string, tlsConfig *tls.Config) error {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return fmt.Errorf("failed to create gRPC client: %v", err)
+	}
+	grpcConns[target] = conn
+	return nil
+}
+
+// closeGRPC closes every endpoint connection
+func closeGRPC() {
+	for _, conn := range grpcConns {
+		conn.Close()
+	}
+}
+
+// encodeTensorProto builds a DT_FLOAT tensorflow.TensorProto with the given shape
+func encodeTensorProto(values []float64, shape ...int) []byte {
+	var dims []byte
+	for _, size := range shape {
+		var dim []byte
+		dim = protowire.AppendTag(dim, 1, protowire.VarintType)
+		dim = protowire.AppendVarint(dim, uint64(size))
+		dims = protowire.AppendTag(dims, 2, protowire.BytesType)
+		dims = protowire.AppendBytes(dims, dim)
+	}
+
+	var floats []byte
+	for _, value := range values {
+		floats = protowire.AppendFixed32(floats, math.Float32bits(float32(value)))
+	}
+
+	var tensor []byte
+	tensor = protowire.AppendTag(tensor, 1, protowire.VarintType)
+	tensor = protowire.AppendVarint(tensor, dtFloat)
+	tensor = protowire.AppendTag(tensor, 2, protowire.BytesType)
+	tensor = protowire.AppendBytes(tensor, dims)
+	tensor = protowire.AppendTag(tensor, 5, protowire.BytesType)
+	tensor = protowire.AppendBytes(tensor, floats)
+	return tensor
+}
+
+// encodePredictRequest builds a tensorflow.serving.PredictRequest for a batch
+// of samples
+func encodePredictRequest(batch [][]float64) []byte {
+	var spec []byte
+	spec = protowire.AppendTag(spec, 1, protowire.BytesType)
+	spec = protowire.AppendString(spec, modelName)
+	if modelVersion != "" {
+		// version is a google.protobuf.Int64Value wrapper
+		version, _ := strconv.ParseInt(modelVersion, 10, 64)
+		var wrapper []byte
+		wrapper = protowire.AppendTag(wrapper, 1, protowire.VarintType)
+		wrapper = protowire.AppendVarint(wrapper, uint64(version))
+		spec = protowire.AppendTag(spec, 2, protowire.BytesType)
+		spec = protowire.AppendBytes(spec, wrapper)
+	}
+	if signatureName != "" {
+		spec = protowire.AppendTag(spec, 3, protowire.BytesType)
+		spec = protowire.AppendString(spec, signatureName)
+	}
+
+	// map<string, TensorProto> entries are encoded as nested key/value messages
+	var entry []byte
+	entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+	entry = protowire.AppendString(entry, inputName)
+	entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+	entry = protowire.AppendBytes(entry, encodeTensorProto(flatten(batch), len(batch), len(batch[0])))
+
+	var req []byte
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	req = protowire.AppendBytes(req, spec)
+	req = protowire.AppendTag(req, 2, protowire.BytesType)
+	req = protowire.AppendBytes(req, entry)
+	return req
+}
+
+// tensor is a decoded tensorflow.TensorProto
+type tensor struct {
+	dtype  uint64
+	shape  []int
+	values []float64
+}
+
+// decodePredictResponse reads the rows of the output tensor from a
+// tensorflow.serving.PredictResponse. When the model has several outputs the
+// first one by name is used.
+func decodePredictResponse(resp []byte) ([][]float64, error) {
+	outputs := map[string][]byte{}
+	err := consumeFields(resp, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		// map<string, TensorProto> entry
+		var key string
+		var data []byte
+		err := consumeFields(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+			switch {
+			case num == 1 && typ == protowire.BytesType:
+				key = string(value)
+			case num == 2 && typ == protowire.BytesType:
+				data = value
+			}
+			return nil
+		})
+		outputs[key] = data
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode PredictResponse: %v", err)
+	}
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("PredictResponse has no outputs")
+	}
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	output, err := decodeTensorProto(outputs[names[0]])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode output %q: %v", names[0], err)
+	}
+	return tensorRows(output.values, output.shape)
+}
+
+// decodeTensorProto reads the shape and the values of a float, double, int32
+// or int64 tensorflow.TensorProto, either from its typed value field or from
+// tensor_content
+func decodeTensorProto(data []byte) (tensor, error) {
+	var t tensor
+	var content []byte
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			t.dtype, _ = protowire.ConsumeVarint(value)
+		case num == 2 && typ == protowire.BytesType:
+			return consumeFields(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+				if num != 2 || typ != protowire.BytesType {
+					return nil
+				}
+				return consumeFields(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+					if num == 1 && typ == protowire.VarintType {
+						size, _ := protowire.ConsumeVarint(value)
+						t.shape = append(t.shape, int(int64(size)))
+					}
+					return nil
+				})
+			})
+		case num == 4 && typ == protowire.BytesType:
+			content = value
+		case num == 5, num == 6, num == 7, num == 10:
+			return appendScalars(&t.values, num, typ, value)
+		}
+		return nil
+	})
+	if err != nil || content == nil {
+		return t, err
+	}
+
+	var size int
+	switch t.dtype {
+	case dtFloat, dtInt32:
+		size = 4
+	case dtDouble, dtInt64:
+		size = 8
+	default:
+		return t, fmt.Errorf("unsupported tensor dtype %d", t.dtype)
+	}
+	if len(content)%size != 0 {
+		return t, fmt.Errorf("tensor_content of %d bytes is not a multiple of %d", len(content), size)
+	}
+	t.values = t.values[:0]
+	for i := 0; i < len(content); i += size {
+		switch t.dtype {
+		case dtFloat:
+			t.values = append(t.values, float64(math.Float32frombits(binary.LittleEndian.Uint32(content[i:]))))
+		case dtDouble:
+			t.values = append(t.values, math.Float64frombits(binary.LittleEndian.Uint64(content[i:])))
+		case dtInt32:
+			t.values = append(t.values, float64(int32(binary.LittleEndian.Uint32(content[i:]))))
+		case dtInt64:
+			t.values = append(t.values, float64(int64(binary.LittleEndian.Uint64(content[i:]))))
+		}
+	}
+	return t, nil
+}
+
+// appendScalars appends the float_val (5), double_val (6), int_val (7) or
+// int64_val (10) values of a TensorProto, packed or not
+func appendScalars(values *[]float64, num protowire.Number, typ protowire.Type, value []byte) error {
+	consume := func(b []byte) int {
+		switch num {
+		case 5:
+			v, n := protowire.ConsumeFixed32(b)
+			*values = append(*values, float64(math.Float32frombits(v)))
+			return n
+		case 6:
+			v, n := protowire.ConsumeFixed64(b)
+			*values = append(*values, math.Float64frombits(v))
+			return n
+		case 7:
+			v, n := protowire.ConsumeVarint(b)
+			*values = append(*values, float64(int32(v)))
+			return n
+		default:
+			v, n := protowire.ConsumeVarint(b)
+			*values = append(*values, float64(int64(v)))
+			return n
+		}
+	}
+	if typ != protowire.BytesType {
+		if n := consume(value); n < 0 {
+			return protowire.ParseError(n)
+		}
+		return nil
+	}
+	for len(value) > 0 {
+		n := consume(value)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		value = value[n:]
+	}
+	return nil
+}
+
+// consumeFields calls fn with the number, wire type and raw value of every
+// field of a protobuf message. Length-delimited values are passed without
+// their length prefix.
+func consumeFields(data []byte, fn func(protowire.Number, protowire.Type, []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		n = protowire.ConsumeFieldValue(num, typ, data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		value := data[:n]
+		if typ == protowire.BytesType {
+			value, _ = protowire.ConsumeBytes(value)
+		}
+		if err := fn(num, typ, value); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// grpcTarget calls TF Serving's PredictionService over one gRPC connection
+type grpcTarget struct {
+	conn *grpc.ClientConn
+}
+
+// newGRPCTarget returns a Target using the endpoint's dialed connection
+func newGRPCTarget(endpoint string) (Target, error) {
+	conn, ok := grpcConns[endpoint]
+	if !ok {
+		return nil, fmt.Errorf("no gRPC connection to %s", endpoint)
+	}
+	return &grpcTarget{conn: conn}, nil
+}
+
+// Send calls Predict with one batch under the configured deadline
+func (t *grpcTarget) Send(ctx context.Context, batch [][]float64) (Result, error) {
+	var result Result
+	startTime := time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, grpcDeadline)
+	defer cancel()
+
+	ctx, err := grpcOutgoingContext(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	req := encodePredictRequest(batch)
+	var resp []byte
+	err = t.conn.Invoke(ctx, predictMethod, &req, &resp, grpc.ForceCodec(rawCodec{}))
+	if err := finishGRPCCall(ctx, &result, startTime, len(req), len(resp), err); err != nil {
+		return result, err
+	}
+
+	rows, err := decodePredictResponse(resp)
+	if err != nil {
+		return result, fmt.Errorf("invalid response: %v", err)
+	}
+	result.Predicted = predictedDigits(rows)
+	return result, nil
+}
+
+// grpcOutgoingContext attaches the extra headers, the sample tags, the trace
+// context and the access token, if any, to a call as gRPC metadata
+func grpcOutgoingContext(ctx context.Context) (context.Context, error) {
+	for key, values := range extraHeaders {
+		for _, value := range values {
+			ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(key), value)
+		}
+	}
+	for key, value := range sampleTags(requestInfoFrom(ctx)) {
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(key), value)
+	}
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	for key, value := range carrier {
+		ctx = metadata.AppendToOutgoingContext(ctx, key, value)
+	}
+	if tokenSource != nil {
+		token, err := tokenSource.Token()
+		if err != nil {
+			return nil, fmt.Errorf("error obtaining access token: %v", err)
+		}
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", token.Type()+" "+token.AccessToken)
+	}
+	return ctx, nil
+}
+
+// finishGRPCCall records the latency, the bytes sent and received and the
+// status of a call started at startTime, and maps its error
+func finishGRPCCall(ctx context.Context, result *Result, startTime time.Time, sent, received int, err error) error {
+	result.Latency = time.Since(startTime).Seconds() * 1000
+	recordBodyBytes(sent, sent)
+	recordResponseBytes(received)
+
+	result.Status = status.Code(err).String()
+	if err != nil {
+		if status.Code(err) == codes.Unavailable {
+			// the call never reached the model, as for a failed REST send
+			return &sendError{grpcError(ctx, startTime, err)}
+		}
+		return grpcError(ctx, startTime, err)
+	}
+	result.Protocol = "gRPC"
+	return nil
+}
+
+// grpcError describes a call started at startTime that failed, calling out
+// an exceeded deadline: the call's own, which --request-timeout may cut
+// shorter than --grpc-deadline. The status is wrapped so failureKind can
+// classify it by code.
+func grpcError(ctx context.Context, startTime time.Time, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) || status.Code(err) == codes.DeadlineExceeded {
+		if deadline, ok := ctx.Deadline(); ok {
+			return fmt.Errorf("gRPC deadline of %s exceeded: %w", deadline.Sub(startTime).Round(time.Millisecond), err)
+		}
+		return fmt.Errorf("gRPC deadline exceeded: %w", err)
+	}
+	return fmt.Errorf("gRPC request failed: %w", err)
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// genericGRPCOptions describes a custom gRPC inference method
+type genericGRPCOptions struct {
+	method        string
+	descriptorSet string
+	pixelField    string
+	outputField   string
+}
+
+// genericMethod is a resolved custom inference method. Requests are built
+// dynamically from its descriptors, so no generated code is needed.
+type genericMethod struct {
+	fullMethod  string
+	input       protoreflect.MessageDescriptor
+	output      protoreflect.MessageDescriptor
+	pixelPath   []protoreflect.FieldDescriptor
+	outputPath  []protoreflect.FieldDescriptor
+	pixelScalar protoreflect.Kind
+}
+
+// resolveGenericMethod loads the method's descriptors from a descriptor set
+// file or, when none is given, via server reflection on conn
+func resolveGenericMethod(opts genericGRPCOptions, conn *grpc.ClientConn) (*genericMethod, error) {
+	service, method, err := splitMethodName(opts.method)
+	if err != nil {
+		return nil, err
+	}
+
+	var files *protoregistry.Files
+	if opts.descriptorSet != "" {
+		files, err = loadDescriptorSet(opts.descriptorSet)
+	} else {
+		files, err = fetchReflectionFiles(conn, service)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("service %s not found: %v", service, err)
+	}
+	serviceDesc, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+	methodDesc := serviceDesc.Methods().ByName(protoreflect.Name(method))
+	if methodDesc == nil {
+		return nil, fmt.Errorf("service %s has no method %s", service, method)
+	}
+	if methodDesc.IsStreamingClient() || methodDesc.IsStreamingServer() {
+		return nil, fmt.Errorf("method %s is streaming, only unary methods are supported", opts.method)
+	}
+
+	m := &genericMethod{
+		fullMethod: fmt.Sprintf("/%s/%s", service, method),
+		input:      methodDesc.Input(),
+		output:     methodDesc.Output(),
+	}
+	if m.pixelPath, err = fieldPath(m.input, opts.pixelField); err != nil {
+		return nil, fmt.Errorf("invalid pixel field: %v", err)
+	}
+	pixels := m.pixelPath[len(m.pixelPath)-1]
+	if !pixels.IsList() || !isNumericKind(pixels.Kind()) {
+		return nil, fmt.Errorf("pixel field %s must be a repeated numeric field", pixels.FullName())
+	}
+	m.pixelScalar = pixels.Kind()
+	if opts.outputField != "" {
+		if m.outputPath, err = fieldPath(m.output, opts.outputField); err != nil {
+			return nil, fmt.Errorf("invalid output field: %v", err)
+		}
+		scores := m.outputPath[len(m.outputPath)-1]
+		if !scores.IsList() || !isNumericKind(scores.Kind()) {
+			return nil, fmt.Errorf("output field %s must be a repeated numeric field", scores.FullName())
+		}
+	}
+	return m, nil
+}
+
+// splitMethodName splits "pkg.Service/Method" (or "pkg.Service.Method")
+// into its service and method names
+func splitMethodName(name string) (string, string, error) {
+	name = strings.TrimPrefix(name, "/")
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		i = strings.LastIndex(name, ".")
+	}
+	if i <= 0 || i == len(name)-1 {
+		return "", "", fmt.Errorf("invalid gRPC method %q (expected package.Service/Method)", name)
+	}
+	return name[:i], name[i+1:], nil
+}
+
+// loadDescriptorSet reads a FileDescriptorSet as written by
+// protoc --include_imports --descriptor_set_out
+func loadDescriptorSet(filename string) (*protoregistry.Files, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read descriptor set: %v", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse descriptor set: %v", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %v", err)
+	}
+	return files, nil
+}
+
+// fetchReflectionFiles asks the server for the file defining symbol and,
+// transitively, every file it imports
+func fetchReflectionFiles(conn *grpc.ClientConn, symbol string) (*protoregistry.Files, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), grpcDeadline)
+	defer cancel()
+	ctx, err := grpcOutgoingContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("server reflection unavailable: %v", err)
+	}
+	defer stream.CloseSend()
+
+	protos := map[string]*descriptorpb.FileDescriptorProto{}
+	request := func(req *rpb.ServerReflectionRequest) error {
+		if err := stream.Send(req); err != nil {
+			return fmt.Errorf("server reflection failed: %v", err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return fmt.Errorf("server reflection failed: %v", err)
+		}
+		if errResp := resp.GetErrorResponse(); errResp != nil {
+			return fmt.Errorf("server reflection failed: %s", errResp.GetErrorMessage())
+		}
+		for _, raw := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			file := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(raw, file); err != nil {
+				return fmt.Errorf("invalid file descriptor from server: %v", err)
+			}
+			protos[file.GetName()] = file
+		}
+		return nil
+	}
+
+	if err := request(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: symbol},
+	}); err != nil {
+		return nil, err
+	}
+	for missing := missingImports(protos); len(missing) > 0; missing = missingImports(protos) {
+		for _, name := range missing {
+			if err := request(&rpb.ServerReflectionRequest{
+				MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: name},
+			}); err != nil {
+				return nil, err
+			}
+			if _, ok := protos[name]; !ok {
+				return nil, fmt.Errorf("server did not return %s", name)
+			}
+		}
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, file := range protos {
+		set.File = append(set.File, file)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptors from server: %v", err)
+	}
+	return files, nil
+}
+
+// missingImports lists the imported files not fetched yet
+func missingImports(protos map[string]*descriptorpb.FileDescriptorProto) []string {
+	var missing []string
+	for _, file := range protos {
+		for _, dep := range file.GetDependency() {
+			if _, ok := protos[dep]; !ok {
+				missing = append(missing, dep)
+			}
+		}
+	}
+	return missing
+}
+
+// fieldPath resolves a dotted field path such as "image.pixels". Every field
+// but the last must be a singular message field.
+func fieldPath(msg protoreflect.MessageDescriptor, path string) ([]protoreflect.FieldDescriptor, error) {
+	if path == "" {
+		return nil, fmt.Errorf("no field given")
+	}
+	var fields []protoreflect.FieldDescriptor
+	for i, name := range strings.Split(path, ".") {
+		if msg == nil {
+			return nil, fmt.Errorf("%s is not a message field", strings.Join(strings.Split(path, ".")[:i], "."))
+		}
+		field := msg.Fields().ByName(protoreflect.Name(name))
+		if field == nil {
+			return nil, fmt.Errorf("%s has no field %s", msg.FullName(), name)
+		}
+		fields = append(fields, field)
+		msg = nil
+		if field.Kind() == protoreflect.MessageKind && !field.IsList() && !field.IsMap() {
+			msg = field.Message()
+		}
+	}
+	return fields, nil
+}
+
+// isNumericKind reports whether values of the kind can hold pixel values
+func isNumericKind(kind protoreflect.Kind) bool {
+	switch kind {
+	case protoreflect.FloatKind, protoreflect.DoubleKind,
+		protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return true
+	}
+	return false
+}
+
+// numericValue converts a pixel to a protobuf value of the given kind
+func numericValue(kind protoreflect.Kind, v float64) protoreflect.Value {
+	switch kind {
+	case protoreflect.FloatKind:
+		return protoreflect.ValueOfFloat32(float32(v))
+	case protoreflect.DoubleKind:
+		return protoreflect.ValueOfFloat64(v)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return protoreflect.ValueOfInt32(int32(v))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return protoreflect.ValueOfInt64(int64(v))
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return protoreflect.ValueOfUint32(uint32(v))
+	default:
+		return protoreflect.ValueOfUint64(uint64(v))
+	}
+}
+
+// floatValue converts a numeric protobuf value back to a float64
+func floatValue(v protoreflect.Value) float64 {
+	switch x := v.Interface().(type) {
+	case float32:
+		return float64(x)
+	case float64:
+		return x
+	case int32:
+		return float64(x)
+	case int64:
+		return float64(x)
+	case uint32:
+		return float64(x)
+	case uint64:
+		return float64(x)
+	}
+	return 0
+}
+
+// buildRequest returns a request message with the samples of a batch, one
+// after the other, in the pixel field
+func (m *genericMethod) buildRequest(batch [][]float64) *dynamicpb.Message {
+	req := dynamicpb.NewMessage(m.input)
+	var msg protoreflect.Message = req
+	for _, field := range m.pixelPath[:len(m.pixelPath)-1] {
+		msg = msg.Mutable(field).Message()
+	}
+	list := msg.Mutable(m.pixelPath[len(m.pixelPath)-1]).List()
+	for _, sample := range batch {
+		for _, v := range sample {
+			list.Append(numericValue(m.pixelScalar, v))
+		}
+	}
+	return req
+}
+
+// scores reads the output field of a response, or nil if none is configured
+func (m *genericMethod) scores(resp *dynamicpb.Message) []float64 {
+	if m.outputPath == nil {
+		return nil
+	}
+	var msg protoreflect.Message = resp
+	for _, field := range m.outputPath[:len(m.outputPath)-1] {
+		msg = msg.Get(field).Message()
+	}
+	list := msg.Get(m.outputPath[len(m.outputPath)-1]).List()
+	scores := make([]float64, list.Len())
+	for i := range scores {
+		scores[i] = floatValue(list.Get(i))
+	}
+	return scores
+}
+
+// genericGRPCTarget calls a custom inference method over one connection
+type genericGRPCTarget struct {
+	conn   *grpc.ClientConn
+	method *genericMethod
+}
+
+// newGenericGRPCTargets returns a newTargetFunc calling method on each
+// endpoint's dialed connection
+func newGenericGRPCTargets(method *genericMethod) newTargetFunc {
+	return func(endpoint string) (Target, error) {
+		conn, ok := grpcConns[endpoint]
+		if !ok {
+			return nil, fmt.Errorf("no gRPC connection to %s", endpoint)
+		}
+		return &genericGRPCTarget{conn: conn, method: method}, nil
+	}
+}
+
+// Send calls the method with one batch under the configured deadline. The
+// output field holds the scores of every sample, one after the other.
+func (t *genericGRPCTarget) Send(ctx context.Context, batch [][]float64) (Result, error) {
+	var result Result
+	startTime := time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, grpcDeadline)
+	defer cancel()
+
+	ctx, err := grpcOutgoingContext(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	req := t.method.buildRequest(batch)
+	resp := dynamicpb.NewMessage(t.method.output)
+	err = t.conn.Invoke(ctx, t.method.fullMethod, req, resp)
+	if err := finishGRPCCall(ctx, &result, startTime, proto.Size(req), proto.Size(resp), err); err != nil {
+		return result, err
+	}
+	if scores := t.method.scores(resp); len(scores) > 0 {
+		rows, err := splitRows(scores, len(batch))
+		if err != nil {
+			return result, fmt.Errorf("invalid response: %v", err)
+		}
+		result.Predicted = predictedDigits(rows)
+	}
+	return result, nil
+}
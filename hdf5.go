@@ -0,0 +1,783 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// This is a minimal HDF5 reader for the files h5py and Keras write by
+// default: superblock versions 0 to 3, version 1 and 2 object headers,
+// symbol table and compact link groups, and contiguous, compact or chunked
+// (v1 B-tree, deflate and shuffle filters) integer or float datasets.
+
+var (
+	// h5Dataset is the path of the dataset holding the images
+	h5Dataset string
+	// h5Labels is the path of the dataset holding the labels
+	h5Labels string
+)
+
+var h5Signature = []byte("\x89HDF\r\n\x1a\n")
+
+// HDF5 header message types used below
+const (
+	h5Dataspace    = 0x01
+	h5LinkInfo     = 0x02
+	h5Datatype     = 0x03
+	h5Link         = 0x06
+	h5Layout       = 0x08
+	h5Filters      = 0x0b
+	h5Continuation = 0x10
+	h5SymbolTable  = 0x11
+)
+
+// h5Undefined is the undefined address, e.g. of a dataset never written
+const h5Undefined = ^uint64(0)
+
+// h5File is an open HDF5 file
+type h5File struct {
+	file       *os.File
+	base       uint64
+	offsetSize int
+	lengthSize int
+	root       uint64
+}
+
+// h5Message is one header message of an object
+type h5Message struct {
+	typ  uint16
+	data []byte
+}
+
+// h5Filter is one filter of a dataset's filter pipeline
+type h5Filter struct {
+	id     uint16
+	params []uint32
+}
+
+// isHDF5File reports whether a file is an HDF5 file
+func isHDF5File(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return ext == ".h5" || ext == ".hdf5"
+}
+
+// loadHDF5Dataset loads samples from an (N, 784) or (N, 28, 28) dataset and,
+// if present, labels from a one-dimensional dataset. Without --h5-dataset
+// the conventional names (x_test, x, images, x_train) are tried, with
+// labels from the matching y_* or labels dataset.
+func loadHDF5Dataset(filename string) ([][]float64, []int, error) {
+	f, err := openHDF5(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %v", filename, err)
+	}
+	defer f.file.Close()
+
+	imagesPath := h5Dataset
+	if imagesPath == "" {
+		for _, name := range sampleArrayNames {
+			if _, err := f.lookup(name); err == nil {
+				imagesPath = name
+				break
+			}
+		}
+		if imagesPath == "" {
+			return nil, nil, fmt.Errorf("no image dataset found in %s (use --h5-dataset)", filename)
+		}
+	}
+	array, err := f.readDatasetAt(imagesPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	samples, err := array.samples()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	labelsPath := h5Labels
+	if labelsPath == "" {
+		labelsPath = labelArrayName(strings.TrimPrefix(imagesPath, "/"))
+		if labelsPath == "" {
+			return samples, nil, nil
+		}
+		if _, err := f.lookup(labelsPath); err != nil {
+			return samples, nil, nil
+		}
+	}
+	labelArray, err := f.readDatasetAt(labelsPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(labelArray.values) != len(samples) {
+		return nil, nil, fmt.Errorf("%s has %d labels for %d images", labelsPath, len(labelArray.values), len(samples))
+	}
+	labels := make([]int, len(labelArray.values))
+	for i, label := range labelArray.values {
+		labels[i] = int(label)
+	}
+	return samples, labels, nil
+}
+
+// openHDF5 finds and parses the superblock
+func openHDF5(filename string) (*h5File, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// The superblock is at offset 0 or a power of two from 512 on
+	for offset := int64(0); offset+48 <= info.Size(); offset = max(offset*2, 512) {
+		header := make([]byte, 96)
+		n, _ := file.ReadAt(header, offset)
+		header = header[:n]
+		if !bytes.HasPrefix(header, h5Signature) {
+			continue
+		}
+		f := &h5File{file: file}
+		c := &h5Cursor{b: header, pos: 8}
+		switch version := c.u8(); version {
+		case 0, 1:
+			c.skip(4)
+			f.offsetSize, f.lengthSize = int(c.u8()), int(c.u8())
+			c.skip(1 + 4 + 4)
+			if version == 1 {
+				c.skip(4)
+			}
+			f.base = c.uint(f.offsetSize)
+			c.skip(3 * f.offsetSize)
+			// Root group symbol table entry
+			c.skip(f.offsetSize)
+			f.root = c.uint(f.offsetSize)
+		case 2, 3:
+			f.offsetSize, f.lengthSize = int(c.u8()), int(c.u8())
+			c.skip(1)
+			f.base = c.uint(f.offsetSize)
+			c.skip(2 * f.offsetSize)
+			f.root = c.uint(f.offsetSize)
+		default:
+			file.Close()
+			return nil, fmt.Errorf("unsupported superblock version %d", version)
+		}
+		if c.err != nil {
+			file.Close()
+			return nil, fmt.Errorf("truncated superblock")
+		}
+		return f, nil
+	}
+	file.Close()
+	return nil, fmt.Errorf("not an HDF5 file")
+}
+
+// readAt reads n bytes at a file address
+func (f *h5File) readAt(addr uint64, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := f.file.ReadAt(buf, int64(f.base+addr)); err != nil {
+		return nil, fmt.Errorf("failed to read at %#x: %v", addr, err)
+	}
+	return buf, nil
+}
+
+// lookup resolves a slash-separated path from the root group to the address
+// of an object header
+func (f *h5File) lookup(path string) (uint64, error) {
+	addr := f.root
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		next, err := f.child(addr, name)
+		if err != nil {
+			return 0, err
+		}
+		addr = next
+	}
+	return addr, nil
+}
+
+// child finds a link of a group by name
+func (f *h5File) child(group uint64, name string) (uint64, error) {
+	messages, err := f.messages(group)
+	if err != nil {
+		return 0, err
+	}
+	dense := false
+	for _, msg := range messages {
+		c := &h5Cursor{b: msg.data}
+		switch msg.typ {
+		case h5SymbolTable:
+			btree := c.uint(f.offsetSize)
+			heap := c.uint(f.offsetSize)
+			return f.symbolTableChild(btree, heap, name)
+		case h5Link:
+			linkName, addr, hard := f.parseLink(c)
+			if c.err == nil && linkName == name {
+				if !hard {
+					return 0, fmt.Errorf("%s is not a hard link", name)
+				}
+				return addr, nil
+			}
+		case h5LinkInfo:
+			c.skip(1)
+			flags := c.u8()
+			if flags&1 != 0 {
+				c.skip(8)
+			}
+			if c.uint(f.offsetSize) != h5Undefined>>(64-8*f.offsetSize) {
+				dense = true
+			}
+		}
+	}
+	if dense {
+		return 0, fmt.Errorf("%s not found (groups with dense link storage are not supported)", name)
+	}
+	return 0, fmt.Errorf("%s not found", name)
+}
+
+// parseLink decodes a link message into its name and, for hard links, the
+// target address
+func (f *h5File) parseLink(c *h5Cursor) (string, uint64, bool) {
+	c.skip(1)
+	flags := c.u8()
+	linkType := byte(0)
+	if flags&0x08 != 0 {
+		linkType = c.u8()
+	}
+	if flags&0x04 != 0 {
+		c.skip(8)
+	}
+	if flags&0x10 != 0 {
+		c.skip(1)
+	}
+	nameLength := int(c.uint(1 << (flags & 3)))
+	name := string(c.bytes(nameLength))
+	if linkType != 0 {
+		return name, 0, false
+	}
+	return name, c.uint(f.offsetSize), true
+}
+
+// symbolTableChild searches an old-style group's B-tree of symbol nodes
+func (f *h5File) symbolTableChild(btree, heap uint64, name string) (uint64, error) {
+	header, err := f.readAt(heap, 8+2*f.lengthSize+f.offsetSize)
+	if err != nil {
+		return 0, err
+	}
+	if string(header[:4]) != "HEAP" {
+		return 0, fmt.Errorf("invalid local heap at %#x", heap)
+	}
+	c := &h5Cursor{b: header, pos: 8}
+	heapSize := c.uint(f.lengthSize)
+	c.skip(f.lengthSize)
+	names, err := f.readAt(c.uint(f.offsetSize), int(heapSize))
+	if err != nil {
+		return 0, err
+	}
+
+	var found uint64
+	ok := false
+	err = f.walkBTree(btree, 0, f.lengthSize, func(_ []byte, child uint64) error {
+		node, err := f.readAt(child, 8)
+		if err != nil {
+			return err
+		}
+		if string(node[:4]) != "SNOD" {
+			return fmt.Errorf("invalid symbol table node at %#x", child)
+		}
+		count := int(binary.LittleEndian.Uint16(node[6:]))
+		entrySize := 2*f.offsetSize + 24
+		entries, err := f.readAt(child+8, count*entrySize)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < count; i++ {
+			c := &h5Cursor{b: entries[i*entrySize:]}
+			nameOffset := int(c.uint(f.offsetSize))
+			if nameOffset < len(names) {
+				entryName, _, _ := bytes.Cut(names[nameOffset:], []byte{0})
+				if string(entryName) == name {
+					found, ok = c.uint(f.offsetSize), true
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("%s not found", name)
+	}
+	return found, nil
+}
+
+// walkBTree visits the leaf entries of a version 1 B-tree of the given node
+// type, passing each child's preceding key and address
+func (f *h5File) walkBTree(addr uint64, nodeType byte, keySize int, visit func(key []byte, child uint64) error) error {
+	header, err := f.readAt(addr, 8+2*f.offsetSize)
+	if err != nil {
+		return err
+	}
+	if string(header[:4]) != "TREE" || header[4] != nodeType {
+		return fmt.Errorf("invalid B-tree node at %#x", addr)
+	}
+	level := header[5]
+	entries := int(binary.LittleEndian.Uint16(header[6:]))
+	body, err := f.readAt(addr+uint64(len(header)), entries*(keySize+f.offsetSize)+keySize)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < entries; i++ {
+		entry := body[i*(keySize+f.offsetSize):]
+		c := &h5Cursor{b: entry, pos: keySize}
+		child := c.uint(f.offsetSize)
+		if level > 0 {
+			err = f.walkBTree(child, nodeType, keySize, visit)
+		} else {
+			err = visit(entry[:keySize], child)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// messages reads the header messages of an object, following continuation
+// blocks
+func (f *h5File) messages(addr uint64) ([]h5Message, error) {
+	prefix, err := f.readAt(addr, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	type block struct {
+		addr, length uint64
+	}
+	var blocks []block
+	var messages []h5Message
+	v2 := string(prefix[:4]) == "OHDR"
+	flags := byte(0)
+
+	if v2 {
+		flags = prefix[5]
+		start := uint64(6)
+		if flags&0x20 != 0 {
+			start += 16
+		}
+		if flags&0x10 != 0 {
+			start += 4
+		}
+		sizeBytes := 1 << (flags & 3)
+		head, err := f.readAt(addr+start, sizeBytes)
+		if err != nil {
+			return nil, err
+		}
+		c := &h5Cursor{b: head}
+		blocks = append(blocks, block{addr + start + uint64(sizeBytes), c.uint(sizeBytes)})
+	} else {
+		if prefix[0] != 1 {
+			return nil, fmt.Errorf("unsupported object header version %d at %#x", prefix[0], addr)
+		}
+		blocks = append(blocks, block{addr + 16, uint64(binary.LittleEndian.Uint32(prefix[8:]))})
+	}
+
+	for i := 0; i < len(blocks); i++ {
+		data, err := f.readAt(blocks[i].addr, int(blocks[i].length))
+		if err != nil {
+			return nil, err
+		}
+		c := &h5Cursor{b: data}
+		if v2 && i > 0 {
+			if string(data[:4]) != "OCHK" {
+				return nil, fmt.Errorf("invalid object header continuation at %#x", blocks[i].addr)
+			}
+			c.pos = 4
+		}
+		end := len(data)
+		if v2 && i > 0 {
+			end -= 4 // checksum
+		}
+
+		for c.err == nil {
+			var msg h5Message
+			var size, msgFlags int
+			if v2 {
+				if end-c.pos < 4 {
+					break
+				}
+				msg.typ = uint16(c.u8())
+				size = int(c.u16())
+				msgFlags = int(c.u8())
+				if flags&0x04 != 0 {
+					c.skip(2)
+				}
+			} else {
+				if end-c.pos < 8 {
+					break
+				}
+				msg.typ = c.u16()
+				size = int(c.u16())
+				msgFlags = int(c.u8())
+				c.skip(3)
+			}
+			msg.data = c.bytes(size)
+			if c.err != nil {
+				break
+			}
+			if msgFlags&0x02 != 0 && (msg.typ == h5Datatype || msg.typ == h5Filters) {
+				return nil, fmt.Errorf("shared header messages are not supported")
+			}
+			if msg.typ == h5Continuation {
+				mc := &h5Cursor{b: msg.data}
+				blocks = append(blocks, block{mc.uint(f.offsetSize), mc.uint(f.lengthSize)})
+				continue
+			}
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+// readDatasetAt reads the dataset at a path
+func (f *h5File) readDatasetAt(path string) (*numericArray, error) {
+	addr, err := f.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	array, err := f.readDataset(addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return array, nil
+}
+
+// readDataset decodes a numeric dataset
+func (f *h5File) readDataset(addr uint64) (*numericArray, error) {
+	messages, err := f.messages(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var dims []int
+	var decode func([]byte) float64
+	var elemSize int
+	var layout []byte
+	var filters []h5Filter
+	array := &numericArray{}
+	for _, msg := range messages {
+		c := &h5Cursor{b: msg.data}
+		switch msg.typ {
+		case h5Dataspace:
+			version := c.u8()
+			rank := int(c.u8())
+			c.skip(1)
+			if version == 1 {
+				c.skip(5)
+			} else {
+				c.skip(1)
+			}
+			for i := 0; i < rank; i++ {
+				dims = append(dims, int(c.uint(f.lengthSize)))
+			}
+		case h5Datatype:
+			class := c.u8() & 0x0f
+			bits := c.u8()
+			c.skip(2)
+			elemSize = int(c.u32())
+			var order binary.ByteOrder = binary.LittleEndian
+			if bits&1 != 0 {
+				order = binary.BigEndian
+			}
+			kind := byte('f')
+			switch class {
+			case 0:
+				kind = 'u'
+				if bits&0x08 != 0 {
+					kind = 'i'
+				}
+			case 1:
+			default:
+				return nil, fmt.Errorf("unsupported datatype class %d", class)
+			}
+			if decode, err = npyDecoder(kind, elemSize, order); err != nil {
+				return nil, err
+			}
+			array.rawPixels = kind == 'u' && elemSize == 1
+		case h5Layout:
+			layout = msg.data
+		case h5Filters:
+			filters = parseFilters(c)
+		}
+		if c.err != nil {
+			return nil, fmt.Errorf("truncated header message %#x", msg.typ)
+		}
+	}
+	if decode == nil || layout == nil {
+		return nil, fmt.Errorf("object is not a dataset")
+	}
+
+	count := 1
+	for _, dim := range dims {
+		count *= dim
+	}
+	raw, err := f.readLayout(layout, dims, elemSize, filters)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < count*elemSize {
+		return nil, fmt.Errorf("dataset holds %d bytes, expected %d", len(raw), count*elemSize)
+	}
+
+	array.shape = dims
+	array.values = make([]float64, count)
+	for i := range array.values {
+		array.values[i] = decode(raw[i*elemSize : (i+1)*elemSize])
+	}
+	return array, nil
+}
+
+// parseFilters decodes a filter pipeline message
+func parseFilters(c *h5Cursor) []h5Filter {
+	version := c.u8()
+	count := int(c.u8())
+	if version == 1 {
+		c.skip(6)
+	}
+	filters := make([]h5Filter, count)
+	for i := range filters {
+		filters[i].id = c.u16()
+		nameLength := 0
+		if version == 1 || filters[i].id >= 256 {
+			nameLength = int(c.u16())
+		}
+		c.skip(2)
+		params := int(c.u16())
+		if version == 1 {
+			nameLength = (nameLength + 7) &^ 7
+		}
+		c.skip(nameLength)
+		for j := 0; j < params; j++ {
+			filters[i].params = append(filters[i].params, c.u32())
+		}
+		if version == 1 && params%2 == 1 {
+			c.skip(4)
+		}
+	}
+	return filters
+}
+
+// readLayout reads the raw bytes of a dataset according to its layout
+func (f *h5File) readLayout(layout []byte, dims []int, elemSize int, filters []h5Filter) ([]byte, error) {
+	count := 1
+	for _, dim := range dims {
+		count *= dim
+	}
+	size := count * elemSize
+
+	c := &h5Cursor{b: layout}
+	version := c.u8()
+	var class byte
+	var chunkDims []int
+	var addr uint64
+	if version < 3 {
+		rank := int(c.u8())
+		class = c.u8()
+		c.skip(5)
+		if class != 0 {
+			addr = c.uint(f.offsetSize)
+		}
+		for i := 0; i < rank; i++ {
+			chunkDims = append(chunkDims, int(c.u32()))
+		}
+		if class == 0 {
+			return c.bytes(int(c.u32())), c.err
+		}
+	} else {
+		class = c.u8()
+		switch class {
+		case 0:
+			return c.bytes(int(c.u16())), c.err
+		case 1:
+			addr = c.uint(f.offsetSize)
+		case 2:
+			if version != 3 {
+				return nil, fmt.Errorf("unsupported chunk index (layout version %d)", version)
+			}
+			rank := int(c.u8())
+			addr = c.uint(f.offsetSize)
+			for i := 0; i < rank; i++ {
+				chunkDims = append(chunkDims, int(c.u32()))
+			}
+		}
+	}
+	if c.err != nil {
+		return nil, fmt.Errorf("truncated layout message")
+	}
+
+	undefined := addr == h5Undefined>>(64-8*f.offsetSize)
+	switch class {
+	case 1:
+		if undefined {
+			return make([]byte, size), nil
+		}
+		return f.readAt(addr, size)
+	case 2:
+		out := make([]byte, size)
+		if undefined {
+			return out, nil
+		}
+		// The last chunk dimension is the element size
+		return out, f.readChunks(addr, dims, chunkDims[:len(chunkDims)-1], elemSize, filters, out)
+	}
+	return nil, fmt.Errorf("unsupported layout class %d", class)
+}
+
+// readChunks decodes every chunk of a chunked dataset into out
+func (f *h5File) readChunks(btree uint64, dims, chunkDims []int, elemSize int, filters []h5Filter, out []byte) error {
+	if len(chunkDims) != len(dims) {
+		return fmt.Errorf("chunk rank %d does not match dataset rank %d", len(chunkDims), len(dims))
+	}
+	rank := len(dims)
+	keySize := 8 + 8*(rank+1)
+	return f.walkBTree(btree, 1, keySize, func(key []byte, child uint64) error {
+		c := &h5Cursor{b: key}
+		size := int(c.u32())
+		mask := c.u32()
+		offsets := make([]int, rank)
+		for i := range offsets {
+			offsets[i] = int(c.u64())
+		}
+
+		chunk, err := f.readAt(child, size)
+		if err != nil {
+			return err
+		}
+		for i := len(filters) - 1; i >= 0; i-- {
+			if mask&(1<<i) != 0 {
+				continue
+			}
+			if chunk, err = applyFilter(filters[i], chunk, elemSize); err != nil {
+				return err
+			}
+		}
+		copyChunk(out, chunk, dims, chunkDims, offsets, elemSize)
+		return nil
+	})
+}
+
+// applyFilter reverses one filter of the pipeline
+func applyFilter(filter h5Filter, data []byte, elemSize int) ([]byte, error) {
+	switch filter.id {
+	case 1: // deflate
+		reader, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to inflate chunk: %v", err)
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	case 2: // shuffle
+		size := elemSize
+		if len(filter.params) > 0 {
+			size = int(filter.params[0])
+		}
+		n := len(data) / size
+		out := make([]byte, len(data))
+		for i := 0; i < n; i++ {
+			for j := 0; j < size; j++ {
+				out[i*size+j] = data[j*n+i]
+			}
+		}
+		copy(out[n*size:], data[n*size:])
+		return out, nil
+	case 3: // fletcher32 checksum
+		if len(data) < 4 {
+			return nil, fmt.Errorf("truncated chunk")
+		}
+		return data[:len(data)-4], nil
+	}
+	return nil, fmt.Errorf("unsupported filter %d", filter.id)
+}
+
+// copyChunk copies a chunk into the dataset buffer, clipping edge chunks
+func copyChunk(out, chunk []byte, dims, chunkDims, offsets []int, elemSize int) {
+	rank := len(dims)
+	last := rank - 1
+	rowLength := min(chunkDims[last], dims[last]-offsets[last])
+	if rowLength <= 0 {
+		return
+	}
+
+	index := make([]int, rank) // position within the chunk, last dim always 0
+	for {
+		src, dst := 0, 0
+		inside := true
+		for d := 0; d < rank; d++ {
+			if offsets[d]+index[d] >= dims[d] {
+				inside = false
+			}
+			src = src*chunkDims[d] + index[d]
+			dst = dst*dims[d] + offsets[d] + index[d]
+		}
+		if inside && (src+rowLength)*elemSize <= len(chunk) {
+			copy(out[dst*elemSize:(dst+rowLength)*elemSize], chunk[src*elemSize:(src+rowLength)*elemSize])
+		}
+
+		// Advance to the next row of the chunk
+		d := last - 1
+		for ; d >= 0; d-- {
+			index[d]++
+			if index[d] < chunkDims[d] {
+				break
+			}
+			index[d] = 0
+		}
+		if d < 0 {
+			return
+		}
+	}
+}
+
+// h5Cursor reads little-endian fields. The first overrun is kept and makes
+// every later read return zero values.
+type h5Cursor struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (c *h5Cursor) bytes(n int) []byte {
+	if n < 0 || c.pos+n > len(c.b) {
+		c.err = io.ErrUnexpectedEOF
+		c.pos = len(c.b)
+		return nil
+	}
+	b := c.b[c.pos : c.pos+n]
+	c.pos += n
+	return b
+}
+
+func (c *h5Cursor) skip(n int) { c.bytes(n) }
+
+// uint reads an unsigned integer of n bytes
+func (c *h5Cursor) uint(n int) uint64 {
+	var value uint64
+	for i, b := range c.bytes(n) {
+		value |= uint64(b) << (8 * i)
+	}
+	return value
+}
+
+func (c *h5Cursor) u8() byte    { return byte(c.uint(1)) }
+func (c *h5Cursor) u16() uint16 { return uint16(c.uint(2)) }
+func (c *h5Cursor) u32() uint32 { return uint32(c.uint(4)) }
+func (c *h5Cursor) u64() uint64 { return c.uint(8) }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// testdata/mnist.h5 is assembled by testdata/gen_hdf5.py after h5py's default
+// layout: a version 0 superblock, version 1 object headers and a symbol table
+// root group. Files written by h5py itself, with
+// testdata/gen_hdf5_reference.py, are read from testdata/reference when
+// present.
+
+func TestLoadHDF5Dataset(t *testing.T) {
+	checkHDF5Fixture(t, "testdata/mnist.h5")
+}
+
+func TestLoadReferenceHDF5(t *testing.T) {
+	files, _ := filepath.Glob("testdata/reference/*.h5")
+	if len(files) == 0 {
+		t.Skip("no reference files, run testdata/gen_hdf5_reference.py")
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			checkHDF5Fixture(t, file)
+		})
+	}
+}
+
+// checkHDF5Fixture checks the contiguous and the chunked datasets the fixture
+// generators write
+func checkHDF5Fixture(t *testing.T, path string) {
+	t.Helper()
+	samples, labels, err := loadHDF5Dataset(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 3 {
+		t.Fatalf("got %d samples, want 3", len(samples))
+	}
+	for i, sample := range samples {
+		if len(sample) != 784 {
+			t.Fatalf("sample %d has %d pixels, want 784", i, len(sample))
+		}
+		for j, pixel := range sample {
+			// uint8 pixels are scaled to 0-1
+			if want := float64((31*i+7*j)%256) / 255; pixel != want {
+				t.Fatalf("pixel %d of sample %d is %v, want %v", j, i, pixel, want)
+			}
+		}
+	}
+	if want := []int{7, 2, 1}; !slices.Equal(labels, want) {
+		t.Errorf("got labels %v, want %v", labels, want)
+	}
+
+	f, err := openHDF5(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.file.Close()
+
+	// x_train is stored in shuffled, deflated chunks of two rows, the last
+	// one reaching past the end of the dataset
+	array, err := f.readDatasetAt("x_train")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(array.shape) != 2 || array.shape[0] != 3 || array.shape[1] != 784 {
+		t.Fatalf("got shape %v, want [3 784]", array.shape)
+	}
+	for i, value := range array.values {
+		want := float64(float32(float64(i%256) / 255))
+		if math.Abs(value-want) > 1e-7 {
+			t.Fatalf("value %d is %v, want %v", i, value, want)
+		}
+	}
+}
+
+func TestHDF5MissingDataset(t *testing.T) {
+	f, err := openHDF5("testdata/mnist.h5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.file.Close()
+	if _, err := f.lookup("x_val"); err == nil {
+		t.Error("lookup of a missing dataset succeeded")
+	}
+}
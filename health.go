@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// readinessTimeout bounds each readiness probe
+const readinessTimeout = 10 * time.Second
+
+// readinessURL returns the URL probed before starting for a resolved
+// endpoint: --health-path on the endpoint's host if given, else the selected
+// target's status URL, or "" when there is none. TF Serving's model status
+// and Triton's ready URLs are derived from the endpoint's predict and infer
+// paths, so they follow the model named in --api.
+func readinessURL(endpoint, targetType, healthPath string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	path := healthPath
+	if path == "" {
+		switch targetType {
+		case "tfserving":
+			// /v1/models/mnist/versions/3:predict -> /v1/models/mnist/versions/3
+			last := strings.LastIndexByte(u.Path, '/')
+			colon := strings.LastIndexByte(u.Path, ':')
+			if !strings.HasPrefix(u.Path, "/v1/models/") || colon < last {
+				return "", nil
+			}
+			path = u.Path[:colon]
+		case "triton":
+			if !strings.HasPrefix(u.Path, "/v2/models/") || !strings.HasSuffix(u.Path, "/infer") {
+				return "", nil
+			}
+			path = strings.TrimSuffix(u.Path, "/infer") + "/ready"
+		case "torchserve":
+			path = "/ping"
+		case "seldon":
+			path = "/health/ping"
+		case "bentoml":
+			path = "/readyz"
+		case "mlflow":
+			path = "/health"
+		default:
+			return "", nil
+		}
+	}
+	u.Path, u.RawPath, u.RawQuery = path, "", ""
+	return u.String(), nil
+}
+
+// checkReady probes a readiness URL and returns nil once the model can serve
+// requests
+func checkReady(probeURL string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), readinessTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL, nil)
+	if err != nil {
+		return err
+	}
+	for key, values := range extraHeaders {
+		req.Header[key] = values
+	}
+	if signRequest != nil {
+		if err := signRequest(req, nil); err != nil {
+			return err
+		}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", probeURL, resp.Status)
+	}
+
+	// TF Serving answers the model status request even while versions are
+	// still loading, so look for one that is AVAILABLE
+	var status struct {
+		ModelVersionStatus []struct {
+			Version string `json:"version"`
+			State   string `json:"state"`
+		} `json:"model_version_status"`
+	}
+	if json.Unmarshal(body, &status) == nil && len(status.ModelVersionStatus) > 0 {
+		for _, version := range status.ModelVersionStatus {
+			if version.State == "AVAILABLE" {
+				return nil
+			}
+		}
+		return fmt.Errorf("no model version is AVAILABLE yet (state %s)", status.ModelVersionStatus[0].State)
+	}
+	return nil
+}
+
+// waitUntilReady checks every endpoint with a readiness URL until all are
+// ready. With a zero wait it fails on the first unready endpoint, otherwise
+// it retries with exponential backoff until the wait is used up.
+func waitUntilReady(endpoints []string, targetType, healthPath string, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	backoff := time.Second
+	for _, endpoint := range endpoints {
+		probeURL, err := readinessURL(endpoint, targetType, healthPath)
+		if err != nil {
+			return err
+		}
+		if probeURL == "" {
+			continue
+		}
+		for {
+			err := checkReady(probeURL)
+			if err == nil {
+				logger.Infof("%s is ready", endpoint)
+				break
+			}
+			if time.Now().Add(backoff).After(deadline) {
+				return fmt.Errorf("%s is not ready: %v", endpoint, err)
+			}
+			logger.Infof("%s is not ready (%v), retrying in %s", endpoint, err, backoff)
+			time.Sleep(backoff)
+			backoff = min(2*backoff, 30*time.Second)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestReadinessURL(t *testing.T) {
+	tests := []struct {
+		endpoint, targetType, healthPath, want string
+	}{
+		{"http://tf:8501/v1/models/digits/versions/3:predict", "tfserving", "", "http://tf:8501/v1/models/digits/versions/3"},
+		{"http://tf:8501/v1/models/digits:predict", "tfserving", "", "http://tf:8501/v1/models/digits"},
+		{"http://svc:8080/predict", "tfserving", "", ""},
+		{"http://triton:8000/v2/models/digits/infer", "triton", "", "http://triton:8000/v2/models/digits/ready"},
+		{"http://ts:8080/predictions/digits", "torchserve", "", "http://ts:8080/ping"},
+		{"http://svc:8080/predict?x=1", "tfserving", "/healthz", "http://svc:8080/healthz"},
+		{"http://svc:8080/invocations", "onnx", "", ""},
+	}
+	for _, test := range tests {
+		got, err := readinessURL(test.endpoint, test.targetType, test.healthPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("readinessURL(%q, %q, %q) = %q, want %q", test.endpoint, test.targetType, test.healthPath, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// maxTrackedLatency is the highest latency the histogram tells apart, in
+// microseconds; slower requests are recorded as taking this long
+const maxTrackedLatency = 3600 * 1000 * 1000
+
+// latencyHistogram records the latency of successful requests in
+// microseconds, in constant memory. setupLatencyHistogram sets its
+// precision.
+var latencyHistogram = hdrhistogram.New(1, maxTrackedLatency, 3)
+
+// intervalHistograms record the latencies since the last point of each
+// periodic sink (--influx, --graphite)
+var intervalHistograms []*hdrhistogram.Histogram
+
+// newIntervalHistogram adds a histogram recording the latencies from now on,
+// at the precision of latencyHistogram. Callers must hold metricsMutex.
+func newIntervalHistogram() *hdrhistogram.Histogram {
+	histogram := hdrhistogram.New(1, maxTrackedLatency, int(latencyHistogram.SignificantFigures()))
+	intervalHistograms = append(intervalHistograms, histogram)
+	return histogram
+}
+
+// setupLatencyHistogram sets the number of significant digits latencies are
+// recorded with (--latency-precision)
+func setupLatencyHistogram(digits int) error {
+	if digits < 1 || digits > 5 {
+		return fmt.Errorf("--latency-precision must be between 1 and 5 significant digits")
+	}
+	latencyHistogram = hdrhistogram.New(1, maxTrackedLatency, digits)
+	return nil
+}
+
+// recordLatency records a latency in milliseconds and updates the average.
+// Callers must hold metricsMutex.
+func recordLatency(latency float64) {
+	latencyHistogram.RecordValue(min(int64(latency*1000), maxTrackedLatency))
+	for _, histogram := range intervalHistograms {
+		histogram.RecordValue(min(int64(latency*1000), maxTrackedLatency))
+	}
+	latencySum += latency
+	averageLatency = latencySum / float64(latencyHistogram.TotalCount())
+}
+
+// histogramPercentile is a percentile of the recorded latencies in
+// milliseconds, 0 without any. Callers must hold metricsMutex.
+func histogramPercentile(percentile float64) float64 {
+	return float64(latencyHistogram.ValueAtPercentile(percentile)) / 1000
+}
+
+// latencyRows is the metrics row of the latency percentiles. Callers must
+// hold metricsMutex.
+func latencyRows() [][]string {
+	if latencyHistogram.TotalCount() == 0 {
+		return nil
+	}
+	percentiles := fmt.Sprintf("%.2f / %.2f / %.2f / %.2f / %.2f",
+		histogramPercentile(50), histogramPercentile(90), histogramPercentile(95),
+		histogramPercentile(99), float64(latencyHistogram.Max())/1000)
+	return [][]string{{"Latency p50/p90/p95/p99/max (ms)", percentiles}}
+}
+
+// saveHistogram writes the latency distribution in milliseconds to a file in
+// the HdrHistogram percentile format (.hgrm), which HdrHistogram's plotter
+// and other tools read
+func saveHistogram(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	metricsMutex.Lock()
+	_, err = latencyHistogram.PercentilesPrint(file, 5, 1000)
+	metricsMutex.Unlock()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
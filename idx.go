@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// idxUnsignedByte is the IDX type code of unsigned byte data, the only type
+// used by the MNIST files
+const idxUnsignedByte = 0x08
+
+// idxImagesName matches the images part of the MNIST IDX file names, e.g.
+// train-images-idx3-ubyte or train-images.idx3-ubyte
+var idxImagesName = regexp.MustCompile(`images([-.])idx3`)
+
+// isIDXFile reports whether a file, gzip-compressed or not, starts with the
+// IDX magic number of unsigned byte data: two zero bytes, the type code and
+// the number of dimensions
+func isIDXFile(filename string) bool {
+	reader, closeIDX, err := openIDX(filename)
+	if err != nil {
+		return false
+	}
+	defer closeIDX()
+	var header [4]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return false
+	}
+	return header[0] == 0 && header[1] == 0 && header[2] == idxUnsignedByte && header[3] > 0
+}
+
+// idxLabelsFile returns the label file belonging to an IDX image file, e.g.
+// train-labels-idx1-ubyte for train-images-idx3-ubyte and
+// train-labels.idx1-ubyte for train-images.idx3-ubyte
+func idxLabelsFile(filename string) string {
+	dir, base := filepath.Split(filename)
+	return dir + idxImagesName.ReplaceAllString(base, "labels${1}idx1")
+}
+
+// loadIDXDataset loads the images of an IDX file, scaled to [0, 1], and the
+// labels from the matching labels file if there is one next to it
+func loadIDXDataset(filename string) ([][]float64, []int, error) {
+	dims, data, err := readIDX(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(dims) < 2 {
+		return nil, nil, fmt.Errorf("%s is not an image file (%d dimensions)", filename, len(dims))
+	}
+
+	size := 1
+	for _, dim := range dims[1:] {
+		size *= dim
+	}
+	samples := make([][]float64, dims[0])
+	for i := range samples {
+		sample := make([]float64, size)
+		for j, pixel := range data[i*size : (i+1)*size] {
+			sample[j] = float64(pixel) / 255
+		}
+		samples[i] = sample
+	}
+
+	labelsFile := idxLabelsFile(filename)
+	if labelsFile == filename {
+		return samples, nil, nil
+	}
+	if _, err := os.Stat(labelsFile); os.IsNotExist(err) {
+		return samples, nil, nil
+	}
+	dims, data, err = readIDX(labelsFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(dims) != 1 || dims[0] != len(samples) {
+		return nil, nil, fmt.Errorf("%s holds %d labels for %d images", labelsFile, len(data), len(samples))
+	}
+	labels := make([]int, len(data))
+	for i, label := range data {
+		labels[i] = int(label)
+	}
+	return samples, labels, nil
+}
+
+// openIDX opens an IDX file, decompressing it when it starts with the gzip
+// magic number. The returned function closes it.
+func openIDX(filename string) (io.Reader, func(), error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open file: %v", err)
+	}
+
+	buffered := bufio.NewReader(file)
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("failed to decompress %s: %v", filename, err)
+		}
+		return gz, func() { gz.Close(); file.Close() }, nil
+	}
+	return buffered, func() { file.Close() }, nil
+}
+
+// readIDX reads an unsigned byte IDX file, gzip-compressed or not, and
+// returns its dimensions and data
+func readIDX(filename string) ([]int, []byte, error) {
+	reader, closeIDX, err := openIDX(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer closeIDX()
+
+	var header [4]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, nil, fmt.Errorf("failed to read IDX header: %v", err)
+	}
+	if header[0] != 0 || header[1] != 0 || header[2] != idxUnsignedByte {
+		return nil, nil, fmt.Errorf("%s is not an unsigned byte IDX file", filename)
+	}
+
+	dims := make([]int, header[3])
+	total := 1
+	for i := range dims {
+		var dim uint32
+		if err := binary.Read(reader, binary.BigEndian, &dim); err != nil {
+			return nil, nil, fmt.Errorf("failed to read IDX dimensions: %v", err)
+		}
+		dims[i] = int(dim)
+		total *= dims[i]
+	}
+
+	data := make([]byte, total)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, nil, fmt.Errorf("failed to read IDX data: %v", err)
+	}
+	return dims, data, nil
+}
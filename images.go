@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// mnistSide is the width and height of an MNIST digit
+const mnistSide = 28
+
+var (
+	// resizeImages scales images of other sizes down (or up) to 28x28
+	resizeImages bool
+	// invertImages turns dark-on-light photos into MNIST's light-on-dark
+	invertImages bool
+)
+
+// imageExtensions are the image formats the directory loader decodes
+var imageExtensions = map[string]bool{".png": true, ".jpg": true, ".jpeg": true}
+
+// loadImageDirectory decodes every PNG and JPEG image below dir into a
+// grayscale pixel vector scaled to [0, 1]. Images in directories named after
+// a single digit (e.g. 7/photo.png) are labelled with that digit; labels are
+// only returned if every image has one.
+func loadImageDirectory(dir string) ([][]float64, []int, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && imageExtensions[strings.ToLower(filepath.Ext(path))] {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list images: %v", err)
+	}
+	if len(files) == 0 {
+		return nil, nil, fmt.Errorf("no PNG or JPEG images in %s", dir)
+	}
+	sort.Strings(files)
+
+	samples := make([][]float64, 0, len(files))
+	labels := make([]int, 0, len(files))
+	for _, path := range files {
+		sample, err := loadImage(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		samples = append(samples, sample)
+
+		if labels != nil {
+			parent := filepath.Base(filepath.Dir(path))
+			if len(parent) == 1 && parent[0] >= '0' && parent[0] <= '9' {
+				labels = append(labels, int(parent[0]-'0'))
+			} else {
+				labels = nil
+			}
+		}
+	}
+	return samples, labels, nil
+}
+
+// loadImage decodes one image into a flattened grayscale pixel vector
+func loadImage(path string) ([]float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image: %v", err)
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", path, err)
+	}
+
+	bounds := img.Bounds()
+	if bounds.Dx() != mnistSide || bounds.Dy() != mnistSide {
+		if !resizeImages {
+			return nil, fmt.Errorf("%s is %dx%d, not %dx%d (use --resize-images)", path, bounds.Dx(), bounds.Dy(), mnistSide, mnistSide)
+		}
+		scaled := image.NewGray(image.Rect(0, 0, mnistSide, mnistSide))
+		draw.ApproxBiLinear.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+		img = scaled
+		bounds = scaled.Bounds()
+	}
+
+	sample := make([]float64, 0, mnistSide*mnistSide)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pixel := float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y) / 255
+			if invertImages {
+				pixel = 1 - pixel
+			}
+			sample = append(sample, pixel)
+		}
+	}
+	return sample, nil
+}
+
+// encodeSamplePNG encodes a 28x28 sample as a grayscale PNG. raw tells
+// whether the sample holds 0-255 pixels or 0-1 pixels; values out of range
+// are clamped.
+func encodeSamplePNG(sample []float64, raw bool) ([]byte, error) {
+	if len(sample) != mnistSide*mnistSide {
+		return nil, fmt.Errorf("sample has %d values, not %d", len(sample), mnistSide*mnistSide)
+	}
+	scale := 255.0
+	if raw {
+		scale = 1
+	}
+
+	img := image.NewGray(image.Rect(0, 0, mnistSide, mnistSide))
+	for i, pixel := range sample {
+		img.Pix[i] = uint8(math.Max(0, math.Min(255, math.Round(pixel*scale))))
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestEncodeSamplePNGScale(t *testing.T) {
+	// a faint sample of a 0-255 dataset has no pixel above 1 either
+	sample := make([]float64, mnistSide*mnistSide)
+	sample[0] = 1
+	for _, test := range []struct {
+		raw  bool
+		want uint8
+	}{{true, 1}, {false, 255}} {
+		encoded, err := encodeSamplePNG(sample, test.raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := img.(*image.Gray).Pix[0]; got != test.want {
+			t.Errorf("raw %v: first pixel is %d, want %d", test.raw, got, test.want)
+		}
+	}
+}
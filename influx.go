@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+var (
+	// influxTarget, when set, receives a point in the InfluxDB line protocol
+	// every influxInterval: an http(s) write URL, or a file appended to
+	influxTarget   string
+	influxInterval time.Duration
+	// influxToken authenticates writes to InfluxDB 2 ("Authorization: Token")
+	influxToken string
+	// influxHistogram records the latencies since the last point
+	influxHistogram *hdrhistogram.Histogram
+)
+
+// influxCounts are the cumulative counts at the last point, to write the
+// differences
+var influxCounts struct {
+	success, failed int
+	at              time.Time
+}
+
+// startInflux writes a point every --influx-interval until stop is closed,
+// and a last one then. The returned channel is closed after it.
+func startInflux(stop <-chan struct{}) <-chan struct{} {
+	if influxToken == "" {
+		influxToken = os.Getenv("INFLUX_TOKEN")
+	}
+	metricsMutex.Lock()
+	influxHistogram = newIntervalHistogram()
+	influxCounts.at = time.Now()
+	metricsMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(influxInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-stop:
+				if err := writeInflux(influxPoint()); err != nil {
+					logger.Errorf("Failed to write the last --influx point: %v", err)
+				}
+				return
+			}
+			if err := writeInflux(influxPoint()); err != nil {
+				logToWidget(fmt.Sprintf("Failed to write to --influx: %v", err))
+			}
+		}
+	}()
+	return done
+}
+
+// influxPoint is the line of the interval since the last point: the
+// requests, failures and rate, and the latency percentiles in ms
+func influxPoint() string {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	now := time.Now()
+	success, failed := successRequests-influxCounts.success, failedRequests-influxCounts.failed
+	rate := float64(success+failed) / now.Sub(influxCounts.at).Seconds()
+	influxCounts.success, influxCounts.failed, influxCounts.at = successRequests, failedRequests, now
+
+	fields := fmt.Sprintf("requests=%di,failures=%di,rps=%.3f,in_flight=%di,bots=%di", success+failed, failed, rate, busyWorkers, activeBots)
+	if influxHistogram.TotalCount() > 0 {
+		fields += fmt.Sprintf(",p50=%.3f,p90=%.3f,p95=%.3f,p99=%.3f,max=%.3f,mean=%.3f",
+			float64(influxHistogram.ValueAtPercentile(50))/1000, float64(influxHistogram.ValueAtPercentile(90))/1000,
+			float64(influxHistogram.ValueAtPercentile(95))/1000, float64(influxHistogram.ValueAtPercentile(99))/1000,
+			float64(influxHistogram.Max())/1000, influxHistogram.Mean()/1000)
+	}
+	influxHistogram.Reset()
+	return fmt.Sprintf("mnist_bot,run_id=%s %s %d\n", escapeInfluxTag(pushRunID), fields, now.UnixNano())
+}
+
+// escapeInfluxTag escapes the characters the line protocol gives a meaning
+// to in tag values
+func escapeInfluxTag(value string) string {
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(value)
+}
+
+// writeInflux posts a line to the InfluxDB write URL, or appends it to the
+// file
+func writeInflux(line string) error {
+	if !strings.HasPrefix(influxTarget, "http://") && !strings.HasPrefix(influxTarget, "https://") {
+		file, err := os.OpenFile(influxTarget, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		if _, err := file.WriteString(line); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}
+	req, err := http.NewRequest(http.MethodPost, influxTarget, strings.NewReader(line))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if influxToken != "" {
+		req.Header.Set("Authorization", "Token "+influxToken)
+	}
+	resp, err := pushClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var body bytes.Buffer
+		body.ReadFrom(resp.Body)
+		return fmt.Errorf("InfluxDB answered %s: %s", resp.Status, strings.TrimSpace(body.String()))
+	}
+	return nil
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// kafkaTimeout bounds dialing and each produce round trip
+const kafkaTimeout = 10 * time.Second
+
+var (
+	kafkaFormatter = kmsg.NewRequestFormatter(kmsg.FormatterClientID("mnist-bot"))
+	crc32c         = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// kafkaOptions holds the settings of the Kafka producer
+type kafkaOptions struct {
+	brokers   string
+	topic     string
+	encoding  string
+	schemaID  int
+	acks      int
+	tlsConfig *tls.Config
+}
+
+// kafkaConn is a connection to one broker. Requests are serialized so every
+// response can be matched to its request and timed as a round trip.
+type kafkaConn struct {
+	mu            sync.Mutex
+	addr          string
+	tlsConfig     *tls.Config
+	conn          net.Conn
+	reader        *bufio.Reader
+	correlationID int32
+}
+
+// kafkaProducer produces samples to the partitions of one topic, sending
+// every record to the partition's leader. The leaders are looked up again
+// when a broker says it no longer leads a partition or cannot be reached.
+type kafkaProducer struct {
+	opts       kafkaOptions
+	mu         sync.RWMutex
+	partitions []int32
+	leaders    map[int32]*kafkaConn
+	conns      map[string]*kafkaConn // by broker address, kept across refreshes
+	generation uint64                // counts the metadata refreshes
+	refreshMu  sync.Mutex
+	next       atomic.Uint64
+}
+
+// Kafka error codes after which the partition leaders are looked up again
+const (
+	kafkaUnknownTopicOrPartition = 3
+	kafkaLeaderNotAvailable      = 5
+	kafkaNotLeaderOrFollower     = 6
+)
+
+// newKafkaProducer looks up the topic's partition leaders on the first
+// reachable broker
+func newKafkaProducer(opts kafkaOptions) (*kafkaProducer, error) {
+	if opts.encoding != "json" && opts.encoding != "avro" {
+		return nil, fmt.Errorf("unknown Kafka encoding %q (expected json or avro)", opts.encoding)
+	}
+
+	metadata, err := fetchKafkaMetadata(opts)
+	if err != nil {
+		return nil, err
+	}
+	p := &kafkaProducer{opts: opts, conns: map[string]*kafkaConn{}}
+	if err := p.remap(metadata); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// fetchKafkaMetadata fetches the topic's metadata from the first reachable
+// broker
+func fetchKafkaMetadata(opts kafkaOptions) (*kmsg.MetadataResponse, error) {
+	var lastErr error
+	for _, broker := range strings.Split(opts.brokers, ",") {
+		broker = strings.TrimSpace(broker)
+		if broker == "" {
+			continue
+		}
+		conn := &kafkaConn{addr: broker, tlsConfig: opts.tlsConfig}
+		metadata, err := conn.metadata(opts.topic)
+		conn.close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return metadata, nil
+	}
+	if lastErr == nil {
+		return nil, fmt.Errorf("no Kafka brokers given")
+	}
+	return nil, fmt.Errorf("failed to fetch Kafka metadata: %v", lastErr)
+}
+
+// remap maps each partition of the topic to a connection to its leader,
+// sharing connections between partitions and reusing those already open.
+// The current mapping is kept if the metadata has no usable leaders.
+func (p *kafkaProducer) remap(metadata *kmsg.MetadataResponse) error {
+	brokers := map[int32]string{}
+	for _, broker := range metadata.Brokers {
+		brokers[broker.NodeID] = net.JoinHostPort(broker.Host, strconv.Itoa(int(broker.Port)))
+	}
+	if len(metadata.Topics) != 1 {
+		return fmt.Errorf("topic %q not found", p.opts.topic)
+	}
+	topic := metadata.Topics[0]
+	if topic.ErrorCode != 0 {
+		return fmt.Errorf("topic %q: Kafka error code %d", p.opts.topic, topic.ErrorCode)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var partitions []int32
+	leaders := map[int32]*kafkaConn{}
+	for _, partition := range topic.Partitions {
+		addr, ok := brokers[partition.Leader]
+		if partition.ErrorCode != 0 || !ok {
+			continue
+		}
+		conn, ok := p.conns[addr]
+		if !ok {
+			conn = &kafkaConn{addr: addr, tlsConfig: p.opts.tlsConfig}
+			p.conns[addr] = conn
+		}
+		partitions = append(partitions, partition.Partition)
+		leaders[partition.Partition] = conn
+	}
+	if len(partitions) == 0 {
+		return fmt.Errorf("topic %q has no partitions with a leader", p.opts.topic)
+	}
+	p.partitions, p.leaders = partitions, leaders
+	p.generation++
+	return nil
+}
+
+// refresh looks up the partition leaders again after a produce to the
+// mapping of the given generation failed. Bots failing together refresh
+// only once.
+func (p *kafkaProducer) refresh(generation uint64) {
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+	p.mu.RLock()
+	current := p.generation
+	p.mu.RUnlock()
+	if current != generation {
+		return // another bot already refreshed
+	}
+
+	metadata, err := fetchKafkaMetadata(p.opts)
+	if err == nil {
+		err = p.remap(metadata)
+	}
+	if err != nil {
+		logToWidget(fmt.Sprintf("Failed to refresh Kafka partition leaders: %v", err))
+	}
+}
+
+// isLeadershipError reports whether a produce error code means the
+// partition's leader has moved
+func isLeadershipError(code int16) bool {
+	return code == kafkaUnknownTopicOrPartition || code == kafkaLeaderNotAvailable || code == kafkaNotLeaderOrFollower
+}
+
+// endpoint is the label used for the producer in the metrics table
+func (p *kafkaProducer) endpoint() string {
+	return fmt.Sprintf("kafka://%s/%s", p.opts.brokers, p.opts.topic)
+}
+
+// close closes every broker connection
+func (p *kafkaProducer) close() {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, conn := range p.conns {
+		conn.mu.Lock()
+		conn.close()
+		conn.mu.Unlock()
+	}
+}
+
+// encode serializes a batch in the configured encoding: one Avro record per
+// sample, or a single record holding the whole batch
+func (p *kafkaProducer) encode(ctx context.Context, batch [][]float64) ([][]byte, error) {
+	if p.opts.encoding == "avro" {
+		values := make([][]byte, len(batch))
+		for i, sample := range batch {
+			values[i] = encodeAvroSample(sample, p.opts.schemaID)
+		}
+		return values, nil
+	}
+	value, err := encoder.Encode(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{value}, nil
+}
+
+// Send produces one batch, spreading requests round-robin across
+// partitions, and times the round trip until the broker acknowledges it
+func (p *kafkaProducer) Send(ctx context.Context, batch [][]float64) (Result, error) {
+	var result Result
+
+	values, err := p.encode(ctx, batch)
+	if err != nil {
+		return result, fmt.Errorf("error encoding record: %v", err)
+	}
+
+	p.mu.RLock()
+	partition := p.partitions[p.next.Add(1)%uint64(len(p.partitions))]
+	conn, generation := p.leaders[partition], p.generation
+	p.mu.RUnlock()
+	req := kmsg.NewPtrProduceRequest()
+	req.Version = 7
+	req.Acks = int16(p.opts.acks)
+	req.TimeoutMillis = int32(kafkaTimeout / time.Millisecond)
+	req.Topics = []kmsg.ProduceRequestTopic{{
+		Topic: p.opts.topic,
+		Partitions: []kmsg.ProduceRequestTopicPartition{{
+			Partition: partition,
+			Records:   encodeRecordBatch(values, time.Now()),
+		}},
+	}}
+
+	conn.mu.Lock()
+	startTime := time.Now()
+	body, err := conn.roundTrip(req, p.opts.acks != 0)
+	result.Latency = time.Since(startTime).Seconds() * 1000
+	conn.mu.Unlock()
+	if err != nil {
+		p.refresh(generation)
+		return result, &sendError{fmt.Errorf("error producing to Kafka: %w", err)}
+	}
+	result.Protocol = "Kafka"
+
+	if body != nil {
+		resp := kmsg.NewPtrProduceResponse()
+		resp.Version = req.Version
+		if err := resp.ReadFrom(body); err != nil {
+			return result, fmt.Errorf("invalid produce response: %v", err)
+		}
+		for _, topic := range resp.Topics {
+			for _, part := range topic.Partitions {
+				if isLeadershipError(part.ErrorCode) {
+					p.refresh(generation)
+				}
+				if part.ErrorCode != 0 {
+					return result, fmt.Errorf("produce to partition %d failed with Kafka error code %d", part.Partition, part.ErrorCode)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// metadata fetches the partition metadata of a topic
+func (c *kafkaConn) metadata(topic string) (*kmsg.MetadataResponse, error) {
+	req := kmsg.NewPtrMetadataRequest()
+	req.Version = 4
+	req.Topics = []kmsg.MetadataRequestTopic{{Topic: kmsg.StringPtr(topic)}}
+
+	body, err := c.roundTrip(req, true)
+	if err != nil {
+		return nil, err
+	}
+	resp := kmsg.NewPtrMetadataResponse()
+	resp.Version = req.Version
+	if err := resp.ReadFrom(body); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// roundTrip writes a request and, if one is expected, returns the body of
+// its response. Broken connections are closed so the next call redials.
+// Callers must hold mu when the connection is shared.
+func (c *kafkaConn) roundTrip(req kmsg.Request, expectResponse bool) ([]byte, error) {
+	if c.conn == nil {
+		if err := c.dial(); err != nil {
+			return nil, err
+		}
+	}
+	c.correlationID++
+	c.conn.SetDeadline(time.Now().Add(kafkaTimeout))
+
+	if _, err := c.conn.Write(kafkaFormatter.AppendRequest(nil, req, c.correlationID)); err != nil {
+		c.close()
+		return nil, err
+	}
+	if !expectResponse {
+		return nil, nil
+	}
+
+	var header [8]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		c.close()
+		return nil, err
+	}
+	size := int32(binary.BigEndian.Uint32(header[:4]))
+	if size < 4 {
+		c.close()
+		return nil, fmt.Errorf("invalid Kafka response size %d", size)
+	}
+	body := make([]byte, size-4)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		c.close()
+		return nil, err
+	}
+	if id := int32(binary.BigEndian.Uint32(header[4:])); id != c.correlationID {
+		c.close()
+		return nil, fmt.Errorf("Kafka response correlation ID %d does not match request %d", id, c.correlationID)
+	}
+	return body, nil
+}
+
+// dial opens the connection to the broker
+func (c *kafkaConn) dial() error {
+	dialer := &net.Dialer{Timeout: kafkaTimeout}
+	var conn net.Conn
+	var err error
+	if c.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.addr, c.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", c.addr)
+	}
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	return nil
+}
+
+// close drops the connection so the next request redials
+func (c *kafkaConn) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// encodeRecordBatch wraps values in a v2 (magic 2) record batch
+func encodeRecordBatch(values [][]byte, timestamp time.Time) []byte {
+	var records []byte
+	for i, value := range values {
+		record := kmsg.Record{OffsetDelta: int32(i), Value: value}
+		// Length covers everything after the varint itself, which is one
+		// byte while it is still zero
+		record.Length = int32(len(record.AppendTo(nil)) - 1)
+		records = record.AppendTo(records)
+	}
+
+	millis := timestamp.UnixMilli()
+	batch := kmsg.RecordBatch{
+		Magic:           2,
+		FirstTimestamp:  millis,
+		MaxTimestamp:    millis,
+		ProducerID:      -1,
+		ProducerEpoch:   -1,
+		FirstSequence:   -1,
+		LastOffsetDelta: int32(len(values) - 1),
+		NumRecords:      int32(len(values)),
+		Records:         records,
+	}
+	encoded := batch.AppendTo(nil)
+
+	// Length counts the bytes after FirstOffset and Length, and the CRC
+	// covers everything from Attributes onwards
+	binary.BigEndian.PutUint32(encoded[8:12], uint32(len(encoded)-12))
+	binary.BigEndian.PutUint32(encoded[17:21], crc32.Checksum(encoded[21:], crc32c))
+	return encoded
+}
+
+// encodeAvroSample encodes a sample as an Avro record with a single
+// "pixels" field of type array<double>. A positive schema ID prefixes the
+// Confluent Schema Registry wire format header.
+func encodeAvroSample(data []float64, schemaID int) []byte {
+	var buf []byte
+	if schemaID > 0 {
+		buf = append(buf, 0)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(schemaID))
+	}
+	if len(data) > 0 {
+		buf = binary.AppendVarint(buf, int64(len(data)))
+		for _, value := range data {
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(value))
+		}
+	}
+	return append(buf, 0)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// castagnoli computes CRC-32C bit by bit, independently of hash/crc32
+func castagnoli(data []byte) uint32 {
+	crc := ^uint32(0)
+	for _, b := range data {
+		crc ^= uint32(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0x82f63b78
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return ^crc
+}
+
+func TestCastagnoli(t *testing.T) {
+	// the standard check value of CRC-32C
+	if got := castagnoli([]byte("123456789")); got != 0xe3069283 {
+		t.Fatalf("got %#x, want 0xe3069283", got)
+	}
+}
+
+func TestEncodeRecordBatch(t *testing.T) {
+	values := [][]byte{[]byte(`{"instances":[[0,1]]}`), []byte("second")}
+	timestamp := time.UnixMilli(1700000000000)
+	encoded := encodeRecordBatch(values, timestamp)
+
+	// FirstOffset, Length, PartitionLeaderEpoch, Magic and CRC lead the batch
+	if length := binary.BigEndian.Uint32(encoded[8:12]); int(length) != len(encoded)-12 {
+		t.Errorf("length field is %d, want %d", length, len(encoded)-12)
+	}
+	if magic := encoded[16]; magic != 2 {
+		t.Errorf("magic is %d, want 2", magic)
+	}
+	if crc, want := binary.BigEndian.Uint32(encoded[17:21]), castagnoli(encoded[21:]); crc != want {
+		t.Errorf("CRC is %#x, want %#x", crc, want)
+	}
+
+	var batch kmsg.RecordBatch
+	if err := batch.ReadFrom(encoded); err != nil {
+		t.Fatal(err)
+	}
+	if batch.FirstTimestamp != timestamp.UnixMilli() || batch.NumRecords != 2 || batch.LastOffsetDelta != 1 {
+		t.Errorf("got timestamp %d, %d records and last offset delta %d", batch.FirstTimestamp, batch.NumRecords, batch.LastOffsetDelta)
+	}
+
+	records := batch.Records
+	for i, value := range values {
+		length, n := binary.Varint(records)
+		if n <= 0 || int(length)+n > len(records) {
+			t.Fatalf("record %d has an invalid length", i)
+		}
+		var record kmsg.Record
+		if err := record.ReadFrom(records[:n+int(length)]); err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if record.OffsetDelta != int32(i) || string(record.Value) != string(value) {
+			t.Errorf("record %d has offset delta %d and value %q", i, record.OffsetDelta, record.Value)
+		}
+		records = records[n+int(length):]
+	}
+	if len(records) != 0 {
+		t.Errorf("%d bytes follow the last record", len(records))
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	// csvLabelColumn treats the first column of CSV data as the digit label
+	csvLabelColumn bool
+	// labelsFile holds the labels of the --data samples, one per sample
+	labelsFile string
+)
+
+// labelledSample is a sample together with its ground-truth digit
+type labelledSample struct {
+	pixels []float64
+	label  int    // -1 when the dataset has no labels
+	source string // the --data source or --generate pattern
+	index  int    // position in the source, -1 for generated samples
+}
+
+// isCSVHeader reports whether the first record of a CSV file is a header row,
+// i.e. its first field is not a number
+func isCSVHeader(record []string) bool {
+	if len(record) == 0 {
+		return false
+	}
+	_, err := strconv.ParseFloat(strings.TrimSpace(record[0]), 64)
+	return err != nil
+}
+
+// parseCSVRecord decodes one CSV record into its pixels and, when labelled is
+// set, the label in its first column
+func parseCSVRecord(record []string, labelled bool) (labelledSample, error) {
+	sample := labelledSample{label: -1}
+	if labelled {
+		if len(record) == 0 {
+			return sample, fmt.Errorf("empty CSV record")
+		}
+		label, err := strconv.Atoi(strings.TrimSpace(record[0]))
+		if err != nil {
+			return sample, fmt.Errorf("failed to parse label: %v", err)
+		}
+		sample.label = label
+		record = record[1:]
+	}
+	sample.pixels = make([]float64, len(record))
+	for i, value := range record {
+		pixel, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return sample, fmt.Errorf("failed to parse pixel value: %v", err)
+		}
+		sample.pixels[i] = pixel
+	}
+	return sample, nil
+}
+
+// newLabelScanner splits a labels file into labels. Labels may be separated
+// by whitespace or commas, so both plain lists and JSON arrays are accepted.
+func newLabelScanner(reader io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(reader)
+	isSeparator := func(b byte) bool {
+		return b == ',' || b == '[' || b == ']' || b == ' ' || b == '\t' || b == '\n' || b == '\r'
+	}
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		start := 0
+		for start < len(data) && isSeparator(data[start]) {
+			start++
+		}
+		for end := start; end < len(data); end++ {
+			if isSeparator(data[end]) {
+				return end + 1, data[start:end], nil
+			}
+		}
+		if atEOF && start < len(data) {
+			return len(data), data[start:], nil
+		}
+		return start, nil, nil
+	})
+	return scanner
+}
+
+// scanLabel reads the next label, returning io.EOF at the end of the file
+func scanLabel(scanner *bufio.Scanner) (int, error) {
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return 0, fmt.Errorf("failed to read labels: %v", err)
+		}
+		return 0, io.EOF
+	}
+	label, err := strconv.Atoi(string(bytes.TrimSpace(scanner.Bytes())))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse label: %v", err)
+	}
+	return label, nil
+}
+
+// loadLabelsFile reads every label of a labels file
+func loadLabelsFile(filename string) ([]int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open labels file: %v", err)
+	}
+	defer file.Close()
+
+	var labels []int
+	scanner := newLabelScanner(file)
+	for {
+		label, err := scanLabel(scanner)
+		if err == io.EOF {
+			return labels, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, label)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a rate limiter shared by all bots: it holds up to burst
+// tokens, refilled at rate per second, and every request takes one
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter caps the total rate of all bots, nil when unlimited
+var rateLimiter *tokenBucket
+
+// newTokenBucket returns a full bucket
+func newTokenBucket(rate float64, burst int) (*tokenBucket, error) {
+	if rate <= 0 {
+		return nil, fmt.Errorf("--rate-limit must be positive")
+	}
+	if burst < 1 {
+		return nil, fmt.Errorf("--burst must be at least 1")
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}, nil
+}
+
+// reserve takes a token and returns how long to wait until it is due.
+// Tokens may be reserved ahead, so waiting bots are served in order.
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// waitForToken blocks until the rate limit allows another request, reporting
+// false if quit is closed meanwhile
+func waitForToken(quit <-chan struct{}) bool {
+	if rateLimiter == nil {
+		return true
+	}
+	wait := rateLimiter.reserve()
+	if wait == 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-quit:
+		return false
+	}
+}
+
+// limiterRows is the metrics row of the rate limit
+func limiterRows() [][]string {
+	if rateLimiter == nil {
+		return nil
+	}
+	return [][]string{{"Rate Limit", fmt.Sprintf("%.1f rps (burst %.0f)", rateLimiter.rate, rateLimiter.burst)}}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// targetRPS, when set, sends requests at a fixed arrival rate shared by
+	// all bots instead of one per bot every --interval
+	targetRPS float64
+	// arrivalProcess spaces arrivals: constant (periodic) or poisson
+	// (exponentially distributed gaps)
+	arrivalProcess string
+	// rampUp and rampDown scale the rate linearly from zero at the start and
+	// to zero when stopping
+	rampUp, rampDown time.Duration
+	// currentRate is the arrival rate scheduled last
+	currentRate float64
+	// droppedRequests counts scheduled arrivals no bot was ready to take
+	droppedRequests int
+)
+
+// checkArrivalProcess validates --arrival
+func checkArrivalProcess() error {
+	if arrivalProcess != "constant" && arrivalProcess != "poisson" {
+		return fmt.Errorf("unknown --arrival process %q (expected constant or poisson)", arrivalProcess)
+	}
+	return nil
+}
+
+// rateStep is the resolution arrival times are worked out at while the rate
+// changes
+const rateStep = 10 * time.Millisecond
+
+// arrivalSchedule delivers request arrivals at a rate that may change over
+// the run
+type arrivalSchedule struct {
+	arrivals chan time.Time
+	rate     func(elapsed time.Duration) float64
+	start    time.Time
+	end      time.Time     // zero while the schedule runs until stopped
+	drain    chan struct{} // closed to start ramping down
+	drainAt  time.Time     // zero until ramping down
+	done     chan struct{} // closed once the schedule has ended
+}
+
+// constantRate is a fixed rate profile
+func constantRate(rate float64) func(time.Duration) float64 {
+	return func(time.Duration) float64 { return rate }
+}
+
+// targetRate is the rate profile of --rps, following the +/- keys
+func targetRate(time.Duration) float64 {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	return targetRPS
+}
+
+// rateStepSize is how much the +/- keys change --rps by: a rate, or with
+// rateStepPercent a percentage of the current one
+var (
+	rateStepSize    float64
+	rateStepPercent bool
+)
+
+// parseRateStep parses --rate-step: "5" for 5 rps or "10%"
+func parseRateStep(spec string) error {
+	number, percent := strings.CutSuffix(spec, "%")
+	step, err := strconv.ParseFloat(number, 64)
+	if err != nil || step <= 0 {
+		return fmt.Errorf("invalid --rate-step %q (expected a positive rate or percentage)", spec)
+	}
+	rateStepSize, rateStepPercent = step, percent
+	return nil
+}
+
+// adjustRate raises (+1) or lowers (-1) the --rps rate by one step
+func adjustRate(direction int) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	step := rateStepSize
+	if rateStepPercent {
+		step = targetRPS * rateStepSize / 100
+	}
+	rate := targetRPS + float64(direction)*step
+	if rate <= 0 {
+		logToWidget(fmt.Sprintf("The rate can't go below %.1f rps by %s steps", targetRPS, formatRateStep()))
+		return
+	}
+	targetRPS = rate
+	logToWidget(fmt.Sprintf("Rate set to %.1f rps", rate))
+}
+
+// formatRateStep prints --rate-step as given
+func formatRateStep() string {
+	if rateStepPercent {
+		return fmt.Sprintf("%g%%", rateStepSize)
+	}
+	return fmt.Sprintf("%g rps", rateStepSize)
+}
+
+// scheduleArrivals delivers arrivals following a rate profile, after
+// --ramp-up, for length (or until stopped when 0) or until quit is closed.
+// Arrivals are scheduled from the previous scheduled arrival rather than
+// from the previous send, so slow responses never lower the rate; arrivals
+// no bot is ready to take are counted as dropped.
+func scheduleArrivals(rate func(elapsed time.Duration) float64, length time.Duration, quit <-chan struct{}) *arrivalSchedule {
+	s := &arrivalSchedule{
+		arrivals: make(chan time.Time),
+		rate:     rate,
+		start:    time.Now(),
+		drain:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if length > 0 {
+		s.end = s.start.Add(length)
+	}
+	go s.run(quit)
+	return s
+}
+
+// stop starts ramping the rate down over --ramp-down, returning a channel
+// closed once it reaches zero
+func (s *arrivalSchedule) stop() <-chan struct{} {
+	close(s.drain)
+	return s.done
+}
+
+func (s *arrivalSchedule) run(quit <-chan struct{}) {
+	next, ok := s.nextArrival(s.start)
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+	drain := s.drain
+	for {
+		select {
+		case now := <-timer.C:
+			if !ok {
+				close(s.done)
+				return
+			}
+			select {
+			case s.arrivals <- now:
+			case <-quit:
+				// the bots have stopped taking arrivals
+				return
+			default:
+				metricsMutex.Lock()
+				droppedRequests++
+				metricsMutex.Unlock()
+			}
+			next, ok = s.nextArrival(next)
+			timer.Reset(time.Until(next))
+		case <-drain:
+			drain = nil
+			s.drainAt = time.Now()
+			if end := s.drainAt.Add(rampDown); s.end.IsZero() || end.Before(s.end) {
+				s.end = end
+			}
+			next, ok = s.nextArrival(s.drainAt)
+			timer.Reset(time.Until(next))
+		case <-quit:
+			return
+		}
+	}
+}
+
+// rateAt is the arrival rate at t, scaled up linearly over --ramp-up and
+// down over --ramp-down
+func (s *arrivalSchedule) rateAt(t time.Time) float64 {
+	elapsed := t.Sub(s.start)
+	rate := s.rate(elapsed)
+	if elapsed < rampUp {
+		rate *= float64(elapsed) / float64(rampUp)
+	}
+	if !s.drainAt.IsZero() {
+		rate *= math.Max(0, 1-float64(t.Sub(s.drainAt))/float64(rampDown))
+	}
+	return rate
+}
+
+// nextArrival returns the time after t by which one more arrival is due: the
+// rate adds up to one request, or with poisson arrivals to an exponentially
+// distributed amount averaging one. It reports false, with the end of the
+// schedule, once no more arrivals are due before it.
+func (s *arrivalSchedule) nextArrival(t time.Time) (time.Time, bool) {
+	due := 1.0
+	if arrivalProcess == "poisson" {
+		due = rng.ExpFloat64()
+	}
+	for {
+		if !s.end.IsZero() && !t.Before(s.end) {
+			return s.end, false
+		}
+		rate := s.rateAt(t.Add(rateStep / 2))
+		metricsMutex.Lock()
+		currentRate = rate
+		metricsMutex.Unlock()
+		if rate*rateStep.Seconds() >= due {
+			arrival := t.Add(time.Duration(due / rate * float64(time.Second)))
+			if !s.end.IsZero() && !arrival.Before(s.end) {
+				return s.end, false
+			}
+			return arrival, true
+		}
+		due -= rate * rateStep.Seconds()
+		t = t.Add(rateStep)
+	}
+}
+
+// loadRows are the metrics rows of the open-loop mode
+func loadRows() [][]string {
+	var rows [][]string
+	if targetRPS > 0 {
+		rate := fmt.Sprintf("%.1f rps", currentRate)
+		if currentRate < targetRPS {
+			rate += fmt.Sprintf(" of %.1f", targetRPS)
+		}
+		rows = append(rows, []string{"Target Rate", rate})
+	}
+	if droppedRequests > 0 {
+		rows = append(rows, []string{"Dropped", fmt.Sprintf("%d", droppedRequests)})
+	}
+	return rows
+}
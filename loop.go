@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// executionModel is how bots pace their requests: open (requests arrive on
+// a schedule whether or not earlier ones were answered) or closed (each bot
+// waits for its answer, and any --think-time, before sending again)
+var executionModel string
+
+// checkExecutionModel validates --loop
+func checkExecutionModel() error {
+	if executionModel != "open" && executionModel != "closed" {
+		return fmt.Errorf("unknown --loop %q (expected open or closed)", executionModel)
+	}
+	return nil
+}
+
+// startClosedBot sends MNIST data from its sampler one request at a time,
+// waiting for each answer and then for a --think-time pause, if any
+func startClosedBot(bot int, samples *sampler, random *rand.Rand, newTarget newTargetFunc, wg *sync.WaitGroup, quitChan <-chan struct{}) {
+	defer wg.Done()
+
+	targets := map[string]Target{}
+	pause := time.NewTimer(0)
+	defer pause.Stop()
+
+	for {
+		select {
+		case <-pause.C:
+		case <-quitChan:
+			logToWidget("Bot stopping gracefully...")
+			return
+		}
+		if isPaused() {
+			// check again shortly rather than spinning
+			pause.Reset(100 * time.Millisecond)
+			continue
+		}
+		if !waitForToken(quitChan) {
+			logToWidget("Bot stopping gracefully...")
+			return
+		}
+		job, ok := nextJob(bot, samples, random, newTarget, targets)
+		if ok && !sendAndWait(job, wg, quitChan) {
+			logToWidget("Bot stopping gracefully...")
+			return
+		}
+		var think time.Duration
+		if botThinkTime != nil {
+			think = botThinkTime.draw(random)
+		}
+		if !ok {
+			// nothing was sent: the circuit is open or an error was logged
+			think = max(think, time.Second)
+		}
+		pause.Reset(think)
+	}
+}
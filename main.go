@@ -2,8 +2,11 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -11,7 +14,9 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -19,15 +24,20 @@ import (
 	"github.com/gizak/termui/v3"
 	"github.com/gizak/termui/v3/widgets"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 // MNISTData represents the structure of MNIST input data
 type MNISTData struct {
-	Instances [][]float64 `json:"instances"`
+	SignatureName string      `json:"signature_name,omitempty"`
+	Instances     [][]float64 `json:"instances"`
 }
 
 var (
 	mnistSamples [][]float64
+	mnistLabels  []int // nil unless the dataset comes with labels
+	mnistRows    []int // position of each sample in its --data source
 	logger       = logrus.New()
 
 	// Metrics
@@ -35,8 +45,14 @@ var (
 	successRequests int
 	failedRequests  int
 	averageLatency  float64
-	latencies       []float64
-	metricsMutex    sync.Mutex
+	latencySum      float64
+	protocolCounts  = map[string]int{}
+	statusCounts    = map[string]int{}
+	samplesSent     int // in successful requests
+	// labelled samples the model predicted a digit for, and how many were right
+	predictions        int
+	correctPredictions int
+	metricsMutex       sync.Mutex
 
 	// Logs
 	logEntries []string
@@ -44,115 +60,547 @@ var (
 	maxLogs    = 10 // Limit logs displayed in UI
 )
 
-// loadMNISTData loads MNIST samples from a CSV or JSON file
-func loadMNISTData(filename string) error {
+// loadMNISTData loads the samples of every --data source and their labels,
+// if any, one source after the other. Samples are validated with
+// --validate-data, normalized and filtered by --classes source by source.
+func loadMNISTData(classes map[int]bool) error {
+	var sourceLabels [][]int
+	labelled := false
+	for i := range dataSources {
+		source := &dataSources[i]
+		samples, labels, err := loadDataset(source.file, "")
+		if err != nil {
+			return err
+		}
+		if labels != nil {
+			logToWidget(fmt.Sprintf("Loaded %d labelled MNIST samples from %s", len(samples), source.name))
+		} else {
+			logToWidget(fmt.Sprintf("Loaded %d MNIST samples from %s", len(samples), source.name))
+		}
+
+		rows := make([]int, len(samples))
+		for row := range rows {
+			rows[row] = row
+		}
+		if validateData {
+			var report *dataReport
+			samples, labels, report, err = validateSamples(samples, labels)
+			for _, line := range report.lines() {
+				logger.Info(line)
+				logToWidget(line)
+			}
+			if err != nil {
+				return fmt.Errorf("invalid samples in %s: %v", source.name, err)
+			}
+			rows = report.kept
+		}
+		normalizeSamples(samples)
+		if classes != nil {
+			kept, err := filterClasses(labels, classes)
+			if err != nil {
+				return fmt.Errorf("%s: %v", source.name, err)
+			}
+			logToWidget(fmt.Sprintf("Kept %d of %d samples labelled %s", len(kept), len(samples), joinDigits(sortedClasses(classes))))
+			keptSamples, keptLabels, keptRows := make([][]float64, len(kept)), make([]int, len(kept)), make([]int, len(kept))
+			for i, index := range kept {
+				keptSamples[i], keptLabels[i], keptRows[i] = samples[index], labels[index], rows[index]
+			}
+			samples, labels, rows = keptSamples, keptLabels, keptRows
+		}
+
+		source.start = len(mnistSamples)
+		mnistSamples = append(mnistSamples, samples...)
+		mnistRows = append(mnistRows, rows...)
+		source.end = len(mnistSamples)
+		sourceLabels = append(sourceLabels, labels)
+		labelled = labelled || labels != nil
+	}
+	rawPixels = normalizedRaw(isRawScale(mnistSamples...))
+
+	// samples of unlabelled sources are labelled -1 when mixed with labelled ones
+	if labelled {
+		for i, labels := range sourceLabels {
+			if labels == nil {
+				labels = make([]int, dataSources[i].end-dataSources[i].start)
+				for j := range labels {
+					labels[j] = -1
+				}
+			}
+			mnistLabels = append(mnistLabels, labels...)
+		}
+	}
+	return nil
+}
+
+// loadDataset loads samples from a CSV, JSON, JSONL, IDX, NumPy, Parquet or
+// HDF5 file, or from a directory of images, and their labels from the dataset
+// or the --labels file. The format is detected from the file name unless
+// given (csv, json, jsonl, idx, numpy, parquet, hdf5 or images).
+func loadDataset(filename, format string) ([][]float64, []int, error) {
+	if format == "" {
+		if info, err := os.Stat(filename); err == nil && info.IsDir() {
+			format = "images"
+		} else if isIDXFile(filename) {
+			format = "idx"
+		} else if isNumPyFile(filename) {
+			format = "numpy"
+		} else if isParquetFile(filename) {
+			format = "parquet"
+		} else if isHDF5File(filename) {
+			format = "hdf5"
+		} else {
+			format = sampleFormat(filename)
+		}
+	}
+
+	var samples [][]float64
+	var labels []int
+	var err error
+	switch format {
+	case "images":
+		samples, labels, err = loadImageDirectory(filename)
+	case "idx":
+		samples, labels, err = loadIDXDataset(filename)
+	case "numpy":
+		samples, labels, err = loadNumPyDataset(filename)
+	case "parquet":
+		samples, labels, err = loadParquetDataset(filename)
+	case "hdf5":
+		samples, labels, err = loadHDF5Dataset(filename)
+	case "csv", "json", "jsonl":
+		samples, labels, err = loadSampleFile(filename, format)
+	default:
+		err = fmt.Errorf("unknown data format %q", format)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if labelsFile != "" {
+		if labels, err = loadLabelsFile(labelsFile); err != nil {
+			return nil, nil, err
+		}
+		if len(labels) != len(samples) {
+			return nil, nil, fmt.Errorf("%s holds %d labels for %d samples", labelsFile, len(labels), len(samples))
+		}
+	}
+	return samples, labels, nil
+}
+
+// sampleFormat returns the format of a CSV, JSON or JSONL file from its name,
+// ignoring a .gz suffix. Anything else is read as CSV.
+func sampleFormat(filename string) string {
+	name := strings.TrimSuffix(filename, ".gz")
+	if strings.HasSuffix(name, ".jsonl") {
+		return "jsonl"
+	}
+	if strings.HasSuffix(name, ".json") {
+		return "json"
+	}
+	return "csv"
+}
+
+// loadSampleFile loads samples from a CSV, JSON or JSONL file, which may be
+// gzip-compressed (.csv.gz, .json.gz). CSV files may start with a header row
+// and carry labels in their first column (--csv-label-column, or a header
+// whose first column is named "label").
+func loadSampleFile(filename, format string) ([][]float64, []int, error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		return fmt.Errorf("failed to open file: %v", err)
+		return nil, nil, fmt.Errorf("failed to open file: %v", err)
 	}
 	defer file.Close()
 
-	// Check the file extension
-	if len(filename) > 5 && filename[len(filename)-5:] == ".json" {
-		decoder := json.NewDecoder(file)
-		if err := decoder.Decode(&mnistSamples); err != nil {
-			return fmt.Errorf("failed to decode JSON: %v", err)
+	var reader io.Reader = file
+	if strings.HasSuffix(filename, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decompress file: %v", err)
 		}
-	} else {
-		reader := csv.NewReader(file)
+		defer gz.Close()
+		reader = gz
+	}
+
+	var samples [][]float64
+	if format == "jsonl" {
+		decoder := json.NewDecoder(reader)
 		for {
-			record, err := reader.Read()
-			if err == io.EOF {
+			var sample []float64
+			if err := decoder.Decode(&sample); err == io.EOF {
 				break
+			} else if err != nil {
+				return nil, nil, fmt.Errorf("failed to decode JSON: %v", err)
 			}
-			if err != nil {
-				return fmt.Errorf("failed to read CSV: %v", err)
-			}
+			samples = append(samples, sample)
+		}
+		return samples, nil, nil
+	}
+	if format == "json" {
+		decoder := json.NewDecoder(reader)
+		if err := decoder.Decode(&samples); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode JSON: %v", err)
+		}
+		return samples, nil, nil
+	}
 
-			var sample []float64
-			for _, value := range record {
-				pixel, err := strconv.ParseFloat(value, 64)
-				if err != nil {
-					return fmt.Errorf("failed to parse pixel value: %v", err)
-				}
-				sample = append(sample, pixel)
-			}
-			mnistSamples = append(mnistSamples, sample)
+	var labels []int
+	labelled := csvLabelColumn
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1 // row lengths are checked by --validate-data
+	for first := true; ; first = false {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read CSV: %v", err)
+		}
+		if first && isCSVHeader(record) {
+			labelled = labelled || strings.EqualFold(strings.TrimSpace(record[0]), "label")
+			continue
+		}
+
+		sample, err := parseCSVRecord(record, labelled)
+		if err != nil {
+			return nil, nil, err
+		}
+		samples = append(samples, sample.pixels)
+		if labelled {
+			labels = append(labels, sample.label)
 		}
 	}
+	return samples, labels, nil
+}
 
-	logToWidget(fmt.Sprintf("Loaded %d MNIST samples", len(mnistSamples)))
-	return nil
+// loadedSample returns a loaded sample with its label, if any
+func loadedSample(index int) labelledSample {
+	sample := labelledSample{pixels: mnistSamples[index], label: -1, index: mnistRows[index]}
+	if mnistLabels != nil {
+		sample.label = mnistLabels[index]
+	}
+	for _, source := range dataSources {
+		if index < source.end {
+			sample.source = source.name
+			break
+		}
+	}
+	return sample
 }
 
-// generateRandomMNISTData selects a random sample from the predefined list
-func generateRandomMNISTData() []float64 {
-	index := rand.Intn(len(mnistSamples))
-	return mnistSamples[index]
+// Target sends batches of MNIST samples to one model endpoint over one protocol
+type Target interface {
+	// Send delivers a batch in one request and waits for the answer. The
+	// Result may be partially filled (e.g. with the protocol) when the
+	// answer was an error.
+	Send(ctx context.Context, batch [][]float64) (Result, error)
 }
 
-// sendData sends MNIST data to the specified API endpoint
-func sendData(apiURL string, data []float64, wg *sync.WaitGroup) {
-	defer wg.Done()
+// Result describes the answer to one request
+type Result struct {
+	Latency   float64 // milliseconds
+	Protocol  string  // wire protocol, counted in the metrics table when set
+	Status    string  // response status code (200, 503, Unavailable...), counted in the metrics table when set
+	Predicted []int   // predicted digit per sample, nil when the answer carries none
+}
+
+// newTargetFunc returns the Target for an endpoint. Bots call it once per
+// endpoint they send to and reuse the Target afterwards.
+type newTargetFunc func(endpoint string) (Target, error)
+
+// sharedTarget returns a newTargetFunc that hands every bot the same Target
+func sharedTarget(t Target) newTargetFunc {
+	return func(string) (Target, error) {
+		return t, nil
+	}
+}
+
+// sendError marks a request that never got an answer, as opposed to one the
+// server answered with an error
+type sendError struct {
+	err error
+}
+
+func (e *sendError) Error() string { return e.err.Error() }
+func (e *sendError) Unwrap() error { return e.err }
+
+// statusError is an HTTP response with a status other than 200 OK
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string { return "request failed: " + e.status }
 
+// signRequest, when set, authenticates each REST request before it is sent
+var signRequest func(req *http.Request, payload []byte) error
+
+// restTarget posts samples to an HTTP endpoint in the target type's format
+type restTarget struct {
+	url string
+}
+
+// newRESTTarget returns a Target posting to the endpoint URL
+func newRESTTarget(endpoint string) (Target, error) {
+	return &restTarget{url: endpoint}, nil
+}
+
+// Send posts one batch and parses the predictions from the response
+func (t *restTarget) Send(ctx context.Context, batch [][]float64) (Result, error) {
+	var result Result
 	startTime := time.Now()
 
-	requestBody := MNISTData{Instances: [][]float64{data}}
-	jsonData, err := json.Marshal(requestBody)
+	payload, err := encoder.Encode(ctx, batch)
 	if err != nil {
-		logToWidget(fmt.Sprintf("Error marshaling JSON: %v", err))
-		return
+		return result, fmt.Errorf("error building payload: %v", err)
 	}
 
-	resp, err := http.Post(apiURL, "application/json", bytes.NewBuffer(jsonData))
+	uncompressed := len(payload)
+	if payload, err = compressBody(payload); err != nil {
+		return result, fmt.Errorf("error compressing payload: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(payload))
+	if err != nil {
+		return result, fmt.Errorf("error creating request: %v", err)
+	}
+	for key, values := range extraHeaders {
+		req.Header[key] = values
+	}
+	for key, value := range sampleTags(requestInfoFrom(ctx)) {
+		req.Header.Set(key, value)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	req.Header.Set("Content-Type", encoder.ContentType())
+	if requestCompression != "none" {
+		req.Header.Set("Content-Encoding", requestCompression)
+	}
+	if signRequest != nil {
+		if err := signRequest(req, payload); err != nil {
+			return result, fmt.Errorf("error signing request: %v", err)
+		}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return result, &sendError{fmt.Errorf("error sending request: %w", err)}
+	}
+	defer resp.Body.Close()
+	result.Protocol = resp.Proto
+	result.Status = strconv.Itoa(resp.StatusCode)
+	recordBodyBytes(uncompressed, len(payload))
+
+	body, err := io.ReadAll(resp.Body)
+	result.Latency = time.Since(startTime).Seconds() * 1000
+	recordResponseBytes(len(body))
 	if err != nil {
-		logToWidget(fmt.Sprintf("Error sending request: %v", err))
+		return result, fmt.Errorf("error reading response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return result, &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+
+	scores, err := target.parseResponse(body)
+	if err != nil {
+		return result, fmt.Errorf("invalid response: %v", err)
+	}
+	result.Predicted = predictedDigits(scores)
+	return result, nil
+}
+
+// dispatch sends one batch to an endpoint and records the outcome
+func dispatch(t Target, bot int, endpoint string, batch []labelledSample, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	info := requestInfo{id: newRequestID(), labels: make([]int, len(batch)), indexes: make([]int, len(batch)), sent: time.Now()}
+	for i, sample := range batch {
+		info.labels[i], info.indexes[i] = sample.label, sample.index
+	}
+	labels := info.labels
+	ctx, cancel := requestContext(info)
+	defer cancel()
+	ctx, span := startRequestSpan(ctx, endpoint, info)
+	recordBotSent(bot)
+	result, err := sendWithRetries(ctx, t, batchPixels(batch))
+	recordBotResult(bot, err == nil, result.Latency)
+	if err != nil && ctx.Err() != nil {
+		recordTimeout()
+	}
+	kind := ""
+	if err != nil {
+		kind = failureKind(ctx, err)
+	}
+	endRequestSpan(span, result, kind, err)
+	if len(result.Predicted) != len(batch) {
+		result.Predicted = nil
+	}
+	saveResult(endpoint, info, batch, result, err)
+
+	if result.Protocol != "" || result.Status != "" {
 		metricsMutex.Lock()
-		failedRequests++
+		if result.Protocol != "" {
+			protocolCounts[result.Protocol]++
+		}
+		if result.Status != "" {
+			statusCounts[result.Status]++
+		}
 		metricsMutex.Unlock()
+	}
+
+	if err != nil {
+		var sendErr *sendError
+		if errors.As(err, &sendErr) {
+			recordSendError(endpoint)
+		} else {
+			recordFailure(endpoint)
+		}
+		recordFailureKind(kind)
+		statsdRequest(0, kind)
+		logToWidget(fmt.Sprintf("Request to %s failed (%s): %v", endpoint, kind, err))
 		return
 	}
-	defer resp.Body.Close()
 
-	latency := time.Since(startTime).Seconds() * 1000
+	recordSuccess(endpoint, result.Latency)
+	statsdRequest(result.Latency, "")
+	otlpRequest(result.Latency)
+	recordBatch(len(batch))
+	labelled := false
+	for i, predicted := range result.Predicted {
+		if labels[i] >= 0 && predicted >= 0 {
+			recordPrediction(labels[i], predicted)
+			labelled = true
+		}
+	}
+
+	message := "Request sent and Saved Successfully"
+	if len(batch) > 1 {
+		message = fmt.Sprintf("Batch of %d sent and Saved Successfully", len(batch))
+	}
+	if result.Protocol != "" {
+		message += " over " + result.Protocol
+	}
+	if result.Predicted != nil {
+		message += ", Predicted: " + joinDigits(result.Predicted)
+		if labelled {
+			message += " (Expected: " + joinDigits(labels) + ")"
+		}
+	}
+	logToWidget(fmt.Sprintf("%s, Latency: %.2f ms", message, result.Latency))
+}
 
-	metricsMutex.Lock()
-	totalRequests++
-	if resp.StatusCode == http.StatusOK {
-		successRequests++
-		latencies = append(latencies, latency)
-		averageLatency = calculateAverageLatency()
-	} else {
-		failedRequests++
-		logToWidget(fmt.Sprintf("Request failed: %s", resp.Status))
+// joinDigits formats the digits of a batch for the log
+func joinDigits(digits []int) string {
+	parts := make([]string, len(digits))
+	for i, digit := range digits {
+		parts[i] = strconv.Itoa(digit)
 	}
-	metricsMutex.Unlock()
+	return strings.Join(parts, ",")
+}
 
-	logToWidget(fmt.Sprintf("Request sent and Saved Successfully, Latency: %.2f ms", latency))
+// recordBatch counts the samples sent in a successful request
+func recordBatch(size int) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	samplesSent += size
 }
 
-// calculateAverageLatency computes the average latency from recorded values
-func calculateAverageLatency() float64 {
-	sum := 0.0
-	for _, latency := range latencies {
-		sum += latency
+// recordPrediction compares a predicted digit with the sample's label
+func recordPrediction(label, predicted int) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	predictions++
+	if predicted == label {
+		correctPredictions++
 	}
-	return sum / float64(len(latencies))
 }
 
-// startBot starts sending random MNIST data at the specified rate
-func startBot(apiURL string, interval time.Duration, wg *sync.WaitGroup, quitChan <-chan struct{}) {
+// recordSuccess counts a successful request and its latency
+func recordSuccess(endpoint string, latency float64) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	totalRequests++
+	successRequests++
+	recordLatency(latency)
+
+	stats := statsFor(endpoint)
+	stats.success++
+	stats.latencySum += latency
+	recordStage(true, latency)
+	recordWindow(true, latency)
+	recordRolling(true, latency)
+	noteResult(endpoint, true)
+	noteCircuit(endpoint, true)
+	checkBudget()
+}
+
+// recordFailure counts a request that got an answer but did not succeed
+func recordFailure(endpoint string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	totalRequests++
+	failedRequests++
+	statsFor(endpoint).failed++
+	recordStage(false, 0)
+	recordWindow(false, 0)
+	recordRolling(false, 0)
+	noteResult(endpoint, false)
+	noteCircuit(endpoint, false)
+	checkBudget()
+}
+
+// recordSendError counts a request that never got an answer
+func recordSendError(endpoint string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	failedRequests++
+	statsFor(endpoint).failed++
+	recordStage(false, 0)
+	recordWindow(false, 0)
+	recordRolling(false, 0)
+	noteResult(endpoint, false)
+	noteCircuit(endpoint, false)
+	checkBudget()
+}
+
+// startBot starts sending MNIST data from its sampler at the specified
+// interval, after every --think-time pause, or on every shared arrival when
+// arrivals is set, drawing its samples and pauses from random. With --bursts
+// it sends a whole burst each time.
+func startBot(bot int, samples *sampler, random *rand.Rand, newTarget newTargetFunc, interval time.Duration, arrivals <-chan time.Time, wg *sync.WaitGroup, quitChan <-chan struct{}) {
 	defer wg.Done()
 
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	targets := map[string]Target{}
+
+	ticks := arrivals
+	var thinking *time.Timer
+	if ticks == nil && botThinkTime != nil {
+		thinking = time.NewTimer(botThinkTime.draw(random))
+		defer thinking.Stop()
+		ticks = thinking.C
+	} else if ticks == nil {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
 
 	for {
 		select {
-		case <-ticker.C:
-			data := generateRandomMNISTData()
-			wg.Add(1)
-			go sendData(apiURL, data, wg)
+		case <-ticks:
+			if thinking != nil {
+				thinking.Reset(botThinkTime.draw(random))
+			}
+			if isPaused() {
+				continue
+			}
+			for i := 0; i < requestsPerTick(); i++ {
+				if !waitForToken(quitChan) {
+					logToWidget("Bot stopping gracefully...")
+					return
+				}
+				if job, ok := nextJob(bot, samples, random, newTarget, targets); ok {
+					enqueueSend(job, wg)
+				}
+			}
 
 		case <-quitChan:
 			logToWidget("Bot stopping gracefully...")
@@ -161,6 +609,31 @@ func startBot(apiURL string, interval time.Duration, wg *sync.WaitGroup, quitCha
 	}
 }
 
+// nextJob picks the endpoint and samples of a bot's next request. It reports
+// false when there is nothing to send: the endpoint's circuit is open or an
+// error was logged.
+func nextJob(bot int, samples *sampler, random *rand.Rand, newTarget newTargetFunc, targets map[string]Target) (sendJob, bool) {
+	endpoint := routeEndpoint(pickEndpoint())
+	t, ok := targets[endpoint]
+	if !ok {
+		var err error
+		if t, err = newTarget(endpoint); err != nil {
+			logToWidget(fmt.Sprintf("Error setting up %s: %v", endpoint, err))
+			return sendJob{}, false
+		}
+		targets[endpoint] = t
+	}
+	batch, err := samples.batch(currentBatchSizes().pick(random), random)
+	if err != nil {
+		logToWidget(fmt.Sprintf("Error reading sample: %v", err))
+		return sendJob{}, false
+	}
+	if !allowRequest(endpoint) {
+		return sendJob{}, false
+	}
+	return sendJob{target: t, bot: bot, endpoint: endpoint, batch: batch}, true
+}
+
 // logToWidget adds a log entry while ensuring it doesn't overflow the UI
 func logToWidget(message string) {
 	logMutex.Lock()
@@ -171,19 +644,76 @@ func logToWidget(message string) {
 	}
 }
 
-// renderMetricsTable creates a terminal-based table to display metrics
-func renderMetricsTable() *widgets.Table {
-	table := widgets.NewTable()
-	table.Rows = [][]string{
+// metricsRows builds the rows of the metrics table. Callers must hold metricsMutex.
+func metricsRows() [][]string {
+	rows := [][]string{
 		{"Metric", "Value"},
 		{"Total Requests", fmt.Sprintf("%d", totalRequests)},
 		{"Success Requests", fmt.Sprintf("%d", successRequests)},
 		{"Failed Requests", fmt.Sprintf("%d", failedRequests)},
 		{"Average Latency (ms)", fmt.Sprintf("%.2f", averageLatency)},
 	}
+	rows = append(rows, latencyRows()...)
+	rows = append(rows, throughputRows()...)
+	rows = append(rows, rollingRows()...)
+	if !batchSizes.fixed() && successRequests > 0 {
+		rows = append(rows, []string{"Samples Sent", fmt.Sprintf("%d (%.1f per request)", samplesSent, float64(samplesSent)/float64(successRequests))})
+	}
+	if predictions > 0 {
+		accuracy := 100 * float64(correctPredictions) / float64(predictions)
+		rows = append(rows, []string{"Accuracy", fmt.Sprintf("%.2f%% (%d/%d)", accuracy, correctPredictions, predictions)})
+	}
+	if len(protocolCounts) > 0 {
+		protocols := make([]string, 0, len(protocolCounts))
+		for proto, count := range protocolCounts {
+			protocols = append(protocols, fmt.Sprintf("%s: %d", proto, count))
+		}
+		sort.Strings(protocols)
+		rows = append(rows, []string{"Protocols", strings.Join(protocols, ", ")})
+	}
+	if len(statusCounts) > 0 {
+		statuses := make([]string, 0, len(statusCounts))
+		for code, count := range statusCounts {
+			statuses = append(statuses, fmt.Sprintf("%s: %d", code, count))
+		}
+		sort.Strings(statuses)
+		rows = append(rows, []string{"Status Codes", strings.Join(statuses, ", ")})
+	}
+	rows = append(rows, bandwidthRows()...)
+	rows = append(rows, failureRows()...)
+	rows = append(rows, timeoutRows()...)
+	rows = append(rows, mqttRows()...)
+	rows = append(rows, []string{"Bots", fmt.Sprintf("%d", activeBots)})
+	rows = append(rows, seedRows()...)
+	rows = append(rows, pauseRows()...)
+	rows = append(rows, retryRows()...)
+	rows = append(rows, circuitRows()...)
+	rows = append(rows, poolRows()...)
+	rows = append(rows, loadRows()...)
+	rows = append(rows, limiterRows()...)
+	rows = append(rows, stageRows()...)
+	rows = append(rows, stressRows()...)
+	rows = append(rows, replayRows()...)
+	rows = append(rows, sweepRows()...)
+	if backupEndpoint != "" {
+		rows = append(rows, []string{"Failovers", fmt.Sprintf("%d", failoverEvents)})
+	}
+	if len(allEndpoints()) > 1 {
+		rows = append(rows, endpointRows()...)
+	}
+	rows = append(rows, botRows()...)
+	rows = append(rows, verdictRows()...)
+	return rows
+}
+
+// renderMetricsTable creates a terminal-based table to display metrics
+func renderMetricsTable() *widgets.Table {
+	table := widgets.NewTable()
+	metricsMutex.Lock()
+	table.Rows = metricsRows()
+	metricsMutex.Unlock()
 	table.TextStyle = termui.NewStyle(termui.ColorWhite)
 	table.Title = "MNIST Bot Metrics"
-	table.SetRect(0, 0, 50, 12)
 	return table
 }
 
@@ -193,21 +723,773 @@ func renderLogWidget() *widgets.List {
 	list.Title = "Logs"
 	list.TextStyle = termui.NewStyle(termui.ColorWhite)
 	list.WrapText = true
-	list.SetRect(0, 9, 100, 18) // Positioned below metrics
 	return list
 }
 
+// layoutWidgets sizes the metrics table to its rows and keeps the logs below it
+func layoutWidgets(table *widgets.Table, logWidget *widgets.List) {
+	height := 2*len(table.Rows) + 1
+	table.SetRect(0, 0, 100, height)
+	logWidget.SetRect(0, height, 100, height+maxLogs-1)
+}
+
 // starts sending randomly selected data at a specific rate
 func main() {
-	apiURL := flag.String("api", "", "API endpoint URL")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "download":
+			runDownload(os.Args[2:])
+			return
+		case "convert":
+			runConvert(os.Args[2:])
+			return
+		}
+	}
+
+	var apiURLs stringList
+	flag.Var(&apiURLs, "api", "API endpoint URL (repeat to distribute requests round-robin)")
+	targetsFile := flag.String("targets", "", "File with one API endpoint URL (and optional weight) per line")
+	weights := flag.String("weights", "", "Comma-separated traffic weights, one per endpoint (e.g. 90,10)")
+	flag.StringVar(&backupEndpoint, "backup-api", "", "Backup endpoint URL used while a primary endpoint is failing")
+	flag.IntVar(&failoverThreshold, "failover-threshold", 5, "Consecutive errors before an endpoint fails over to --backup-api")
+	flag.DurationVar(&failbackAfter, "failback-after", 30*time.Second, "How long to stay on the backup before retrying the primary")
+	skipPreflight := flag.Bool("skip-preflight", false, "Start without checking that the model is ready")
+	healthPath := flag.String("health-path", "", "Readiness path probed before starting (defaults to the target type's status path)")
+	waitReady := flag.Duration("wait-ready", 0, "How long to wait, with backoff, for the model to become ready (0 fails immediately)")
 	numBots := flag.Int("bots", 1, "Number of concurrent bots")
 	interval := flag.Int("interval", 1, "Interval between requests (seconds)")
-	dataFile := flag.String("data", "./Assets/Data/data.json", "Path to MNIST data file")
+	flag.IntVar(&maxRequests, "max-requests", 0, "Stop the run once this many requests have finished (0 means no limit)")
+	flag.IntVar(&maxErrors, "max-errors", 0, "Stop the run once this many requests have failed (0 means no limit)")
+	flag.Float64Var(&maxErrorRate, "max-error-rate", 0, "Stop the run once more than this share of requests (0-1) has failed, checked from the 20th request on (0 means no limit)")
+	flag.Float64Var(&sloErrorRate, "slo-error-rate", 0, "Exit with status 2 if more than this share of requests (0-1) failed (0 means not checked)")
+	flag.DurationVar(&sloP99, "slo-p99", 0, "Exit with status 2 if the p99 latency of the run is over this (0 means not checked)")
+	flag.Float64Var(&sloAccuracy, "slo-accuracy", 0, "Exit with status 2 if less than this share of labelled predictions (0-1) was right (0 means not checked)")
+	latencyPrecision := flag.Int("latency-precision", 3, "Significant digits latencies are recorded with, from 1 to 5 (more use more memory)")
+	flag.BoolVar(&perBot, "per-bot", false, "Show the requests, errors, latency and last answer of every bot in the metrics table (and --metrics-addr), to spot a wedged bot or slow connection")
+	metricsAddr := flag.String("metrics-addr", "", "Serve the metrics in the Prometheus format on this address's /metrics (e.g. :9101) while the run goes on")
+	pprofAddr := flag.String("pprof-addr", "", "Serve the bot's own Go profiles (net/http/pprof) on this address's /debug/pprof/ (e.g. localhost:6060), to profile the bot at high request rates")
+	flag.StringVar(&pushgatewayURL, "pushgateway", "", "Push the metrics to this Prometheus Pushgateway URL every --push-interval and at the end of the run, for runs too short to scrape")
+	flag.DurationVar(&pushInterval, "push-interval", 15*time.Second, "How often to push the metrics to --pushgateway during the run (0 pushes only at the end)")
+	flag.StringVar(&pushRunID, "run-id", "", "run_id label of the metrics pushed to --pushgateway, written to --influx or exported to --otlp-endpoint (defaults to the start time)")
+	flag.StringVar(&influxTarget, "influx", "", "Write a point of the run's rate, errors and latency percentiles every --influx-interval in the InfluxDB line protocol: to an InfluxDB write URL (http://influx:8086/api/v2/write?org=ORG&bucket=BUCKET) or appended to a file")
+	flag.DurationVar(&influxInterval, "influx-interval", 10*time.Second, "How often to write a point to --influx")
+	flag.StringVar(&influxToken, "influx-token", "", "InfluxDB 2 API token for --influx (defaults to $INFLUX_TOKEN)")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "Export the --otlp-export signals to this OpenTelemetry collector over OTLP/HTTP (e.g. http://localhost:4318)")
+	flag.StringVar(&otlpExport, "otlp-export", "traces", "Comma-separated signals exported to --otlp-endpoint: traces (a span per request, with the W3C traceparent header sent to the server) and/or metrics")
+	flag.DurationVar(&otlpInterval, "otlp-interval", 10*time.Second, "How often to export the metrics to --otlp-endpoint")
+	flag.Float64Var(&traceRatio, "trace-ratio", 1, "Share of the requests traced with --otlp-endpoint, from 0 to 1")
+	flag.StringVar(&graphiteAddr, "graphite", "", "Send the run's rate, errors and latency percentiles every --graphite-interval to this Graphite/Carbon host:port in the plaintext protocol")
+	flag.StringVar(&graphitePrefix, "graphite-prefix", "mnist_bot.", "Prefix of the Graphite metric paths")
+	flag.DurationVar(&graphiteInterval, "graphite-interval", 10*time.Second, "How often to send the metrics to --graphite")
+	statsdAddr := flag.String("statsd", "", "Send latency, request and error metrics to the StatsD (or Datadog) agent at this host:port over UDP")
+	statsdPrefixFlag := flag.String("statsd-prefix", "mnist_bot.", "Prefix of the StatsD metric names")
+	statsdTagsFlag := flag.String("statsd-tags", "", "Comma-separated DogStatsD tags added to every StatsD metric (e.g. env:perf,team:ml)")
+	histogramFile := flag.String("histogram-file", "", "Save the latency distribution of the run to this file in the HdrHistogram percentile format (.hgrm)")
+	duration := flag.Duration("duration", 0, "Stop the run after this long, print a summary and exit (0 runs until stopped)")
+	flag.Float64Var(&targetRPS, "rps", 0, "Send this many requests per second in total, on a fixed schedule shared by all bots, instead of one per bot every --interval")
+	flag.StringVar(&executionModel, "loop", "open", "Execution model: open sends on a schedule (--interval, --rps) whether or not earlier requests were answered; closed has each bot wait for its answer, then --think-time, before sending again")
+	bursts := flag.String("bursts", "", "Have each bot send SIZE requests back to back every PERIOD instead of one per --interval, to exercise server-side batching and queues (e.g. 20/10s)")
+	think := flag.String("think-time", "", "Pace each bot with its own pause between requests instead of --interval: fixed (2s), uniform (1s-5s) or lognormal:MEDIAN:SIGMA (lognormal:2s:0.5)")
+	seed := flag.Int64("seed", 0, "Seed for the random choice of samples, augmentations and batch sizes, to repeat a run (defaults to one picked and logged at start)")
+	flag.StringVar(&arrivalProcess, "arrival", "constant", "Spacing of requests: constant, or poisson for exponentially distributed gaps averaging the --rps or --interval rate")
+	scenarioPath := flag.String("scenario", "", "Run the phases of a YAML scenario file one after the other, then stop; each phase sets a rate and duration and may set its own data, batch-size and augment")
+	stages := flag.String("stages", "", "Run stepped rates instead of --rps, then stop: RATE:DURATION stages (10:2m,50:2m,100:5m) or a file with one \"RATE DURATION\" per line")
+	var wave sineWave
+	flag.Float64Var(&wave.min, "sine-min", 0, "Lowest rate of the --sine-period wave, in requests per second")
+	flag.Float64Var(&wave.max, "sine-max", 0, "Highest rate of the --sine-period wave, in requests per second")
+	flag.DurationVar(&wave.period, "sine-period", 0, "Swing the rate between --sine-min and --sine-max and back once every period (e.g. 24h for day/night cycles) instead of using --rps")
+	sweep := flag.String("sweep", "", "Measure throughput and latency at doubling levels, one --sweep-window each, then stop: bots:FROM-TO (1, 2, 4... bots) or rps:FROM-TO (rates)")
+	flag.DurationVar(&sweepWindow, "sweep-window", 30*time.Second, "How long --sweep measures each level")
+	sweepOutput := flag.String("sweep-output", "", "Write the --sweep throughput-vs-latency curve to this CSV file")
+	flag.BoolVar(&stressOpts.enabled, "stress", false, "Find the highest rate the endpoint sustains: double the rate from --rps (or 1) every --stress-window until a threshold is breached, then bisect")
+	flag.Float64Var(&stressOpts.errorRate, "stress-error-rate", 0.01, "Highest share of failed requests a --stress window may have")
+	flag.DurationVar(&stressOpts.p99, "stress-p99", time.Second, "Highest p99 latency a --stress window may have")
+	flag.DurationVar(&stressOpts.window, "stress-window", 30*time.Second, "How long --stress holds each rate")
+	flag.Float64Var(&stressOpts.precision, "stress-precision", 0.05, "Relative gap between the passing and failing rates at which --stress stops")
+	flag.DurationVar(&rampUp, "ramp-up", 0, "Grow the --rps rate linearly from zero over this long at the start")
+	flag.DurationVar(&rampDown, "ramp-down", 0, "Lower the --rps rate linearly to zero over this long when stopping")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 30*time.Second, "How long requests still in flight when the run stops get to finish before they're abandoned (the --duration deadline abandons them at once)")
+	flag.DurationVar(&requestTimeout, "request-timeout", 30*time.Second, "Give up on a request that hasn't been fully answered after this long and count it as failed (0 means no limit)")
+	flag.IntVar(&maxRetries, "retries", 0, "Send a request again up to this many times when it fails with a reset connection, 429 or 503")
+	flag.DurationVar(&retryBackoff, "retry-backoff", 100*time.Millisecond, "Base of the exponential backoff between retries; each wait is a random time up to this doubled per attempt")
+	flag.DurationVar(&maxRetryBackoff, "retry-max-backoff", 5*time.Second, "Longest backoff between retries")
+	flag.Float64Var(&circuitErrorRate, "circuit-error-rate", 0, "Pause sending to an endpoint once more than this share (0-1) of its last --circuit-window requests failed, probing it every --circuit-cooldown (0 disables the circuit breaker)")
+	flag.IntVar(&circuitWindow, "circuit-window", 20, "Number of recent requests per endpoint the circuit breaker looks at")
+	flag.DurationVar(&circuitCooldown, "circuit-cooldown", 10*time.Second, "How long an open circuit pauses before sending a probe request")
+	rateLimit := flag.Float64("rate-limit", 0, "Cap the total rate of all bots, whatever --bots, --interval or the rate profile ask for, with a token bucket shared by the bots (0 means no cap)")
+	rateStep := flag.String("rate-step", "10%", "How much the + and - keys raise or lower the --rps rate during a run: a rate (5) or a percentage of the current one (10%)")
+	burst := flag.Int("burst", 1, "Number of requests the --rate-limit bucket lets through at once after a quiet spell")
+	maxInFlight := flag.Int("max-in-flight", 1000, "Number of workers sending requests, i.e. the most requests outstanding at once")
+	queueSize := flag.Int("queue-size", 100, "Most requests waiting for a free worker; further sends are dropped and counted as queue overflows")
+	batchSize := flag.String("batch-size", "1", "Samples per request: a size (8), a range (1-32), a list drawn from uniformly (1,4,8,32) or weighted (1:70,8:20,32:10)")
+	replayFile := flag.String("replay", "", "Instead of running bots, send the requests of a --results file again, with the same samples and the same gaps between them")
+	flag.Float64Var(&replaySpeed, "replay-speed", 1, "Scale the gaps of --replay: 2 replays twice as fast, 0.5 at half speed")
+	startAt := flag.String("start-at", "", "Wait until this time before starting: HH:MM (the next time the clock shows it) or an RFC 3339 time")
+	cronSpec := flag.String("cron", "", "Start a run every time this cron expression (minute hour day month weekday, e.g. \"0 2 * * *\") fires, archiving each run's results and summary; runs must end on their own")
+	archiveDir := flag.String("archive", "runs", "Directory --cron archives each run in, one subdirectory per start time")
+	summaryFile := flag.String("summary-file", "", "Also write the final summary to this file")
+	resultsPath := flag.String("results", "", "Write one JSON line per request to this file: its samples' source, index and label, predictions, latency and error")
+	var dataFiles stringList
+	flag.Var(&dataFiles, "data", "Path to MNIST data file (CSV, JSON, JSONL, IDX, .npy, .npz, .parquet or .h5), directory of images, http(s)://, s3:// or gs:// URL, or - to stream CSV or JSONL from stdin (default ./Assets/Data/data.json); repeat with :weight suffixes to mix datasets")
+	registerDataFlags(flag.CommandLine)
+	flag.StringVar(&shardMode, "shard", "none", "Give each bot its own part of the data: none, round-robin (every Nth sample) or contiguous (a consecutive range)")
+	flag.StringVar(&samplingStrategy, "sampling", "", "Order samples are sent in: random, sequential (wrapping around), shuffled-epoch (a new shuffle every pass) or balanced (every digit equally often); defaults to sequential with --shard, else random")
+	generate := flag.String("generate", "", "Send fabricated samples instead of --data: all or a comma-separated list of noise, blank, white and gradient")
+	augment := flag.String("augment", "", "Augment samples before sending: comma-separated rotate, translate, noise, blur, invert and brightness steps, each with an optional probability, e.g. rotate:0.5,noise:0.2")
+	classes := flag.String("classes", "", "Only send samples labelled with these digits, e.g. 3,7")
+	streamData := flag.Bool("stream", false, "Read CSV, JSON or JSONL --data lazily instead of loading it into memory")
+	flag.BoolVar(&validateData, "validate-data", false, "Check that every sample has 784 values in range and no NaNs, and report problems")
+	flag.StringVar(&badRows, "bad-rows", "fail", "What --validate-data does with bad samples (fail, skip, or clamp values into range)")
+	flag.Float64Var(&maxPixel, "max-pixel", 1, "Largest valid pixel value for --validate-data (255 for raw pixels)")
+	streamBuffer := flag.Int("stream-buffer", 0, "Recent samples kept in memory when streaming, picked at random (0 sends in file order)")
+	protocol := flag.String("protocol", "rest", "Protocol used to reach the model (rest, grpc, websocket, sagemaker, vertex, kafka or mqtt)")
+	targetType := flag.String("target-type", "tfserving", "REST serving API to target (tfserving, triton, torchserve, seldon, onnx, bentoml or mlflow)")
+	payloadFormat := flag.String("payload-format", "", "Request body format for REST, WebSocket, Kafka and MQTT (target, v2, b64, multipart, envelope or template; defaults to envelope or template when --envelope or --body-template is set, else target)")
+	flag.StringVar(&formField, "form-field", "file", "Form field holding the image with --payload-format multipart")
+	flag.StringVar(&requestCompression, "compress-requests", "none", "Compress REST request bodies (none, gzip or zstd) and send Content-Encoding")
+	contentType := flag.String("content-type", "", "Request Content-Type; application/msgpack and application/cbor re-encode the body in that format (defaults to the payload format's)")
+	envelope := flag.String("envelope", "", `JSON object wrapping the samples, inline or in a file, with "$instances" where they go (e.g. {"inputs": "$instances", "parameters": {"top_k": 1}})`)
+	bodyTemplateFile := flag.String("body-template", "", "Go template file for request bodies (fields: .Pixels, .Image, .Label, .Batch, .Labels, .Indexes, .RequestID, .Model)")
+	flag.StringVar(&mlflowFormat, "mlflow-format", "dataframe_split", "MLflow input schema (dataframe_split or instances)")
+	flag.StringVar(&inputName, "input-name", "inputs", "Input tensor name for gRPC and v2 inference requests")
+	flag.StringVar(&modelName, "model", "mnist", "Model name used in request URLs and the gRPC ModelSpec")
+	flag.StringVar(&modelVersion, "model-version", "", "Model version to pin (defaults to the latest version)")
+	flag.StringVar(&signatureName, "signature-name", "", "Serving signature name (defaults to the server's default signature)")
+	flag.DurationVar(&grpcDeadline, "grpc-deadline", 10*time.Second, "Per-call deadline for gRPC Predict calls")
+	var genericOpts genericGRPCOptions
+	flag.StringVar(&genericOpts.method, "grpc-method", "", "Custom gRPC method to call instead of TF Serving's Predict (package.Service/Method)")
+	flag.StringVar(&genericOpts.descriptorSet, "grpc-descriptor-set", "", "FileDescriptorSet describing --grpc-method (uses server reflection when empty)")
+	flag.StringVar(&genericOpts.pixelField, "grpc-pixel-field", "", "Dotted path of the repeated request field that receives the pixels")
+	flag.StringVar(&genericOpts.outputField, "grpc-output-field", "", "Dotted path of the repeated response field holding class scores")
+	region := flag.String("region", "", "Cloud region of the SageMaker or Vertex AI endpoint, or the SigV4 signing region")
+	endpointName := flag.String("endpoint-name", "", "SageMaker endpoint name")
+	gcpProject := flag.String("project", "", "Google Cloud project of the Vertex AI endpoint")
+	endpointID := flag.String("endpoint-id", "", "Vertex AI endpoint ID")
+	var kafkaOpts kafkaOptions
+	flag.StringVar(&kafkaOpts.brokers, "kafka-brokers", "localhost:9092", "Comma-separated Kafka bootstrap brokers")
+	flag.StringVar(&kafkaOpts.topic, "kafka-topic", "", "Kafka topic samples are produced to")
+	flag.StringVar(&kafkaOpts.encoding, "kafka-encoding", "json", "Kafka record encoding (json or avro)")
+	flag.IntVar(&kafkaOpts.schemaID, "kafka-schema-id", 0, "Schema Registry ID prepended to Avro records (0 sends plain Avro)")
+	flag.IntVar(&kafkaOpts.acks, "kafka-acks", 1, "Acknowledgements required per record (0, 1 or -1 for all replicas)")
+	var mqttOpts mqttOptions
+	flag.StringVar(&mqttOpts.broker, "mqtt-broker", "tcp://localhost:1883", "MQTT broker URL (tcp://, ssl:// or ws://)")
+	flag.StringVar(&mqttOpts.topic, "mqtt-topic", "", "MQTT topic samples are published to")
+	flag.IntVar(&mqttOpts.qos, "mqtt-qos", 0, "MQTT QoS level for publishing and the response subscription (0, 1 or 2)")
+	flag.StringVar(&mqttOpts.clientID, "mqtt-client-id", "mnist-bot", "MQTT client ID")
+	flag.StringVar(&mqttOpts.username, "mqtt-username", "", "MQTT username")
+	flag.StringVar(&mqttOpts.password, "mqtt-password", "", "MQTT password")
+	flag.StringVar(&mqttOpts.responseTopic, "mqtt-response-topic", "", "Topic the inference service replies on, to measure end-to-end latency (requests go to <topic>/<id>, replies are expected on <response topic>/<id>)")
+	flag.DurationVar(&mqttOpts.responseTimeout, "mqtt-timeout", 10*time.Second, "How long to wait for the broker or a reply")
+	var transportOpts transportOptions
+	flag.StringVar(&transportOpts.httpVersion, "http-version", "auto", "HTTP version for REST requests (auto, 1.1, 2, h2c or 3)")
+	useHTTP3 := flag.Bool("http3", false, "Send REST requests over HTTP/3 (QUIC), experimental")
+	flag.IntVar(&transportOpts.maxIdleConns, "max-idle-conns", 100, "Maximum idle connections across all hosts (0 means no limit)")
+	flag.IntVar(&transportOpts.maxIdleConnsPerHost, "max-idle-conns-per-host", http.DefaultMaxIdleConnsPerHost, "Maximum idle connections kept per host")
+	flag.IntVar(&transportOpts.maxConnsPerHost, "max-conns-per-host", 0, "Maximum connections per host, including active ones (0 means no limit)")
+	flag.DurationVar(&transportOpts.idleConnTimeout, "idle-conn-timeout", 90*time.Second, "How long an idle connection is kept open")
+	var tlsOpts tlsOptions
+	flag.StringVar(&tlsOpts.certFile, "tls-cert", "", "Client certificate file (PEM) for mutual TLS")
+	flag.StringVar(&tlsOpts.keyFile, "tls-key", "", "Client private key file (PEM) for mutual TLS")
+	flag.StringVar(&tlsOpts.caFile, "tls-ca", "", "CA bundle (PEM) used to verify the server certificate")
+	flag.StringVar(&tlsOpts.serverName, "tls-server-name", "", "Override the server name used for SNI and certificate verification")
+	flag.StringVar(&tlsOpts.minVersion, "tls-min-version", "", "Minimum TLS version (1.0, 1.1, 1.2 or 1.3)")
+	flag.BoolVar(&tlsOpts.insecureSkipVerify, "tls-insecure-skip-verify", false, "Skip server certificate verification (insecure, for staging only)")
+	var authOpts authOptions
+	flag.StringVar(&authOpts.bearerToken, "bearer-token", "", "Static bearer token attached to every request")
+	flag.StringVar(&authOpts.tokenURL, "oauth-token-url", "", "OAuth2 token endpoint for the client-credentials flow")
+	flag.StringVar(&authOpts.clientID, "oauth-client-id", "", "OAuth2 client ID")
+	flag.StringVar(&authOpts.clientSecret, "oauth-client-secret", "", "OAuth2 client secret")
+	flag.StringVar(&authOpts.scopes, "oauth-scopes", "", "Comma-separated OAuth2 scopes to request")
+	flag.Var(headerFlags(extraHeaders), "header", "Extra request header as \"Key: Value\" (repeatable)")
+	apiKey := flag.String("api-key", "", "API key sent with every request")
+	apiKeyHeader := flag.String("api-key-header", "X-API-Key", "Header that carries --api-key")
+	sigV4 := flag.Bool("sigv4", false, "Sign REST requests with AWS SigV4 (API Gateway, SageMaker and other AWS endpoints)")
+	sigV4Service := flag.String("sigv4-service", "execute-api", "AWS service name used for SigV4 signing")
+	gcpAuth := flag.Bool("gcp-auth", false, "Attach a Google-signed ID token (Cloud Run, IAP) to every request")
+	gcpAudienceFlag := flag.String("gcp-audience", "", "ID token audience (defaults to the scheme and host of --api)")
+	var azureOpts azureOptions
+	flag.StringVar(&azureOpts.mode, "azure-auth", "", "Azure AD authentication mode (client-secret or managed-identity)")
+	flag.StringVar(&azureOpts.tenantID, "azure-tenant-id", "", "Azure AD tenant ID for client-secret authentication")
+	flag.StringVar(&azureOpts.clientID, "azure-client-id", "", "Azure AD application ID, or the user-assigned managed identity client ID")
+	flag.StringVar(&azureOpts.clientSecret, "azure-client-secret", "", "Azure AD client secret (defaults to $AZURE_CLIENT_SECRET)")
+	flag.StringVar(&azureOpts.scope, "azure-scope", "https://ml.azure.com/.default", "Azure AD scope of the target API")
 	flag.Parse()
 
+	// the summary is printed, and the exit status set, after everything
+	// deferred below has been closed
+	var summary [][]string
+	var stopReason string
+	exitCode := 0
+	defer func() {
+		if summary != nil {
+			printSummary(stopReason, summary)
+			if *summaryFile != "" {
+				if err := saveSummary(*summaryFile, stopReason, summary); err != nil {
+					logger.Errorf("Failed to save summary: %v", err)
+				}
+			}
+			os.Exit(exitCode)
+		}
+	}()
+
+	if *cronSpec != "" {
+		if *startAt != "" {
+			logger.Fatalf("--start-at and --cron cannot be combined")
+		}
+		if *duration == 0 && *stages == "" && !stressOpts.enabled && maxRequests == 0 {
+			logger.Fatalf("--cron needs runs that end on their own: set --duration, --stages, --stress or --max-requests")
+		}
+		runs, err := parseCron(*cronSpec)
+		if err != nil {
+			logger.Fatalf("%v", err)
+		}
+		runRecurring(runs, *cronSpec, *archiveDir)
+		return
+	}
+	if *startAt != "" {
+		at, err := parseStartAt(*startAt, time.Now())
+		if err != nil {
+			logger.Fatalf("%v", err)
+		}
+		waitUntil(at)
+	}
+
+	if *targetsFile != "" {
+		urls, fileWeights, err := loadTargetsFile(*targetsFile)
+		if err != nil {
+			logger.Fatalf("Failed to load targets: %v", err)
+		}
+		apiURLs = append(apiURLs, urls...)
+		if *weights == "" {
+			*weights = fileWeights
+		}
+	}
+	endpoints = apiURLs
+	if *weights != "" {
+		parsed, err := parseWeights(*weights, len(endpoints))
+		if err != nil {
+			logger.Fatalf("Invalid --weights: %v", err)
+		}
+		setEndpointWeights(parsed)
+	}
+
+	if badRows != "fail" && badRows != "skip" && badRows != "clamp" {
+		logger.Fatalf("Unknown --bad-rows mode %q (expected fail, skip or clamp)", badRows)
+	}
+	if err := checkNormalizeMode(); err != nil {
+		logger.Fatalf("%v", err)
+	}
+	if err := setupCompression(); err != nil {
+		logger.Fatalf("%v", err)
+	}
+	if targetRPS < 0 {
+		logger.Fatalf("--rps must not be negative")
+	}
+	seedGiven := false
+	flag.Visit(func(f *flag.Flag) { seedGiven = seedGiven || f.Name == "seed" })
+	seedRandom(*seed, seedGiven)
+	if err := checkArrivalProcess(); err != nil {
+		logger.Fatalf("%v", err)
+	}
+	if *stages != "" {
+		if targetRPS > 0 {
+			logger.Fatalf("--stages sets the rate of each stage and cannot be combined with --rps")
+		}
+		parsed, err := parseStages(*stages)
+		if err != nil {
+			logger.Fatalf("Invalid --stages: %v", err)
+		}
+		loadStages = parsed
+		stageMetrics = make([]endpointStats, len(loadStages))
+		targetRPS = loadStages[0].rate
+	}
+	if *scenarioPath != "" {
+		if targetRPS > 0 || loadStages != nil {
+			logger.Fatalf("--scenario sets the rate of each phase and cannot be combined with --rps or --stages")
+		}
+		parsed, phases, err := loadScenario(*scenarioPath)
+		if err != nil {
+			logger.Fatalf("Invalid --scenario: %v", err)
+		}
+		loadStages, scenarioPhases = parsed, phases
+		stageMetrics = make([]endpointStats, len(loadStages))
+		targetRPS = loadStages[0].rate
+		activatePhase(0)
+	}
+	if wave.period != 0 {
+		if targetRPS > 0 || loadStages != nil {
+			logger.Fatalf("--sine-period sets its own rates and cannot be combined with --rps or --stages")
+		}
+		if err := checkSineWave(&wave); err != nil {
+			logger.Fatalf("%v", err)
+		}
+		loadWave = &wave
+		targetRPS = wave.max
+	}
+	if stressOpts.enabled {
+		measuringWindows = true
+		if loadWave != nil {
+			logger.Fatalf("--stress picks its own rates and cannot be combined with --sine-period")
+		}
+		if loadStages != nil {
+			logger.Fatalf("--stress picks its own rates and cannot be combined with --stages")
+		}
+		if err := checkStressOptions(); err != nil {
+			logger.Fatalf("%v", err)
+		}
+	}
+	if *replayFile != "" {
+		if targetRPS > 0 || loadStages != nil || loadWave != nil || stressOpts.enabled {
+			logger.Fatalf("--replay sends requests at their recorded times and cannot be combined with --rps, --stages, --sine-period or --stress")
+		}
+		if replaySpeed <= 0 {
+			logger.Fatalf("--replay-speed must be positive")
+		}
+		requests, err := loadReplay(*replayFile)
+		if err != nil {
+			logger.Fatalf("Failed to load --replay: %v", err)
+		}
+		replayRequests = requests
+	}
+	if *think != "" {
+		if targetRPS > 0 || loadStages != nil || stressOpts.enabled || replayRequests != nil || arrivalProcess == "poisson" {
+			logger.Fatalf("--think-time paces each bot on its own and cannot be combined with a shared rate (--rps, --stages, --sine-period, --stress), --replay or --arrival poisson")
+		}
+		parsed, err := parseThinkTime(*think)
+		if err != nil {
+			logger.Fatalf("Invalid --think-time: %v", err)
+		}
+		botThinkTime = parsed
+	}
+	if err := checkExecutionModel(); err != nil {
+		logger.Fatalf("%v", err)
+	}
+	if executionModel == "closed" && (targetRPS > 0 || loadStages != nil || stressOpts.enabled || replayRequests != nil || arrivalProcess == "poisson") {
+		logger.Fatalf("--loop closed paces each bot by its answers and cannot be combined with a shared rate (--rps, --stages, --sine-period, --stress), --replay or --arrival poisson")
+	}
+	if *sweep != "" {
+		if targetRPS > 0 || loadStages != nil || stressOpts.enabled || replayRequests != nil {
+			logger.Fatalf("--sweep sets its own levels and cannot be combined with --rps, --stages, --sine-period, --stress or --replay")
+		}
+		if err := parseSweep(*sweep); err != nil {
+			logger.Fatalf("%v", err)
+		}
+		if sweepMode == "rps" && (botThinkTime != nil || executionModel == "closed") {
+			logger.Fatalf("--sweep rps sets a shared rate and cannot be combined with --think-time or --loop closed")
+		}
+		if sweepWindow <= 0 {
+			logger.Fatalf("--sweep-window must be positive")
+		}
+		measuringWindows = true
+		if sweepMode == "rps" {
+			sweepRate, targetRPS = sweepFrom, sweepFrom
+		} else {
+			*numBots = int(sweepFrom)
+		}
+	}
+	if *bursts != "" {
+		if targetRPS > 0 || loadStages != nil || stressOpts.enabled || replayRequests != nil || botThinkTime != nil || executionModel == "closed" {
+			logger.Fatalf("--bursts paces each bot on its own and cannot be combined with a shared rate (--rps, --stages, --sine-period, --stress, --sweep rps), --replay, --think-time or --loop closed")
+		}
+		parsed, err := parseBurst(*bursts)
+		if err != nil {
+			logger.Fatalf("Invalid --bursts: %v", err)
+		}
+		botBurst = parsed
+	}
+	if (rampUp != 0 || rampDown != 0) && targetRPS == 0 && loadStages == nil && !stressOpts.enabled && sweepMode != "rps" {
+		logger.Fatalf("--ramp-up and --ramp-down need a --rps rate to ramp")
+	}
+	if rampUp < 0 || rampDown < 0 {
+		logger.Fatalf("--ramp-up and --ramp-down must not be negative")
+	}
+	if err := parseRateStep(*rateStep); err != nil {
+		logger.Fatalf("%v", err)
+	}
+	if *rateLimit != 0 {
+		bucket, err := newTokenBucket(*rateLimit, *burst)
+		if err != nil {
+			logger.Fatalf("%v", err)
+		}
+		rateLimiter = bucket
+	}
+	if err := checkCircuitOptions(); err != nil {
+		logger.Fatalf("%v", err)
+	}
+	if err := checkRetryOptions(); err != nil {
+		logger.Fatalf("%v", err)
+	}
+	if err := checkBudgetLimits(); err != nil {
+		logger.Fatalf("%v", err)
+	}
+	if err := checkCriteria(); err != nil {
+		logger.Fatalf("%v", err)
+	}
+	if err := setupLatencyHistogram(*latencyPrecision); err != nil {
+		logger.Fatalf("%v", err)
+	}
+	if *metricsAddr != "" {
+		if err := serveMetrics(*metricsAddr); err != nil {
+			logger.Fatalf("Failed to serve --metrics-addr: %v", err)
+		}
+	}
+	if *pprofAddr != "" {
+		if err := servePprof(*pprofAddr); err != nil {
+			logger.Fatalf("Failed to serve --pprof-addr: %v", err)
+		}
+	}
+	if *statsdAddr != "" {
+		if err := setupStatsD(*statsdAddr, *statsdPrefixFlag, *statsdTagsFlag); err != nil {
+			logger.Fatalf("Failed to set up --statsd: %v", err)
+		}
+	}
+	if influxTarget != "" && influxInterval <= 0 {
+		logger.Fatalf("--influx-interval must be positive")
+	}
+	if graphiteAddr != "" && graphiteInterval <= 0 {
+		logger.Fatalf("--graphite-interval must be positive")
+	}
+	if pushRunID == "" {
+		pushRunID = time.Now().Format("20060102-150405")
+	}
+	if otlpEndpoint != "" {
+		if err := setupOTLP(); err != nil {
+			logger.Fatalf("Failed to set up --otlp-endpoint: %v", err)
+		}
+		defer shutdownOTLP()
+	}
+	if err := setupPool(*maxInFlight, *queueSize); err != nil {
+		logger.Fatalf("%v", err)
+	}
+	sizes, err := parseBatchSizes(*batchSize)
+	if err != nil {
+		logger.Fatalf("Invalid --batch-size: %v", err)
+	}
+	batchSizes = sizes
+	if *augment != "" {
+		if normalizeMode == "standardize" {
+			logger.Fatalf("--augment needs 0-1 or 0-255 pixels and cannot be combined with --normalize standardize")
+		}
+		if augmentations, err = parseAugmentations(*augment); err != nil {
+			logger.Fatalf("Invalid --augment: %v", err)
+		}
+	}
+
+	adapter, ok := targetAdapters[*targetType]
+	if !ok {
+		logger.Fatalf("Unknown target type %q", *targetType)
+	}
+	target = adapter
+	if *bodyTemplateFile != "" {
+		tmpl, err := loadBodyTemplate(*bodyTemplateFile)
+		if err != nil {
+			logger.Fatalf("Failed to load body template: %v", err)
+		}
+		bodyTemplate = tmpl
+	}
+	if *envelope != "" {
+		if requestEnvelope, err = loadEnvelope(*envelope); err != nil {
+			logger.Fatalf("Failed to load envelope: %v", err)
+		}
+	}
+	if *payloadFormat == "" {
+		*payloadFormat = "target"
+		if requestEnvelope != nil {
+			*payloadFormat = "envelope"
+		} else if bodyTemplate != nil {
+			*payloadFormat = "template"
+		}
+	}
+	if (*payloadFormat == "b64" || *payloadFormat == "multipart") && normalizeMode == "standardize" {
+		logger.Fatalf("--payload-format %s sends PNG images of 0-1 or 0-255 pixels and cannot be combined with --normalize standardize", *payloadFormat)
+	}
+	payloadEncoder, err := newEncoder(*payloadFormat)
+	if err != nil {
+		logger.Fatalf("Invalid --payload-format: %v", err)
+	}
+	encoder = withContentType(payloadEncoder, *contentType)
+	if *targetType == "mlflow" && mlflowFormat != "dataframe_split" && mlflowFormat != "instances" {
+		logger.Fatalf("Unknown MLflow input format %q", mlflowFormat)
+	}
+
+	if modelVersion != "" {
+		if _, err := strconv.ParseInt(modelVersion, 10, 64); err != nil {
+			logger.Fatalf("Invalid model version %q: must be an integer", modelVersion)
+		}
+	}
+
+	tlsConfig, err := newTLSConfig(tlsOpts)
+	if err != nil {
+		logger.Fatalf("Failed to configure TLS: %v", err)
+	}
+	transportOpts.tlsConfig = tlsConfig
+	if tlsOpts.insecureSkipVerify {
+		logger.Warn("TLS certificate verification is disabled")
+	}
+
+	if *useHTTP3 {
+		if transportOpts.httpVersion != "auto" && transportOpts.httpVersion != "3" {
+			logger.Fatalf("--http3 conflicts with --http-version %s", transportOpts.httpVersion)
+		}
+		transportOpts.httpVersion = "3"
+	}
+	client, err := newHTTPClient(transportOpts)
+	if err != nil {
+		logger.Fatalf("Failed to configure HTTP transport: %v", err)
+	}
+	httpClient = client
+
+	if *apiKey != "" {
+		extraHeaders.Set(*apiKeyHeader, *apiKey)
+	}
+
+	tokenSource, err = newTokenSource(authOpts)
+	if err != nil {
+		logger.Fatalf("Failed to configure authentication: %v", err)
+	}
+	if tokenSource != nil {
+		signRequest = bearerTokenSigner(tokenSource)
+	}
+	if *gcpAuth {
+		if tokenSource != nil {
+			logger.Fatalf("--gcp-auth cannot be combined with other bearer token authentication")
+		}
+		audience := *gcpAudienceFlag
+		if audience == "" {
+			if len(endpoints) != 1 {
+				logger.Fatalf("--gcp-audience is required unless exactly one --api is given")
+			}
+			if audience, err = gcpAudience(endpoints[0]); err != nil {
+				logger.Fatalf("Invalid API endpoint URL: %v", err)
+			}
+		}
+		tokenSource, err = newIDTokenSource(audience)
+		if err != nil {
+			logger.Fatalf("Failed to set up GCP ID token authentication: %v", err)
+		}
+		signRequest = bearerTokenSigner(tokenSource)
+	}
+	if azureOpts.mode != "" {
+		if tokenSource != nil {
+			logger.Fatalf("--azure-auth cannot be combined with other bearer token authentication")
+		}
+		tokenSource, err = newAzureTokenSource(azureOpts)
+		if err != nil {
+			logger.Fatalf("Failed to set up Azure AD authentication: %v", err)
+		}
+		signRequest = bearerTokenSigner(tokenSource)
+	}
+	if *sigV4 {
+		if tokenSource != nil {
+			logger.Fatalf("--sigv4 cannot be combined with bearer token authentication")
+		}
+		signer, err := newSigV4Signer(*region, *sigV4Service)
+		if err != nil {
+			logger.Fatalf("Failed to set up SigV4 signing: %v", err)
+		}
+		signRequest = signer.sign
+	}
+
+	if failoverThreshold < 1 {
+		logger.Fatalf("--failover-threshold must be at least 1")
+	}
+	var newTarget newTargetFunc
+	switch *protocol {
+	case "rest":
+		for i, endpoint := range endpoints {
+			resolved, err := resolveModelURL(endpoint)
+			if err != nil {
+				logger.Fatalf("Invalid API endpoint URL: %v", err)
+			}
+			endpoints[i] = resolved
+		}
+		if backupEndpoint != "" {
+			if backupEndpoint, err = resolveModelURL(backupEndpoint); err != nil {
+				logger.Fatalf("Invalid backup endpoint URL: %v", err)
+			}
+		}
+		newTarget = newRESTTarget
+	case "grpc":
+		if grpcDeadline <= 0 {
+			logger.Fatalf("--grpc-deadline must be positive")
+		}
+		for _, endpoint := range allEndpoints() {
+			if err := dialGRPC(endpoint, tlsConfig); err != nil {
+				logger.Fatalf("Failed to connect to gRPC endpoint: %v", err)
+			}
+		}
+		defer closeGRPC()
+		newTarget = newGRPCTarget
+		if genericOpts.method != "" && len(endpoints) > 0 {
+			method, err := resolveGenericMethod(genericOpts, grpcConns[endpoints[0]])
+			if err != nil {
+				logger.Fatalf("Failed to resolve gRPC method: %v", err)
+			}
+			newTarget = newGenericGRPCTargets(method)
+		}
+	case "websocket":
+		// each bot dials its own connection per endpoint
+		configureWebSocket(tlsConfig)
+		newTarget = newWebSocketTarget
+	case "sagemaker":
+		if *endpointName == "" {
+			logger.Fatalf("--endpoint-name is required for the sagemaker protocol")
+		}
+		if len(endpoints) > 0 || backupEndpoint != "" {
+			logger.Fatalf("The sagemaker protocol calls --endpoint-name and cannot be combined with --api, --targets or --backup-api")
+		}
+		if signRequest != nil {
+			logger.Fatalf("The sagemaker protocol signs requests with SigV4 and cannot be combined with --bearer-token, --oauth-*, --gcp-auth, --azure-auth or --sigv4")
+		}
+		signer, err := newSigV4Signer(*region, "sagemaker")
+		if err != nil {
+			logger.Fatalf("Failed to set up SageMaker signing: %v", err)
+		}
+		endpoints = []string{sageMakerInvokeURL(signer.region, *endpointName)}
+		signRequest = signer.sign
+		newTarget = newRESTTarget
+	case "vertex":
+		if *gcpProject == "" || *region == "" || *endpointID == "" {
+			logger.Fatalf("--project, --region and --endpoint-id are required for the vertex protocol")
+		}
+		if len(endpoints) > 0 || backupEndpoint != "" {
+			logger.Fatalf("The vertex protocol calls --endpoint-id and cannot be combined with --api, --targets or --backup-api")
+		}
+		if signRequest != nil {
+			logger.Fatalf("The vertex protocol authenticates with Application Default Credentials and cannot be combined with --bearer-token, --oauth-*, --gcp-auth, --azure-auth or --sigv4")
+		}
+		tokens, err := newADCTokenSource()
+		if err != nil {
+			logger.Fatalf("Failed to set up Vertex AI authentication: %v", err)
+		}
+		endpoints = []string{vertexPredictURL(*gcpProject, *region, *endpointID)}
+		signRequest = bearerTokenSigner(tokens)
+		newTarget = newRESTTarget
+	case "kafka":
+		if kafkaOpts.topic == "" {
+			logger.Fatalf("--kafka-topic is required for the kafka protocol")
+		}
+		if kafkaOpts.acks != 0 && kafkaOpts.acks != 1 && kafkaOpts.acks != -1 {
+			logger.Fatalf("Invalid --kafka-acks %d (expected 0, 1 or -1)", kafkaOpts.acks)
+		}
+		kafkaOpts.tlsConfig = tlsConfig
+		producer, err := newKafkaProducer(kafkaOpts)
+		if err != nil {
+			logger.Fatalf("Failed to set up Kafka producer: %v", err)
+		}
+		defer producer.close()
+		endpoints = []string{producer.endpoint()}
+		newTarget = sharedTarget(producer)
+	case "mqtt":
+		if mqttOpts.topic == "" {
+			logger.Fatalf("--mqtt-topic is required for the mqtt protocol")
+		}
+		mqttOpts.tlsConfig = tlsConfig
+		publisher, err := newMQTTPublisher(mqttOpts)
+		if err != nil {
+			logger.Fatalf("Failed to set up MQTT publisher: %v", err)
+		}
+		defer publisher.close()
+		endpoints = []string{publisher.endpoint()}
+		newTarget = sharedTarget(publisher)
+	default:
+		logger.Fatalf("Unknown protocol %q (expected rest, grpc, websocket, sagemaker, vertex, kafka or mqtt)", *protocol)
+	}
+	if len(endpoints) == 0 {
+		logger.Fatalf("No API endpoint given (use --api or --targets)")
+	}
+
+	if *protocol == "rest" && !*skipPreflight {
+		// only a target type or health path the user chose makes the check
+		// binding; the tfserving default may not match the server at all
+		binding := *healthPath != ""
+		flag.Visit(func(f *flag.Flag) { binding = binding || f.Name == "target-type" })
+		if err := waitUntilReady(allEndpoints(), *targetType, *healthPath, *waitReady); err != nil {
+			if binding {
+				logger.Fatalf("Pre-flight check failed: %v", err)
+			}
+			logger.Warnf("Pre-flight check failed, starting anyway (give --target-type or --health-path to require it): %v", err)
+		}
+	}
+
 	// loads MNIST Data
-	if err := loadMNISTData(*dataFile); err != nil {
-		logger.Fatalf("Failed to load MNIST data: %v", err)
+	if *generate == "" {
+		if len(dataFiles) == 0 {
+			dataFiles = stringList{"./Assets/Data/data.json"}
+		}
+		if dataSources, err = parseDataSources(dataFiles); err != nil {
+			logger.Fatalf("Invalid --data: %v", err)
+		}
+		if len(dataSources) > 1 && labelsFile != "" {
+			logger.Fatalf("--labels applies to a single --data source")
+		}
+		dataSources = addPhaseSources(dataSources, scenarioPhases)
+		for i := range dataSources {
+			if dataSources[i].file, err = resolveDataFile(dataSources[i].name); err != nil {
+				logger.Fatalf("Failed to fetch MNIST data: %v", err)
+			}
+		}
+	}
+	if phasesNeedData(scenarioPhases) && (*generate != "" || *streamData || dataSources[0].file == stdinData) {
+		logger.Fatalf("--scenario phases with their own data need --data loaded into memory, so they cannot be combined with --generate or --stream")
+	}
+	if replayRequests != nil && (*generate != "" || *streamData || dataSources[0].file == stdinData) {
+		logger.Fatalf("--replay looks samples up in --data loaded into memory, so it cannot be combined with --generate or --stream")
+	}
+	if *generate != "" {
+		if shardMode != "none" || samplingStrategy != "" || *classes != "" {
+			logger.Fatalf("--shard, --sampling and --classes apply to --data, not generated samples")
+		}
+		if syntheticPatterns, err = parseSyntheticPatterns(*generate); err != nil {
+			logger.Fatalf("Invalid --generate: %v", err)
+		}
+		logToWidget(fmt.Sprintf("Generating %s samples", strings.Join(syntheticPatterns, ", ")))
+		rawPixels = normalizedRaw(false)
+	} else if *streamData || dataSources[0].file == stdinData {
+		if shardMode != "none" || samplingStrategy != "" || *classes != "" {
+			logger.Fatalf("--shard, --sampling and --classes apply to data loaded into memory; streamed samples are shared between bots in file order (or picked at random with --stream-buffer)")
+		}
+		if len(dataSources) > 1 {
+			logger.Fatalf("Only one --data source can be streamed")
+		}
+		if dataStream, err = openSampleStream(dataSources[0].file, *streamBuffer); err != nil {
+			logger.Fatalf("Failed to open MNIST data: %v", err)
+		}
+		defer dataStream.close()
+	} else {
+		var kept map[int]bool
+		if *classes != "" {
+			if kept, err = parseClasses(*classes); err != nil {
+				logger.Fatalf("Invalid --classes: %v", err)
+			}
+		}
+		if err := loadMNISTData(kept); err != nil {
+			logger.Fatalf("Failed to load MNIST data: %v", err)
+		}
+		if len(dataSources) > 1 {
+			logToWidget("Mixing " + describeMix(dataSources))
+		}
+		for _, source := range dataSources {
+			if err := checkShardMode(source.end-source.start, *numBots); err != nil {
+				logger.Fatalf("Invalid --shard: %v", err)
+			}
+		}
+		if shardMode != "none" && sweepMode == "bots" {
+			logger.Fatalf("--shard splits the samples between the bots started and cannot be combined with --sweep bots")
+		}
+		if err := checkSamplingStrategy(); err != nil {
+			logger.Fatalf("%v", err)
+		}
+		if shardMode != "none" {
+			logToWidget(fmt.Sprintf("Split %d samples %s between %d bots", len(mnistSamples), shardMode, *numBots))
+		}
+	}
+
+	if *resultsPath != "" {
+		if err := openResults(*resultsPath); err != nil {
+			logger.Fatalf("Failed to create results file: %v", err)
+		}
+		defer closeResults()
 	}
 
 	if err := termui.Init(); err != nil {
@@ -219,12 +1501,114 @@ func main() {
 	stopChan := make(chan os.Signal, 1)
 	signal.Notify(stopChan, syscall.SIGINT, syscall.SIGTERM)
 
-	logToWidget(fmt.Sprintf("Starting %d MNIST bots at %d-second intervals...", *numBots, *interval))
+	var schedule *arrivalSchedule
+	var arrivals <-chan time.Time
+	var scheduleDone, stressDone, replayDone, sweepDone, inputDone <-chan struct{}
+	if dataStream != nil {
+		inputDone = dataStream.inputEnded()
+	}
+	rateAdjustable := false // only a plain --rps rate follows the +/- keys
+	if replayRequests != nil {
+		logToWidget(fmt.Sprintf("Replaying %d requests over %s...", len(replayRequests), replayLength(replayRequests).Round(time.Second)))
+	} else if sweepMode == "rps" {
+		schedule = scheduleArrivals(sweepRateProfile, 0, quitChan)
+		arrivals = schedule.arrivals
+		logToWidget(fmt.Sprintf("Starting %d MNIST bots to sweep %.1f to %.1f requests per second...", *numBots, sweepFrom, sweepTo))
+	} else if sweepMode == "bots" && executionModel == "closed" {
+		logToWidget(fmt.Sprintf("Sweeping %.0f to %.0f closed-loop MNIST bots...", sweepFrom, sweepTo))
+	} else if sweepMode == "bots" {
+		logToWidget(fmt.Sprintf("Sweeping %.0f to %.0f MNIST bots at %d-second intervals...", sweepFrom, sweepTo, *interval))
+	} else if stressOpts.enabled {
+		start := targetRPS
+		if start == 0 {
+			start = 1
+		}
+		stressRate = start
+		schedule = scheduleArrivals(stressRateProfile, 0, quitChan)
+		arrivals = schedule.arrivals
+		stressDone = findMaxRate(start, quitChan)
+		logToWidget(fmt.Sprintf("Starting %d MNIST bots to find the highest rate with at most %.2f%% errors and a p99 of %s...", *numBots, 100*stressOpts.errorRate, stressOpts.p99))
+	} else if loadStages != nil {
+		schedule = scheduleArrivals(stageRate, stagesLength(loadStages), quitChan)
+		arrivals, scheduleDone = schedule.arrivals, schedule.done
+		logToWidget(fmt.Sprintf("Starting %d MNIST bots for %d stages over %s...", *numBots, len(loadStages), stagesLength(loadStages)))
+		logToWidget(fmt.Sprintf("%s: %.1f rps for %s", stageTitle(0), loadStages[0].rate, loadStages[0].duration))
+	} else if loadWave != nil {
+		schedule = scheduleArrivals(loadWave.rate, 0, quitChan)
+		arrivals = schedule.arrivals
+		logToWidget(fmt.Sprintf("Starting %d MNIST bots at %.1f to %.1f requests per second over a %s cycle...", *numBots, loadWave.min, loadWave.max, loadWave.period))
+	} else if targetRPS > 0 {
+		rateAdjustable = true
+		schedule = scheduleArrivals(targetRate, 0, quitChan)
+		arrivals = schedule.arrivals
+		logToWidget(fmt.Sprintf("Starting %d MNIST bots at %.1f requests per second...", *numBots, targetRPS))
+	} else if executionModel == "closed" && botThinkTime != nil {
+		logToWidget(fmt.Sprintf("Starting %d closed-loop MNIST bots with think times of %s...", *numBots, botThinkTime))
+	} else if executionModel == "closed" {
+		logToWidget(fmt.Sprintf("Starting %d closed-loop MNIST bots...", *numBots))
+	} else if botThinkTime != nil {
+		logToWidget(fmt.Sprintf("Starting %d MNIST bots with think times of %s...", *numBots, botThinkTime))
+	} else if botBurst != nil {
+		logToWidget(fmt.Sprintf("Starting %d MNIST bots sending bursts of %s...", *numBots, botBurst))
+	} else {
+		logToWidget(fmt.Sprintf("Starting %d MNIST bots at %d-second intervals...", *numBots, *interval))
+	}
 
+	botInterval := time.Duration(*interval) * time.Second
+	if botBurst != nil {
+		botInterval = botBurst.period
+	}
 	var wg sync.WaitGroup
-	for i := 0; i < *numBots; i++ {
+	metricsMutex.Lock()
+	runStarted = time.Now()
+	metricsMutex.Unlock()
+	startWorkers(&wg, quitChan)
+	if pushgatewayURL != "" {
+		startPushing(quitChan)
+	}
+	if statsdConn != nil {
+		startStatsDGauges(quitChan)
+	}
+	// the periodic sinks write a last point once the bots have stopped
+	sinksStop := make(chan struct{})
+	var sinksDone []<-chan struct{}
+	if influxTarget != "" {
+		sinksDone = append(sinksDone, startInflux(sinksStop))
+	}
+	if graphiteAddr != "" {
+		sinksDone = append(sinksDone, startGraphite(sinksStop))
+	}
+	samplers := newSamplers(*numBots)
+	// bots added during the run share the samplers of the first ones, which
+	// is why --shard rules out adding bots
+	bots := newBotPool(quitChan, func(index int, botQuit <-chan struct{}) {
 		wg.Add(1)
-		go startBot(*apiURL, time.Duration(*interval)*time.Second, &wg, quitChan)
+		if executionModel == "closed" {
+			go startClosedBot(index, samplers[index%len(samplers)], botRandom(index), newTarget, &wg, botQuit)
+			return
+		}
+		botArrivals := arrivals
+		if botArrivals == nil && arrivalProcess == "poisson" {
+			botArrivals = scheduleArrivals(constantRate(1/botInterval.Seconds()), 0, botQuit).arrivals
+		}
+		go startBot(index, samplers[index%len(samplers)], botRandom(index), newTarget, botInterval, botArrivals, &wg, botQuit)
+	})
+	if replayRequests != nil {
+		replayDone = startReplay(replayRequests, newTarget, &wg, quitChan)
+	} else {
+		for i := 0; i < *numBots; i++ {
+			bots.add()
+		}
+	}
+	switch sweepMode {
+	case "bots":
+		sweepDone = runSweep(func(level float64) { bots.resize(int(level)) }, quitChan)
+	case "rps":
+		sweepDone = runSweep(func(level float64) {
+			metricsMutex.Lock()
+			sweepRate, targetRPS = level, level
+			metricsMutex.Unlock()
+		}, quitChan)
 	}
 
 	uiEvents := termui.PollEvents()
@@ -232,49 +1616,171 @@ func main() {
 	logWidget := renderLogWidget()
 
 	// Initial UI rendering
+	layoutWidgets(table, logWidget)
 	termui.Render(table, logWidget)
 
+	stopRequests := make(chan struct{}, 1)
 	go func() {
+		// redraw every second, and right away after a key so it takes effect
+		// visibly
+		redraw := time.NewTicker(1 * time.Second)
+		defer redraw.Stop()
+		render := func() {
+			metricsMutex.Lock()
+			table.Rows = metricsRows()
+			metricsMutex.Unlock()
+			layoutWidgets(table, logWidget)
+
+			logMutex.Lock()
+			logWidget.Rows = append([]string{}, logEntries...) // Prevent infinite growth
+			logMutex.Unlock()
+
+			termui.Render(table, logWidget)
+		}
+		render()
 		for {
 			select {
 			case <-quitChan:
 				return
+			case <-redraw.C:
+				render()
 			case e := <-uiEvents:
-				if e.Type == termui.KeyboardEvent && e.ID == "q" {
-					logToWidget("Received 'q'. Stopping bots...")
-					close(quitChan)
-					return
+				if e.Type != termui.KeyboardEvent {
+					break
 				}
-			default:
-				metricsMutex.Lock()
-				table.Rows = [][]string{
-					{"Metric", "Value"},
-					{"Total Requests", fmt.Sprintf("%d", totalRequests)},
-					{"Success Requests", fmt.Sprintf("%d", successRequests)},
-					{"Average Latency (ms)", fmt.Sprintf("%.2f", averageLatency)},
+				switch e.ID {
+				case "q":
+					logToWidget("Received 'q'. Stopping bots...")
+					select {
+					case stopRequests <- struct{}{}:
+					default:
+					}
+				case "+", "=", "-":
+					if !rateAdjustable {
+						logToWidget("+ and - change the --rps rate, which this run doesn't use")
+						break
+					}
+					direction := 1
+					if e.ID == "-" {
+						direction = -1
+					}
+					adjustRate(direction)
+				case "p":
+					togglePause()
+				case "]", "[":
+					if replayRequests != nil {
+						logToWidget("There are no bots to scale while replaying")
+						break
+					}
+					if shardMode != "none" {
+						logToWidget("Bots can't be added or removed with --shard, which splits the samples between the bots started")
+						break
+					}
+					direction := 1
+					if e.ID == "[" {
+						direction = -1
+					}
+					scaleBots(bots, direction)
 				}
-				metricsMutex.Unlock()
-
-				logMutex.Lock()
-				logWidget.Rows = append([]string{}, logEntries...) // Prevent infinite growth
-				logMutex.Unlock()
-
-				termui.Render(table, logWidget)
-				time.Sleep(1 * time.Second)
+				render()
 			}
 		}
 	}()
+	var deadline <-chan time.Time
+	hardStop := false
+	if *duration > 0 {
+		deadline = time.After(*duration)
+	}
+
 	// Wait for stop signal (Ctrl+C or 'q')
 	select {
 	case <-stopChan:
 		logToWidget("\nReceived stop signal (Ctrl+C). Shutting down MNIST bots...")
-		close(quitChan) // Signal goroutines to stop
-	case <-quitChan:
-		// 'q' key was pressed, and quitChan was closed
+		stopReason = "interrupted"
+	case <-stopRequests:
+		stopReason = "stopped with q"
+	case <-scheduleDone:
+		logToWidget("All stages done. Stopping bots...")
+		stopReason = "all stages done"
+		schedule = nil
+	case <-sweepDone:
+		logToWidget("Sweep done. Stopping bots...")
+		stopReason = "sweep done"
+		schedule = nil
+	case <-replayDone:
+		logToWidget("Replay finished. Stopping...")
+		stopReason = "replay finished"
+	case <-inputDone:
+		logToWidget("Input ended. Stopping bots...")
+		stopReason = "input ended"
+		schedule = nil
+	case <-stressDone:
+		stopReason = "stress test done"
+		schedule = nil
+	case <-deadline:
+		logToWidget(fmt.Sprintf("Run duration of %s reached. Stopping bots...", *duration))
+		stopReason = fmt.Sprintf("run duration of %s reached", *duration)
+		hardStop = true
+	case <-budgetExhausted:
+		metricsMutex.Lock()
+		stopReason = budgetReason
+		metricsMutex.Unlock()
+		logToWidget(fmt.Sprintf("Stopping bots: %s", stopReason))
+		schedule = nil // the budget is spent, so don't ramp down
+	}
+	if schedule != nil && rampDown > 0 {
+		logToWidget(fmt.Sprintf("Ramping down over %s (press q again to stop now)...", rampDown))
+		select {
+		case <-schedule.stop():
+		case <-stopChan:
+		case <-stopRequests:
+		}
+	}
+	close(quitChan) // Signal goroutines to stop
+	metricsMutex.Lock()
+	runStopped = time.Now()
+	metricsMutex.Unlock()
+	stopped := waitDone(&wg)
+	if !hardStop { // once the deadline is up, don't wait for hung requests
+		metricsMutex.Lock()
+		inFlight := busyWorkers
+		metricsMutex.Unlock()
+		if inFlight > 0 {
+			logToWidget(fmt.Sprintf("Waiting up to %s for %d requests in flight (press q again to abandon them)...", drainTimeout, inFlight))
+		}
+		select {
+		case <-stopped:
+		case <-time.After(drainTimeout):
+		case <-stopChan:
+		case <-stopRequests:
+		}
+	}
+	cancelRun()
+	<-stopped // Wait for all bots to exit
+	close(sinksStop)
+	for _, done := range sinksDone {
+		<-done
 	}
-
-	wg.Wait() // Wait for all bots to exit
 	logToWidget("All bots stopped.\n")
 	termui.Render(logWidget)    // Render final logs
 	time.Sleep(2 * time.Second) // Allow time to see the final logs
+
+	metricsMutex.Lock()
+	summary, exitCode = metricsRows(), runExitCode()
+	metricsMutex.Unlock()
+	if *sweepOutput != "" {
+		if err := saveSweep(*sweepOutput); err != nil {
+			logger.Errorf("Failed to save the sweep: %v", err)
+		}
+	}
+	if *histogramFile != "" {
+		if err := saveHistogram(*histogramFile); err != nil {
+			logger.Errorf("Failed to save the latency histogram: %v", err)
+		}
+	}
+	if pushgatewayURL != "" {
+		if err := pushMetrics(); err != nil {
+			logger.Errorf("Failed to push the final metrics: %v", err)
+		}
+	}
 }
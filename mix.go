@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// dataSource is one --data dataset and its share of the traffic
+type dataSource struct {
+	name       string  // as given, without the weight
+	file       string  // local path, once fetched
+	weight     float64 // relative to the other sources
+	start, end int     // range of its samples in mnistSamples
+	phaseOnly  bool    // only drawn from by the --scenario phases naming it
+}
+
+// dataSources are the datasets of the current run, mixed by weight
+var dataSources []dataSource
+
+// parseDataSources parses the --data flags, each a dataset with an optional
+// weight ("noise.json:0.2"); sources without one weigh 1
+func parseDataSources(specs []string) ([]dataSource, error) {
+	sources := make([]dataSource, len(specs))
+	for i, spec := range specs {
+		source := dataSource{name: spec, weight: 1}
+		// a colon followed by a number is a weight, anything else (a URL
+		// scheme, a drive letter) is part of the name
+		if at := strings.LastIndex(spec, ":"); at > 0 {
+			if weight, err := strconv.ParseFloat(spec[at+1:], 64); err == nil {
+				if weight <= 0 {
+					return nil, fmt.Errorf("weight of %s must be positive", spec[:at])
+				}
+				source = dataSource{name: spec[:at], weight: weight}
+			}
+		}
+		source.file = source.name
+		sources[i] = source
+	}
+	return sources, nil
+}
+
+// describeMix lists the sources with their share of the traffic
+func describeMix(sources []dataSource) string {
+	total := 0.0
+	for _, source := range sources {
+		total += source.weight
+	}
+	parts := make([]string, len(sources))
+	for i, source := range sources {
+		parts[i] = fmt.Sprintf("%s (%d samples, %.0f%%)", source.name, source.end-source.start, 100*source.weight/total)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// mixSamplers returns a sampler drawing from the samplers of each source in
+// proportion to its weight
+func mixSamplers(parts []*sampler) *sampler {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	mix := &sampler{mix: parts}
+	total := 0.0
+	for _, source := range dataSources {
+		if !source.phaseOnly {
+			total += source.weight
+		}
+		mix.mixWeights = append(mix.mixWeights, total)
+	}
+	return mix
+}
+
+// pickPart draws the sampler of a source by weight, or the source of the
+// running --scenario phase
+func (s *sampler) pickPart(random *rand.Rand) *sampler {
+	if source := currentSource(); source >= 0 {
+		return s.mix[source]
+	}
+	n := random.Float64() * s.mixWeights[len(s.mixWeights)-1]
+	for i, weight := range s.mixWeights {
+		if n < weight {
+			return s.mix[i]
+		}
+	}
+	return s.mix[len(s.mix)-1]
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttOptions holds the settings of the MQTT publisher
+type mqttOptions struct {
+	broker          string
+	topic           string
+	qos             int
+	clientID        string
+	username        string
+	password        string
+	responseTopic   string
+	responseTimeout time.Duration
+	tlsConfig       *tls.Config
+}
+
+// mqttRequest is a published sample waiting for its reply
+type mqttRequest struct {
+	reply chan []byte
+}
+
+// unmatchedReplies counts replies on the response topic that belong to no
+// waiting request, e.g. ones arriving after their request timed out
+var unmatchedReplies int
+
+// mqttPublisher publishes samples to a topic and, when a response topic is
+// set, matches replies to requests by request ID. Each request is published
+// to <topic>/<id> and its reply is expected on <response topic>/<id>.
+type mqttPublisher struct {
+	opts    mqttOptions
+	client  mqtt.Client
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[string]*mqttRequest
+}
+
+// newMQTTPublisher connects to the broker and subscribes to the response
+// topic, if any. The subscription is renewed whenever the client reconnects.
+func newMQTTPublisher(opts mqttOptions) (*mqttPublisher, error) {
+	if opts.qos < 0 || opts.qos > 2 {
+		return nil, fmt.Errorf("invalid MQTT QoS %d (expected 0, 1 or 2)", opts.qos)
+	}
+
+	p := &mqttPublisher{opts: opts, pending: map[string]*mqttRequest{}}
+	clientOpts := mqtt.NewClientOptions().
+		AddBroker(opts.broker).
+		SetClientID(opts.clientID).
+		SetUsername(opts.username).
+		SetPassword(opts.password).
+		SetTLSConfig(opts.tlsConfig).
+		SetAutoReconnect(true).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logToWidget(fmt.Sprintf("MQTT connection lost: %v", err))
+		})
+	if opts.responseTopic != "" {
+		clientOpts.SetOnConnectHandler(func(client mqtt.Client) {
+			token := client.Subscribe(opts.responseTopic+"/+", byte(opts.qos), p.handleReply)
+			if token.WaitTimeout(opts.responseTimeout) && token.Error() != nil {
+				logToWidget(fmt.Sprintf("Error subscribing to %s: %v", opts.responseTopic, token.Error()))
+			}
+		})
+	}
+
+	p.client = mqtt.NewClient(clientOpts)
+	token := p.client.Connect()
+	if !token.WaitTimeout(opts.responseTimeout) {
+		return nil, fmt.Errorf("timed out connecting to %s", opts.broker)
+	}
+	if err := token.Error(); err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", opts.broker, err)
+	}
+	return p, nil
+}
+
+// endpoint is the label used for the publisher in the metrics table
+func (p *mqttPublisher) endpoint() string {
+	return fmt.Sprintf("%s/%s", p.opts.broker, p.opts.topic)
+}
+
+// close disconnects from the broker
+func (p *mqttPublisher) close() {
+	p.client.Disconnect(250)
+}
+
+// handleReply hands a message on the response topic to the request whose ID
+// ends the topic, counting replies no request is waiting for
+func (p *mqttPublisher) handleReply(_ mqtt.Client, msg mqtt.Message) {
+	id := strings.TrimPrefix(msg.Topic(), p.opts.responseTopic+"/")
+	p.mu.Lock()
+	request, ok := p.pending[id]
+	delete(p.pending, id)
+	p.mu.Unlock()
+	if !ok {
+		metricsMutex.Lock()
+		unmatchedReplies++
+		metricsMutex.Unlock()
+		return
+	}
+	request.reply <- msg.Payload()
+}
+
+// forget removes a request that gave up waiting for its reply
+func (p *mqttPublisher) forget(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pending, id)
+}
+
+// mqttRows is the metrics row of the unmatched MQTT replies. Callers must
+// hold metricsMutex.
+func mqttRows() [][]string {
+	if unmatchedReplies == 0 {
+		return nil
+	}
+	return [][]string{{"Unmatched Replies", fmt.Sprintf("%d", unmatchedReplies)}}
+}
+
+// Send publishes one batch. Without a response topic the latency is the
+// time until the broker acknowledges the publish (immediate for QoS 0);
+// otherwise it is the time until the inference reply arrives.
+func (p *mqttPublisher) Send(ctx context.Context, batch [][]float64) (Result, error) {
+	var result Result
+
+	payload, err := encoder.Encode(ctx, batch)
+	if err != nil {
+		return result, fmt.Errorf("error building payload: %v", err)
+	}
+
+	topic := p.opts.topic
+	var id string
+	var request *mqttRequest
+	if p.opts.responseTopic != "" {
+		// Register before publishing so a fast reply cannot arrive first
+		request = &mqttRequest{reply: make(chan []byte, 1)}
+		p.mu.Lock()
+		p.nextID++
+		id = strconv.FormatUint(p.nextID, 10)
+		p.pending[id] = request
+		p.mu.Unlock()
+		topic += "/" + id
+	}
+
+	startTime := time.Now()
+	token := p.client.Publish(topic, byte(p.opts.qos), false, payload)
+	if !token.WaitTimeout(p.opts.responseTimeout) {
+		if request != nil {
+			p.forget(id)
+		}
+		return result, &sendError{fmt.Errorf("timed out publishing to %s after %s", topic, p.opts.responseTimeout)}
+	}
+	if err := token.Error(); err != nil {
+		if request != nil {
+			p.forget(id)
+		}
+		return result, &sendError{fmt.Errorf("error publishing to %s: %w", topic, err)}
+	}
+	result.Protocol = "MQTT"
+
+	if request == nil {
+		result.Latency = time.Since(startTime).Seconds() * 1000
+		return result, nil
+	}
+
+	var body []byte
+	select {
+	case body = <-request.reply:
+	case <-time.After(p.opts.responseTimeout):
+		p.forget(id)
+		return result, fmt.Errorf("no reply on %s within %s", p.opts.responseTopic, p.opts.responseTimeout)
+	case <-ctx.Done():
+		p.forget(id)
+		return result, ctx.Err()
+	}
+	result.Latency = time.Since(startTime).Seconds() * 1000
+
+	scores, err := target.parseResponse(body)
+	if err != nil {
+		return result, fmt.Errorf("invalid response: %v", err)
+	}
+	result.Predicted = predictedDigits(scores)
+	return result, nil
+}
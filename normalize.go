@@ -0,0 +1,99 @@
+package main
+
+import "fmt"
+
+var (
+	// normalizeMode rescales pixels after loading: none, raw, scaled or standardize
+	normalizeMode string
+	// normalizeMean and normalizeStd are used by the standardize mode
+	normalizeMean float64
+	normalizeStd  float64
+	// inputScale is the scale of the loaded pixels: auto, raw (0-255) or
+	// scaled (0-1)
+	inputScale string
+	// rawPixels tells whether the samples sent hold 0-255 pixels rather than
+	// 0-1 pixels once normalized, for PNG payloads and augmentations. It is
+	// decided once for the whole dataset, when it is loaded or streamed.
+	rawPixels bool
+)
+
+// checkNormalizeMode validates --normalize, its parameters and --input-scale
+func checkNormalizeMode() error {
+	if inputScale != "auto" && inputScale != "raw" && inputScale != "scaled" {
+		return fmt.Errorf("unknown --input-scale %q (expected auto, raw or scaled)", inputScale)
+	}
+	switch normalizeMode {
+	case "none", "raw", "scaled":
+		return nil
+	case "standardize":
+		if normalizeStd <= 0 {
+			return fmt.Errorf("--normalize-std must be positive")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown --normalize mode %q (expected none, raw, scaled or standardize)", normalizeMode)
+}
+
+// isRawScale reports whether samples hold raw 0-255 pixels rather than
+// pixels scaled to 0-1. Unless --input-scale says so, the scale is decided
+// once for all the samples given: raw if any pixel exceeds 1.
+func isRawScale(samples ...[]float64) bool {
+	if inputScale != "auto" && inputScale != "" {
+		return inputScale == "raw"
+	}
+	for _, sample := range samples {
+		for _, pixel := range sample {
+			if pixel > 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// normalizedRaw reports whether samples hold 0-255 pixels once normalized,
+// given whether they did when loaded
+func normalizedRaw(raw bool) bool {
+	switch normalizeMode {
+	case "none", "":
+		return raw
+	case "raw":
+		return true
+	}
+	return false
+}
+
+// normalizeSample returns a sample's pixels in the --normalize scale. raw
+// tells whether the sample holds 0-255 pixels or pixels scaled to 0-1.
+func normalizeSample(pixels []float64, raw bool) []float64 {
+	if normalizeMode == "none" || normalizeMode == "" {
+		return pixels
+	}
+
+	normalized := make([]float64, len(pixels))
+	for i, pixel := range pixels {
+		if raw {
+			pixel /= 255
+		}
+		switch normalizeMode {
+		case "raw":
+			pixel *= 255
+		case "standardize":
+			pixel = (pixel - normalizeMean) / normalizeStd
+		}
+		normalized[i] = pixel
+	}
+	return normalized
+}
+
+// normalizeSamples applies normalizeSample to every sample of a dataset in
+// place, with one input scale for the whole dataset
+func normalizeSamples(samples [][]float64) {
+	if normalizeMode == "none" || normalizeMode == "" {
+		return
+	}
+	raw := isRawScale(samples...)
+	for i, sample := range samples {
+		samples[i] = normalizeSample(sample, raw)
+	}
+}
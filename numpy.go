@@ -0,0 +1,265 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// npzKey selects the sample array of an .npz archive
+var npzKey string
+
+// sampleArrayNames are the conventional names of sample arrays, tried in
+// order when an archive holds several arrays
+var sampleArrayNames = []string{"x_test", "x", "images", "x_train"}
+
+var (
+	npyDescr   = regexp.MustCompile(`'descr':\s*'([<>|=])([a-z])(\d+)'`)
+	npyFortran = regexp.MustCompile(`'fortran_order':\s*True`)
+	npyShape   = regexp.MustCompile(`'shape':\s*\(([^)]*)\)`)
+)
+
+// numericArray is a decoded NumPy or HDF5 array flattened in C order
+type numericArray struct {
+	shape  []int
+	values []float64
+	// rawPixels reports an unsigned 8-bit dtype, i.e. pixels in 0-255
+	rawPixels bool
+}
+
+// isNumPyFile reports whether a file is a NumPy .npy or .npz file
+func isNumPyFile(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return ext == ".npy" || ext == ".npz"
+}
+
+// loadNumPyDataset loads samples from an (N, 784) or (N, 28, 28) array in an
+// .npy file or an .npz archive. An archive may also hold the matching labels,
+// e.g. y_test next to x_test. Unsigned 8-bit pixels are scaled to [0, 1].
+func loadNumPyDataset(filename string) ([][]float64, []int, error) {
+	if strings.ToLower(filepath.Ext(filename)) == ".npy" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open file: %v", err)
+		}
+		defer file.Close()
+		array, err := readNPY(bufio.NewReader(file))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %v", filename, err)
+		}
+		samples, err := array.samples()
+		return samples, nil, err
+	}
+
+	archive, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open archive: %v", err)
+	}
+	defer archive.Close()
+
+	arrays := map[string]*zip.File{}
+	for _, file := range archive.File {
+		arrays[strings.TrimSuffix(file.Name, ".npy")] = file
+	}
+	key := npzKey
+	if key == "" {
+		for _, candidate := range sampleArrayNames {
+			if _, ok := arrays[candidate]; ok {
+				key = candidate
+				break
+			}
+		}
+		if key == "" && len(arrays) == 1 {
+			for name := range arrays {
+				key = name
+			}
+		}
+	}
+	if arrays[key] == nil {
+		return nil, nil, fmt.Errorf("no sample array found in %s (use --npz-key)", filename)
+	}
+
+	array, err := readNPZArray(arrays[key])
+	if err != nil {
+		return nil, nil, err
+	}
+	samples, err := array.samples()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	labelFile := arrays[labelArrayName(key)]
+	if labelFile == nil {
+		return samples, nil, nil
+	}
+	labelArray, err := readNPZArray(labelFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(labelArray.shape) != 1 || labelArray.shape[0] != len(samples) {
+		return nil, nil, fmt.Errorf("%s has shape %v, expected %d labels", labelFile.Name, labelArray.shape, len(samples))
+	}
+	labels := make([]int, len(labelArray.values))
+	for i, label := range labelArray.values {
+		labels[i] = int(label)
+	}
+	return samples, labels, nil
+}
+
+// labelArrayName returns the conventional label array name for a sample
+// array, e.g. y_test for x_test
+func labelArrayName(key string) string {
+	if key == "images" {
+		return "labels"
+	}
+	if strings.HasPrefix(key, "x") {
+		return "y" + key[1:]
+	}
+	return ""
+}
+
+// readNPZArray decodes one array of an .npz archive
+func readNPZArray(file *zip.File) (*numericArray, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", file.Name, err)
+	}
+	defer reader.Close()
+	array, err := readNPY(bufio.NewReader(reader))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", file.Name, err)
+	}
+	return array, nil
+}
+
+// readNPY decodes a numeric C-order array in the .npy format
+func readNPY(reader io.Reader) (*numericArray, error) {
+	var preamble [8]byte
+	if _, err := io.ReadFull(reader, preamble[:]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(preamble[:6], []byte("\x93NUMPY")) {
+		return nil, fmt.Errorf("not a .npy file")
+	}
+
+	var headerLen uint32
+	if preamble[6] == 1 {
+		var n uint16
+		if err := binary.Read(reader, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		headerLen = uint32(n)
+	} else if err := binary.Read(reader, binary.LittleEndian, &headerLen); err != nil {
+		return nil, err
+	}
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	descr := npyDescr.FindSubmatch(header)
+	shapeMatch := npyShape.FindSubmatch(header)
+	if descr == nil || shapeMatch == nil {
+		return nil, fmt.Errorf("unsupported .npy header %q", header)
+	}
+	if npyFortran.Match(header) {
+		return nil, fmt.Errorf("Fortran-ordered arrays are not supported")
+	}
+
+	array := &numericArray{}
+	count := 1
+	for _, dim := range strings.Split(string(shapeMatch[1]), ",") {
+		dim = strings.TrimSpace(dim)
+		if dim == "" {
+			continue
+		}
+		n, err := strconv.Atoi(dim)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shape %q", shapeMatch[1])
+		}
+		array.shape = append(array.shape, n)
+		count *= n
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if descr[1][0] == '>' {
+		order = binary.BigEndian
+	}
+	kind := descr[2][0]
+	size, _ := strconv.Atoi(string(descr[3]))
+	decode, err := npyDecoder(kind, size, order)
+	if err != nil {
+		return nil, err
+	}
+	array.rawPixels = kind == 'u' && size == 1
+
+	data := make([]byte, count*size)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
+	array.values = make([]float64, count)
+	for i := range array.values {
+		array.values[i] = decode(data[i*size : (i+1)*size])
+	}
+	return array, nil
+}
+
+// npyDecoder returns a function decoding one element of a numeric dtype
+func npyDecoder(kind byte, size int, order binary.ByteOrder) (func([]byte) float64, error) {
+	switch {
+	case kind == 'f' && size == 4:
+		return func(b []byte) float64 { return float64(math.Float32frombits(order.Uint32(b))) }, nil
+	case kind == 'f' && size == 8:
+		return func(b []byte) float64 { return math.Float64frombits(order.Uint64(b)) }, nil
+	case (kind == 'u' || kind == 'b') && size == 1:
+		return func(b []byte) float64 { return float64(b[0]) }, nil
+	case kind == 'i' && size == 1:
+		return func(b []byte) float64 { return float64(int8(b[0])) }, nil
+	case kind == 'u' && size == 2:
+		return func(b []byte) float64 { return float64(order.Uint16(b)) }, nil
+	case kind == 'i' && size == 2:
+		return func(b []byte) float64 { return float64(int16(order.Uint16(b))) }, nil
+	case kind == 'u' && size == 4:
+		return func(b []byte) float64 { return float64(order.Uint32(b)) }, nil
+	case kind == 'i' && size == 4:
+		return func(b []byte) float64 { return float64(int32(order.Uint32(b))) }, nil
+	case kind == 'u' && size == 8:
+		return func(b []byte) float64 { return float64(order.Uint64(b)) }, nil
+	case kind == 'i' && size == 8:
+		return func(b []byte) float64 { return float64(int64(order.Uint64(b))) }, nil
+	}
+	return nil, fmt.Errorf("unsupported dtype %c%d", kind, size)
+}
+
+// samples splits an (N, 784) or (N, 28, 28) array into pixel vectors
+func (a *numericArray) samples() ([][]float64, error) {
+	if len(a.shape) != 2 && len(a.shape) != 3 {
+		return nil, fmt.Errorf("array has shape %v, expected (N, 784) or (N, 28, 28)", a.shape)
+	}
+	size := 1
+	for _, dim := range a.shape[1:] {
+		size *= dim
+	}
+	samples := make([][]float64, a.shape[0])
+	for i := range samples {
+		sample := a.values[i*size : (i+1)*size]
+		if a.rawPixels {
+			scaled := make([]float64, size)
+			for j, pixel := range sample {
+				scaled[j] = pixel / 255
+			}
+			sample = scaled
+		}
+		samples[i] = sample
+	}
+	return samples, nil
+}
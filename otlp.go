@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+var (
+	// otlpEndpoint, when set, is the base URL of the OpenTelemetry collector
+	// the --otlp-export signals are sent to over OTLP/HTTP (e.g.
+	// http://localhost:4318)
+	otlpEndpoint string
+	// otlpExport lists the signals exported: traces and/or metrics
+	otlpExport string
+	// otlpInterval is how often the metrics are exported
+	otlpInterval time.Duration
+	// meterProvider is nil while the metrics aren't exported
+	meterProvider *sdkmetric.MeterProvider
+	// otlpLatency records the latency of successful requests, in seconds
+	otlpLatency metric.Float64Histogram
+)
+
+// otlpSignals parses --otlp-export into the set of signals to export
+func otlpSignals() (map[string]bool, error) {
+	signals := make(map[string]bool)
+	for _, signal := range strings.Split(otlpExport, ",") {
+		signal = strings.TrimSpace(signal)
+		if signal != "traces" && signal != "metrics" {
+			return nil, fmt.Errorf("unknown --otlp-export signal %q (expected traces or metrics)", signal)
+		}
+		signals[signal] = true
+	}
+	return signals, nil
+}
+
+// otlpTarget splits --otlp-endpoint into the collector's host, whether it
+// is plain http, and the path of a signal under its base path
+func otlpTarget(signal string) (host string, insecure bool, urlPath string, err error) {
+	u, err := url.Parse(otlpEndpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false, "", fmt.Errorf("invalid --otlp-endpoint %q (expected an http(s) URL)", otlpEndpoint)
+	}
+	return u.Host, u.Scheme == "http", path.Join("/", u.Path, "v1", signal), nil
+}
+
+// otlpResource describes the bot to the collector
+func otlpResource() *resource.Resource {
+	return resource.NewSchemaless(attribute.String("service.name", "mnist-bot"), attribute.String("mnist.run_id", pushRunID))
+}
+
+// setupOTLP starts exporting the --otlp-export signals to --otlp-endpoint
+func setupOTLP() error {
+	signals, err := otlpSignals()
+	if err != nil {
+		return err
+	}
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logToWidget(fmt.Sprintf("Failed to export to --otlp-endpoint: %v", err))
+	}))
+	if signals["traces"] {
+		if err := setupTracing(); err != nil {
+			return err
+		}
+	}
+	if signals["metrics"] {
+		if err := setupOTLPMetrics(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shutdownOTLP flushes the spans and metrics not exported yet
+func shutdownOTLP() {
+	shutdownTracing()
+	shutdownOTLPMetrics()
+}
+
+// setupOTLPMetrics exports the request counters, the latency histogram and
+// the in-flight, queued and bots gauges every --otlp-interval
+func setupOTLPMetrics() error {
+	if otlpInterval <= 0 {
+		return fmt.Errorf("--otlp-interval must be positive")
+	}
+	host, insecure, urlPath, err := otlpTarget("metrics")
+	if err != nil {
+		return err
+	}
+	options := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpoint(host), otlpmetrichttp.WithURLPath(urlPath)}
+	if insecure {
+		options = append(options, otlpmetrichttp.WithInsecure())
+	}
+	exporter, err := otlpmetrichttp.New(context.Background(), options...)
+	if err != nil {
+		return fmt.Errorf("error creating the OTLP exporter: %v", err)
+	}
+	meterProvider = sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(otlpInterval))),
+		sdkmetric.WithResource(otlpResource()),
+	)
+	meter := meterProvider.Meter("mnist-bot")
+
+	if otlpLatency, err = meter.Float64Histogram("mnist_bot.request.duration", metric.WithUnit("s"),
+		metric.WithDescription("Latency of successful requests."), metric.WithExplicitBucketBoundaries(latencyBuckets...)); err != nil {
+		return err
+	}
+	requests, err := meter.Int64ObservableCounter("mnist_bot.requests", metric.WithDescription("Requests finished, by result."))
+	if err != nil {
+		return err
+	}
+	failures, err := meter.Int64ObservableCounter("mnist_bot.failures", metric.WithDescription("Failed requests, by kind."))
+	if err != nil {
+		return err
+	}
+	responses, err := meter.Int64ObservableCounter("mnist_bot.responses", metric.WithDescription("Answers, by status code."))
+	if err != nil {
+		return err
+	}
+	inFlight, err := meter.Int64ObservableGauge("mnist_bot.in_flight", metric.WithDescription("Requests being sent."))
+	if err != nil {
+		return err
+	}
+	queued, err := meter.Int64ObservableGauge("mnist_bot.queued", metric.WithDescription("Requests waiting for a worker."))
+	if err != nil {
+		return err
+	}
+	bots, err := meter.Int64ObservableGauge("mnist_bot.bots", metric.WithDescription("Bots running."))
+	if err != nil {
+		return err
+	}
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		metricsMutex.Lock()
+		defer metricsMutex.Unlock()
+		o.ObserveInt64(requests, int64(successRequests), metric.WithAttributes(attribute.String("result", "success")))
+		o.ObserveInt64(requests, int64(failedRequests), metric.WithAttributes(attribute.String("result", "failure")))
+		for kind, count := range failureCounts {
+			o.ObserveInt64(failures, int64(count), metric.WithAttributes(attribute.String("kind", kind)))
+		}
+		for code, count := range statusCounts {
+			o.ObserveInt64(responses, int64(count), metric.WithAttributes(attribute.String("code", code)))
+		}
+		o.ObserveInt64(inFlight, int64(busyWorkers))
+		o.ObserveInt64(queued, int64(len(sendQueue)))
+		o.ObserveInt64(bots, int64(activeBots))
+		return nil
+	}, requests, failures, responses, inFlight, queued, bots)
+	return err
+}
+
+// otlpRequest records the latency of a successful request
+func otlpRequest(latency float64) {
+	if otlpLatency != nil {
+		otlpLatency.Record(context.Background(), latency/1000)
+	}
+}
+
+// shutdownOTLPMetrics exports the final metrics
+func shutdownOTLPMetrics() {
+	if meterProvider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := meterProvider.Shutdown(ctx); err != nil {
+		logger.Errorf("Failed to export the final metrics: %v", err)
+	}
+}
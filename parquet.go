@@ -0,0 +1,800 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// This is a minimal Parquet reader covering what Spark and pyarrow write for
+// numeric datasets: INT32, INT64, FLOAT and DOUBLE columns, PLAIN and
+// dictionary encodings, v1 and v2 data pages, and uncompressed, Snappy, gzip
+// or zstd column chunks.
+
+var (
+	// parquetColumn names the column holding the pixels
+	parquetColumn string
+	// parquetLabelColumn names the optional column holding the labels
+	parquetLabelColumn string
+)
+
+// Parquet physical types, codecs, encodings and page types used below
+const (
+	parquetInt32  = 1
+	parquetInt64  = 2
+	parquetFloat  = 4
+	parquetDouble = 5
+
+	parquetUncompressed = 0
+	parquetSnappy       = 1
+	parquetGzip         = 2
+	parquetZstd         = 6
+
+	parquetPlain          = 0
+	parquetPlainDict      = 2
+	parquetRLEDictionary  = 8
+	parquetDataPage       = 0
+	parquetDictionaryPage = 2
+	parquetDataPageV2     = 3
+
+	parquetOptional = 1
+	parquetRepeated = 2
+)
+
+// parquetLeaf is a leaf column of the schema
+type parquetLeaf struct {
+	path     []string
+	physical int32
+	maxDef   int
+	maxRep   int
+}
+
+// parquetChunk is the location of one column chunk in a row group
+type parquetChunk struct {
+	path       []string
+	codec      int32
+	dataOffset int64
+	dictOffset int64
+	size       int64
+	numValues  int64
+}
+
+// parquetColumnData holds the decoded values and levels of a column
+type parquetColumnData struct {
+	values    []float64
+	defLevels []int
+	repLevels []int
+}
+
+// isParquetFile reports whether a file is a Parquet file
+func isParquetFile(filename string) bool {
+	return strings.HasSuffix(strings.ToLower(filename), ".parquet")
+}
+
+// loadParquetDataset loads samples from a Parquet file. The pixels come from
+// a list column (e.g. array<double> or a nested 28x28 list) or, in the wide
+// layout, from every numeric column other than the label column.
+func loadParquetDataset(filename string) ([][]float64, []int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	leaves, rowGroups, err := readParquetFooter(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %v", filename, err)
+	}
+
+	var pixelLeaves []parquetLeaf
+	var labelLeaf *parquetLeaf
+	for i, leaf := range leaves {
+		switch {
+		case leaf.path[0] == parquetLabelColumn:
+			labelLeaf = &leaves[i]
+		case parquetColumn != "" && leaf.path[0] == parquetColumn:
+			pixelLeaves = append(pixelLeaves, leaf)
+		case parquetColumn == "" && leaf.maxRep > 0 && len(pixelLeaves) == 0:
+			pixelLeaves = append(pixelLeaves, leaf)
+		}
+	}
+	if len(pixelLeaves) == 0 && parquetColumn == "" {
+		// Wide layout: one column per pixel
+		for _, leaf := range leaves {
+			if leaf.path[0] != parquetLabelColumn && leaf.maxRep == 0 && isParquetNumeric(leaf.physical) {
+				pixelLeaves = append(pixelLeaves, leaf)
+			}
+		}
+	}
+	if len(pixelLeaves) == 0 {
+		return nil, nil, fmt.Errorf("no pixel column found in %s (use --parquet-column)", filename)
+	}
+	for _, leaf := range pixelLeaves {
+		if !isParquetNumeric(leaf.physical) {
+			return nil, nil, fmt.Errorf("column %s is not numeric", strings.Join(leaf.path, "."))
+		}
+	}
+	if len(pixelLeaves) > 1 && pixelLeaves[0].maxRep > 0 {
+		return nil, nil, fmt.Errorf("column %s has more than one leaf", parquetColumn)
+	}
+
+	var samples [][]float64
+	if pixelLeaves[0].maxRep > 0 {
+		data, err := readParquetColumn(file, rowGroups, pixelLeaves[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		samples = assembleListColumn(data, pixelLeaves[0].maxDef)
+	} else {
+		for i, leaf := range pixelLeaves {
+			data, err := readParquetColumn(file, rowGroups, leaf)
+			if err != nil {
+				return nil, nil, err
+			}
+			column := assembleScalarColumn(data, leaf.maxDef)
+			if i == 0 {
+				samples = make([][]float64, len(column))
+				for row := range samples {
+					samples[row] = make([]float64, len(pixelLeaves))
+				}
+			}
+			for row, value := range column {
+				samples[row][i] = value
+			}
+		}
+	}
+
+	if labelLeaf == nil {
+		return samples, nil, nil
+	}
+	data, err := readParquetColumn(file, rowGroups, *labelLeaf)
+	if err != nil {
+		return nil, nil, err
+	}
+	column := assembleScalarColumn(data, labelLeaf.maxDef)
+	if len(column) != len(samples) {
+		return nil, nil, fmt.Errorf("label column has %d rows for %d samples", len(column), len(samples))
+	}
+	labels := make([]int, len(column))
+	for i, label := range column {
+		labels[i] = int(label)
+	}
+	return samples, labels, nil
+}
+
+// isParquetNumeric reports whether a physical type can be read as numbers
+func isParquetNumeric(physical int32) bool {
+	switch physical {
+	case parquetInt32, parquetInt64, parquetFloat, parquetDouble:
+		return true
+	}
+	return false
+}
+
+// assembleListColumn groups the values of a repeated column into one sample
+// per row. Null and empty entries are skipped.
+func assembleListColumn(data *parquetColumnData, maxDef int) [][]float64 {
+	var samples [][]float64
+	next := 0
+	for i, rep := range data.repLevels {
+		if rep == 0 {
+			samples = append(samples, nil)
+		}
+		if data.defLevels[i] == maxDef {
+			row := len(samples) - 1
+			samples[row] = append(samples[row], data.values[next])
+			next++
+		}
+	}
+	return samples
+}
+
+// assembleScalarColumn returns one value per row, with nulls read as zero
+func assembleScalarColumn(data *parquetColumnData, maxDef int) []float64 {
+	if maxDef == 0 {
+		return data.values
+	}
+	column := make([]float64, len(data.defLevels))
+	next := 0
+	for i, def := range data.defLevels {
+		if def == maxDef {
+			column[i] = data.values[next]
+			next++
+		}
+	}
+	return column
+}
+
+// readParquetFooter parses the file metadata into the schema's leaf columns
+// and the column chunks of every row group
+func readParquetFooter(file *os.File) ([]parquetLeaf, [][]parquetChunk, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	var tail [8]byte
+	if info.Size() < 12 {
+		return nil, nil, fmt.Errorf("not a Parquet file")
+	}
+	if _, err := file.ReadAt(tail[:], info.Size()-8); err != nil {
+		return nil, nil, err
+	}
+	if string(tail[4:]) != "PAR1" {
+		return nil, nil, fmt.Errorf("not a Parquet file")
+	}
+	footerLen := int64(binary.LittleEndian.Uint32(tail[:4]))
+	if footerLen > info.Size()-12 {
+		return nil, nil, fmt.Errorf("invalid footer length %d", footerLen)
+	}
+	footer := make([]byte, footerLen)
+	if _, err := file.ReadAt(footer, info.Size()-8-footerLen); err != nil {
+		return nil, nil, err
+	}
+
+	type schemaElement struct {
+		name        string
+		physical    int32
+		repetition  int32
+		numChildren int32
+	}
+	var schema []schemaElement
+	var rowGroups [][]parquetChunk
+
+	r := &thriftReader{buf: footer}
+	r.readStruct(func(id int16, typ byte) {
+		switch id {
+		case 2:
+			r.readList(func(byte) {
+				var element schemaElement
+				r.readStruct(func(id int16, typ byte) {
+					switch id {
+					case 1:
+						element.physical = int32(r.varint())
+					case 3:
+						element.repetition = int32(r.varint())
+					case 4:
+						element.name = string(r.binary())
+					case 5:
+						element.numChildren = int32(r.varint())
+					default:
+						r.skip(typ)
+					}
+				})
+				schema = append(schema, element)
+			})
+		case 4:
+			r.readList(func(byte) {
+				var chunks []parquetChunk
+				r.readStruct(func(id int16, typ byte) {
+					if id != 1 {
+						r.skip(typ)
+						return
+					}
+					r.readList(func(byte) {
+						chunks = append(chunks, readParquetColumnChunk(r))
+					})
+				})
+				rowGroups = append(rowGroups, chunks)
+			})
+		default:
+			r.skip(typ)
+		}
+	})
+	if r.err != nil {
+		return nil, nil, fmt.Errorf("invalid file metadata: %v", r.err)
+	}
+	if len(schema) == 0 {
+		return nil, nil, fmt.Errorf("file has no schema")
+	}
+
+	// The schema is a depth-first flattening of the tree under the root
+	var leaves []parquetLeaf
+	next := 1
+	var walk func(path []string, def, rep int)
+	walk = func(path []string, def, rep int) {
+		element := schema[next]
+		next++
+		path = append(append([]string(nil), path...), element.name)
+		switch element.repetition {
+		case parquetOptional:
+			def++
+		case parquetRepeated:
+			def++
+			rep++
+		}
+		if element.numChildren == 0 {
+			leaves = append(leaves, parquetLeaf{path: path, physical: element.physical, maxDef: def, maxRep: rep})
+			return
+		}
+		for i := int32(0); i < element.numChildren && next < len(schema); i++ {
+			walk(path, def, rep)
+		}
+	}
+	for i := int32(0); i < schema[0].numChildren && next < len(schema); i++ {
+		walk(nil, 0, 0)
+	}
+	return leaves, rowGroups, nil
+}
+
+// readParquetColumnChunk parses a ColumnChunk and its ColumnMetaData
+func readParquetColumnChunk(r *thriftReader) parquetChunk {
+	chunk := parquetChunk{dictOffset: -1}
+	r.readStruct(func(id int16, typ byte) {
+		if id != 3 {
+			r.skip(typ)
+			return
+		}
+		r.readStruct(func(id int16, typ byte) {
+			switch id {
+			case 3:
+				r.readList(func(byte) {
+					chunk.path = append(chunk.path, string(r.binary()))
+				})
+			case 4:
+				chunk.codec = int32(r.varint())
+			case 5:
+				chunk.numValues = r.varint()
+			case 7:
+				chunk.size = r.varint()
+			case 9:
+				chunk.dataOffset = r.varint()
+			case 11:
+				chunk.dictOffset = r.varint()
+			default:
+				r.skip(typ)
+			}
+		})
+	})
+	return chunk
+}
+
+// readParquetColumn decodes a leaf column across all row groups
+func readParquetColumn(file *os.File, rowGroups [][]parquetChunk, leaf parquetLeaf) (*parquetColumnData, error) {
+	name := strings.Join(leaf.path, ".")
+	data := &parquetColumnData{}
+	for _, chunks := range rowGroups {
+		var chunk *parquetChunk
+		for i := range chunks {
+			if strings.Join(chunks[i].path, ".") == name {
+				chunk = &chunks[i]
+			}
+		}
+		if chunk == nil {
+			return nil, fmt.Errorf("row group has no column %s", name)
+		}
+		if err := readParquetChunk(file, *chunk, leaf, data); err != nil {
+			return nil, fmt.Errorf("failed to read column %s: %v", name, err)
+		}
+	}
+	return data, nil
+}
+
+// readParquetChunk decodes the pages of one column chunk into data
+func readParquetChunk(file *os.File, chunk parquetChunk, leaf parquetLeaf, data *parquetColumnData) error {
+	start := chunk.dataOffset
+	if chunk.dictOffset > 0 && chunk.dictOffset < start {
+		start = chunk.dictOffset
+	}
+	buf := make([]byte, chunk.size)
+	if _, err := file.ReadAt(buf, start); err != nil {
+		return err
+	}
+
+	var dictionary []float64
+	var read int64
+	for read < chunk.numValues {
+		r := &thriftReader{buf: buf}
+		var pageType, uncompressedSize, compressedSize int32
+		var numValues, encoding, defLength, repLength int32
+		compressed := true
+		r.readStruct(func(id int16, typ byte) {
+			switch id {
+			case 1:
+				pageType = int32(r.varint())
+			case 2:
+				uncompressedSize = int32(r.varint())
+			case 3:
+				compressedSize = int32(r.varint())
+			case 5, 7:
+				// DataPageHeader and DictionaryPageHeader start alike
+				r.readStruct(func(id int16, typ byte) {
+					switch id {
+					case 1:
+						numValues = int32(r.varint())
+					case 2:
+						encoding = int32(r.varint())
+					default:
+						r.skip(typ)
+					}
+				})
+			case 8:
+				r.readStruct(func(id int16, typ byte) {
+					switch id {
+					case 1:
+						numValues = int32(r.varint())
+					case 4:
+						encoding = int32(r.varint())
+					case 5:
+						defLength = int32(r.varint())
+					case 6:
+						repLength = int32(r.varint())
+					case 7:
+						compressed = typ == thriftTrue
+					default:
+						r.skip(typ)
+					}
+				})
+			default:
+				r.skip(typ)
+			}
+		})
+		if r.err != nil {
+			return fmt.Errorf("invalid page header: %v", r.err)
+		}
+		if compressedSize < 0 || int(compressedSize) > len(buf)-r.pos {
+			return fmt.Errorf("truncated page")
+		}
+		page := buf[r.pos : r.pos+int(compressedSize)]
+		buf = buf[r.pos+int(compressedSize):]
+
+		switch pageType {
+		case parquetDictionaryPage:
+			body, err := decompressParquet(chunk.codec, page, int(uncompressedSize))
+			if err != nil {
+				return err
+			}
+			if dictionary, err = decodePlain(body, leaf.physical, int(numValues)); err != nil {
+				return err
+			}
+		case parquetDataPage, parquetDataPageV2:
+			var levels []byte
+			if pageType == parquetDataPageV2 {
+				// v2 levels are stored uncompressed in front of the values
+				if int(defLength+repLength) > len(page) {
+					return fmt.Errorf("truncated page")
+				}
+				levels, page = page[:repLength+defLength], page[repLength+defLength:]
+				uncompressedSize -= repLength + defLength
+			}
+			body := page
+			if compressed {
+				var err error
+				if body, err = decompressParquet(chunk.codec, page, int(uncompressedSize)); err != nil {
+					return err
+				}
+			}
+			if pageType == parquetDataPageV2 {
+				body = append(levels, body...)
+			}
+			if err := decodeParquetPage(body, pageType == parquetDataPageV2, int(repLength), int(defLength), int(numValues), encoding, dictionary, leaf, data); err != nil {
+				return err
+			}
+			read += int64(numValues)
+		}
+	}
+	return nil
+}
+
+// decodeParquetPage decodes the levels and values of one data page
+func decodeParquetPage(body []byte, v2 bool, repLength, defLength, numValues int, encoding int32, dictionary []float64, leaf parquetLeaf, data *parquetColumnData) error {
+	readLevels := func(maxLevel, length int) ([]int, error) {
+		if maxLevel == 0 {
+			return nil, nil
+		}
+		if !v2 {
+			if len(body) < 4 {
+				return nil, fmt.Errorf("truncated levels")
+			}
+			length = int(binary.LittleEndian.Uint32(body))
+			body = body[4:]
+		}
+		if length > len(body) {
+			return nil, fmt.Errorf("truncated levels")
+		}
+		levels, err := decodeRLEHybrid(body[:length], bits.Len(uint(maxLevel)), numValues)
+		body = body[length:]
+		return levels, err
+	}
+
+	repLevels, err := readLevels(leaf.maxRep, repLength)
+	if err != nil {
+		return err
+	}
+	defLevels, err := readLevels(leaf.maxDef, defLength)
+	if err != nil {
+		return err
+	}
+
+	present := numValues
+	if defLevels != nil {
+		present = 0
+		for _, def := range defLevels {
+			if def == leaf.maxDef {
+				present++
+			}
+		}
+	}
+
+	var values []float64
+	switch encoding {
+	case parquetPlain:
+		values, err = decodePlain(body, leaf.physical, present)
+	case parquetPlainDict, parquetRLEDictionary:
+		if len(body) == 0 {
+			return fmt.Errorf("missing dictionary indices")
+		}
+		var indices []int
+		indices, err = decodeRLEHybrid(body[1:], int(body[0]), present)
+		values = make([]float64, len(indices))
+		for i, index := range indices {
+			if index >= len(dictionary) {
+				return fmt.Errorf("dictionary index %d out of range", index)
+			}
+			values[i] = dictionary[index]
+		}
+	default:
+		return fmt.Errorf("unsupported encoding %d", encoding)
+	}
+	if err != nil {
+		return err
+	}
+
+	data.values = append(data.values, values...)
+	data.defLevels = append(data.defLevels, defLevels...)
+	data.repLevels = append(data.repLevels, repLevels...)
+	return nil
+}
+
+// decompressParquet decompresses a page with the chunk's codec
+func decompressParquet(codec int32, page []byte, size int) ([]byte, error) {
+	switch codec {
+	case parquetUncompressed:
+		return page, nil
+	case parquetSnappy:
+		return snappy.Decode(make([]byte, size), page)
+	case parquetGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(page))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return io.ReadAll(gz)
+	case parquetZstd:
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+		return decoder.DecodeAll(page, make([]byte, 0, size))
+	}
+	return nil, fmt.Errorf("unsupported compression codec %d", codec)
+}
+
+// decodePlain decodes count PLAIN-encoded numbers
+func decodePlain(body []byte, physical int32, count int) ([]float64, error) {
+	size := 4
+	if physical == parquetInt64 || physical == parquetDouble {
+		size = 8
+	}
+	if count*size > len(body) {
+		return nil, fmt.Errorf("truncated values")
+	}
+	values := make([]float64, count)
+	for i := range values {
+		b := body[i*size:]
+		switch physical {
+		case parquetInt32:
+			values[i] = float64(int32(binary.LittleEndian.Uint32(b)))
+		case parquetInt64:
+			values[i] = float64(int64(binary.LittleEndian.Uint64(b)))
+		case parquetFloat:
+			values[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		case parquetDouble:
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		default:
+			return nil, fmt.Errorf("unsupported physical type %d", physical)
+		}
+	}
+	return values, nil
+}
+
+// decodeRLEHybrid decodes count values of the RLE/bit-packing hybrid
+// encoding used for levels and dictionary indices
+func decodeRLEHybrid(buf []byte, bitWidth, count int) ([]int, error) {
+	values := make([]int, 0, count)
+	for len(values) < count {
+		header, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, fmt.Errorf("truncated RLE data")
+		}
+		buf = buf[n:]
+
+		if header&1 == 0 {
+			// RLE run: one value repeated
+			width := (bitWidth + 7) / 8
+			if width > len(buf) {
+				return nil, fmt.Errorf("truncated RLE data")
+			}
+			value := 0
+			for i := 0; i < width; i++ {
+				value |= int(buf[i]) << (8 * i)
+			}
+			buf = buf[width:]
+			for run := int(header >> 1); run > 0 && len(values) < count; run-- {
+				values = append(values, value)
+			}
+			continue
+		}
+
+		// Bit-packed run: groups of eight values, least significant bit first
+		groups := int(header >> 1)
+		length := groups * bitWidth
+		if length > len(buf) {
+			return nil, fmt.Errorf("truncated bit-packed data")
+		}
+		for i := 0; i < groups*8 && len(values) < count; i++ {
+			value := 0
+			for b := 0; b < bitWidth; b++ {
+				bit := i*bitWidth + b
+				value |= int(buf[bit/8]>>(bit%8)&1) << b
+			}
+			values = append(values, value)
+		}
+		buf = buf[length:]
+	}
+	return values, nil
+}
+
+// Thrift compact protocol types
+const (
+	thriftStop   = 0
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftByte   = 3
+	thriftI16    = 4
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftDouble = 7
+	thriftBinary = 8
+	thriftList   = 9
+	thriftSet    = 10
+	thriftMap    = 11
+	thriftStruct = 12
+)
+
+// thriftReader decodes the Thrift compact protocol used by Parquet metadata.
+// The first error is kept and makes every later read return zero values.
+type thriftReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *thriftReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.pos = len(r.buf)
+}
+
+func (r *thriftReader) byte() byte {
+	if r.pos >= len(r.buf) {
+		r.fail(io.ErrUnexpectedEOF)
+		return 0
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	value, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		r.fail(io.ErrUnexpectedEOF)
+		return 0
+	}
+	r.pos += n
+	return value
+}
+
+// varint reads a zigzag-encoded integer
+func (r *thriftReader) varint() int64 {
+	value := r.uvarint()
+	return int64(value>>1) ^ -int64(value&1)
+}
+
+func (r *thriftReader) binary() []byte {
+	length := int(r.uvarint())
+	if length < 0 || length > len(r.buf)-r.pos {
+		r.fail(io.ErrUnexpectedEOF)
+		return nil
+	}
+	b := r.buf[r.pos : r.pos+length]
+	r.pos += length
+	return b
+}
+
+// readStruct calls field for every field of a struct. field must consume the
+// value, e.g. with skip. Boolean fields carry their value in typ.
+func (r *thriftReader) readStruct(field func(id int16, typ byte)) {
+	var id int16
+	for r.err == nil {
+		header := r.byte()
+		typ := header & 0x0f
+		if typ == thriftStop {
+			return
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(r.varint())
+		}
+		field(id, typ)
+	}
+}
+
+// readList calls elem for every element of a list or set
+func (r *thriftReader) readList(elem func(typ byte)) {
+	header := r.byte()
+	size := int(header >> 4)
+	if size == 15 {
+		size = int(r.uvarint())
+	}
+	typ := header & 0x0f
+	for i := 0; i < size && r.err == nil; i++ {
+		elem(typ)
+	}
+}
+
+// skip consumes a struct field of the given type
+func (r *thriftReader) skip(typ byte) {
+	switch typ {
+	case thriftTrue, thriftFalse:
+	case thriftByte:
+		r.byte()
+	case thriftI16, thriftI32, thriftI64:
+		r.uvarint()
+	case thriftDouble:
+		r.pos += 8
+		if r.pos > len(r.buf) {
+			r.fail(io.ErrUnexpectedEOF)
+		}
+	case thriftBinary:
+		r.binary()
+	case thriftList, thriftSet:
+		r.readList(r.skipElement)
+	case thriftMap:
+		size := int(r.uvarint())
+		if size == 0 {
+			return
+		}
+		types := r.byte()
+		for i := 0; i < size && r.err == nil; i++ {
+			r.skipElement(types >> 4)
+			r.skipElement(types & 0x0f)
+		}
+	case thriftStruct:
+		r.readStruct(func(_ int16, typ byte) { r.skip(typ) })
+	default:
+		r.fail(fmt.Errorf("unknown Thrift type %d", typ))
+	}
+}
+
+// skipElement consumes a list or map element. Unlike struct fields, boolean
+// elements take a byte.
+func (r *thriftReader) skipElement(typ byte) {
+	if typ == thriftTrue || typ == thriftFalse {
+		r.byte()
+		return
+	}
+	r.skip(typ)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// testdata/mnist.parquet is assembled by testdata/gen_parquet.py after the
+// layout of pyarrow's defaults: Snappy-compressed dictionary and v1 data pages
+// and a three-level list column, split into two row groups. Files written by
+// pyarrow and Spark themselves, with testdata/gen_parquet_reference.py, are
+// read from testdata/reference/mnist-*.parquet when present.
+
+func TestLoadParquetDataset(t *testing.T) {
+	parquetColumn, parquetLabelColumn = "", parquetLabelColumnName
+
+	samples, labels, err := loadParquetDataset("testdata/mnist.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkParquetFixture(t, samples, labels)
+}
+
+func TestLoadReferenceParquet(t *testing.T) {
+	parquetColumn, parquetLabelColumn = "", parquetLabelColumnName
+
+	files, _ := filepath.Glob("testdata/reference/mnist-*.parquet")
+	if len(files) == 0 {
+		t.Skip("no reference files, run testdata/gen_parquet_reference.py")
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			samples, labels, err := loadParquetDataset(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkParquetFixture(t, samples, labels)
+		})
+	}
+}
+
+// checkParquetFixture checks the rows the fixture generators write
+func checkParquetFixture(t *testing.T, samples [][]float64, labels []int) {
+	t.Helper()
+	if len(samples) != 3 {
+		t.Fatalf("got %d samples, want 3", len(samples))
+	}
+	for i, sample := range samples {
+		if len(sample) != 784 {
+			t.Fatalf("sample %d has %d pixels, want 784", i, len(sample))
+		}
+		for j, pixel := range sample {
+			if want := float64((31*i+7*j)%256) / 255; pixel != want {
+				t.Fatalf("pixel %d of sample %d is %v, want %v", j, i, pixel, want)
+			}
+		}
+	}
+	if want := []int{7, 2, 1}; !slices.Equal(labels, want) {
+		t.Errorf("got labels %v, want %v", labels, want)
+	}
+}
+
+func TestParquetMissingColumn(t *testing.T) {
+	parquetColumn, parquetLabelColumn = "image", parquetLabelColumnName
+	defer func() { parquetColumn = "" }()
+
+	if _, _, err := loadParquetDataset("testdata/mnist.parquet"); err == nil {
+		t.Error("loading a missing --parquet-column succeeded")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/golang/snappy"
+)
+
+// parquetLabelColumnName is the label column written by writeParquetSamples,
+// the default of --parquet-label-column
+const parquetLabelColumnName = "label"
+
+// parquetRLE is the encoding of the (empty) levels of required columns
+const parquetRLE = 3
+
+// writeParquetSamples writes samples in the wide layout the loader reads: an
+// INT32 "label" column when there are labels, then one DOUBLE column per
+// pixel. Every column is a single Snappy-compressed PLAIN page in one row
+// group.
+func writeParquetSamples(path string, samples [][]float64, labels []int) error {
+	width := 0
+	if len(samples) > 0 {
+		width = len(samples[0])
+	}
+	for i, sample := range samples {
+		if len(sample) != width {
+			return fmt.Errorf("sample %d has %d values, expected %d", i, len(sample), width)
+		}
+	}
+
+	type column struct {
+		name     string
+		physical int32
+		values   []byte
+	}
+	var columns []column
+	if labels != nil {
+		values := make([]byte, 4*len(labels))
+		for i, label := range labels {
+			binary.LittleEndian.PutUint32(values[4*i:], uint32(int32(label)))
+		}
+		columns = append(columns, column{parquetLabelColumnName, parquetInt32, values})
+	}
+	for j := 0; j < width; j++ {
+		values := make([]byte, 8*len(samples))
+		for i, sample := range samples {
+			binary.LittleEndian.PutUint64(values[8*i:], math.Float64bits(sample[j]))
+		}
+		columns = append(columns, column{fmt.Sprintf("pixel%d", j), parquetDouble, values})
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	offset := int64(4)
+	if _, err := file.WriteString("PAR1"); err != nil {
+		return err
+	}
+
+	meta := &thriftWriter{}
+	meta.beginStruct()
+	meta.i32Field(1, 1) // version
+	meta.listField(2, thriftStruct, len(columns)+1)
+	meta.beginStruct()
+	meta.binaryField(4, []byte("schema"))
+	meta.i32Field(5, int32(len(columns)))
+	meta.endStruct()
+	for _, c := range columns {
+		meta.beginStruct()
+		meta.i32Field(1, c.physical)
+		meta.i32Field(3, 0) // REQUIRED
+		meta.binaryField(4, []byte(c.name))
+		meta.endStruct()
+	}
+	meta.i64Field(3, int64(len(samples)))
+
+	// the single row group, written while the pages go out
+	meta.listField(4, thriftStruct, 1)
+	meta.beginStruct()
+	meta.listField(1, thriftStruct, len(columns))
+	var totalSize int64
+	for _, c := range columns {
+		compressed := snappy.Encode(nil, c.values)
+		header := &thriftWriter{}
+		header.beginStruct()
+		header.i32Field(1, parquetDataPage)
+		header.i32Field(2, int32(len(c.values)))
+		header.i32Field(3, int32(len(compressed)))
+		header.structField(5)
+		header.i32Field(1, int32(len(samples)))
+		header.i32Field(2, parquetPlain)
+		header.i32Field(3, parquetRLE)
+		header.i32Field(4, parquetRLE)
+		header.endStruct()
+		header.endStruct()
+
+		if _, err := file.Write(header.buf); err != nil {
+			return err
+		}
+		if _, err := file.Write(compressed); err != nil {
+			return err
+		}
+		chunkSize := int64(len(header.buf) + len(compressed))
+		uncompressedSize := int64(len(header.buf) + len(c.values))
+
+		meta.beginStruct()
+		meta.i64Field(2, offset) // file_offset
+		meta.structField(3)
+		meta.i32Field(1, c.physical)
+		meta.listField(2, thriftI32, 1)
+		meta.varint(parquetPlain)
+		meta.listField(3, thriftBinary, 1)
+		meta.binary([]byte(c.name))
+		meta.i32Field(4, parquetSnappy)
+		meta.i64Field(5, int64(len(samples)))
+		meta.i64Field(6, uncompressedSize)
+		meta.i64Field(7, chunkSize)
+		meta.i64Field(9, offset)
+		meta.endStruct()
+		meta.endStruct()
+
+		offset += chunkSize
+		totalSize += uncompressedSize
+	}
+	meta.i64Field(2, totalSize)
+	meta.i64Field(3, int64(len(samples)))
+	meta.endStruct()
+	meta.binaryField(6, []byte("mnist-bot"))
+	meta.endStruct()
+
+	var tail [4]byte
+	binary.LittleEndian.PutUint32(tail[:], uint32(len(meta.buf)))
+	for _, b := range [][]byte{meta.buf, tail[:], []byte("PAR1")} {
+		if _, err := file.Write(b); err != nil {
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// thriftWriter encodes the Thrift compact protocol used by Parquet metadata.
+// Structs are opened with beginStruct or structField and closed with
+// endStruct; field IDs must increase within a struct.
+type thriftWriter struct {
+	buf     []byte
+	lastIDs []int16
+}
+
+func (w *thriftWriter) beginStruct() {
+	w.lastIDs = append(w.lastIDs, 0)
+}
+
+func (w *thriftWriter) endStruct() {
+	w.buf = append(w.buf, thriftStop)
+	w.lastIDs = w.lastIDs[:len(w.lastIDs)-1]
+}
+
+func (w *thriftWriter) fieldHeader(id int16, typ byte) {
+	last := &w.lastIDs[len(w.lastIDs)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.buf = append(w.buf, byte(delta)<<4|typ)
+	} else {
+		w.buf = append(w.buf, typ)
+		w.varint(int64(id))
+	}
+	*last = id
+}
+
+// varint writes a zigzag-encoded integer
+func (w *thriftWriter) varint(value int64) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(value<<1^value>>63))
+}
+
+func (w *thriftWriter) binary(value []byte) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(value)))
+	w.buf = append(w.buf, value...)
+}
+
+func (w *thriftWriter) i32Field(id int16, value int32) {
+	w.fieldHeader(id, thriftI32)
+	w.varint(int64(value))
+}
+
+func (w *thriftWriter) i64Field(id int16, value int64) {
+	w.fieldHeader(id, thriftI64)
+	w.varint(value)
+}
+
+func (w *thriftWriter) binaryField(id int16, value []byte) {
+	w.fieldHeader(id, thriftBinary)
+	w.binary(value)
+}
+
+// structField opens a nested struct field
+func (w *thriftWriter) structField(id int16) {
+	w.fieldHeader(id, thriftStruct)
+	w.beginStruct()
+}
+
+// listField starts a list field; the caller writes its size elements next
+func (w *thriftWriter) listField(id int16, elem byte, size int) {
+	w.fieldHeader(id, thriftList)
+	if size < 15 {
+		w.buf = append(w.buf, byte(size)<<4|elem)
+		return
+	}
+	w.buf = append(w.buf, 0xf0|elem)
+	w.buf = binary.AppendUvarint(w.buf, uint64(size))
+}
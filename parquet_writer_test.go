@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// testSamples and testLabels are the table written by the tests, and by
+// pyarrow into testdata/reference/samples-pyarrow.parquet with
+// testdata/gen_parquet_reference.py
+var (
+	testSamples = [][]float64{{0, 0.5, 1}, {0.25, 0.75, 0.125}}
+	testLabels  = []int{3, 9}
+)
+
+// writeTestParquet writes the test table to a temporary file
+func writeTestParquet(t *testing.T) string {
+	t.Helper()
+	parquetColumn, parquetLabelColumn = "", parquetLabelColumnName
+	path := filepath.Join(t.TempDir(), "samples.parquet")
+	if err := writeParquetSamples(path, testSamples, testLabels); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// parquetFooter checks a file's magic bytes and reads its footer
+func parquetFooter(t *testing.T, path string) ([]parquetLeaf, [][]parquetChunk) {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	magic := make([]byte, 4)
+	if _, err := file.ReadAt(magic, 0); err != nil || string(magic) != "PAR1" {
+		t.Fatalf("%s starts with %q, want PAR1", path, magic)
+	}
+	leaves, rowGroups, err := readParquetFooter(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaves, rowGroups
+}
+
+func TestWriteParquetSamples(t *testing.T) {
+	samples, labels := testSamples, testLabels
+	path := writeTestParquet(t)
+
+	leaves, rowGroups := parquetFooter(t, path)
+	if len(rowGroups) != 1 || len(rowGroups[0]) != 4 {
+		t.Fatalf("got %d row groups, want one of 4 column chunks", len(rowGroups))
+	}
+	var names []string
+	for _, leaf := range leaves {
+		if leaf.maxDef != 0 || leaf.maxRep != 0 {
+			t.Errorf("column %v is not required", leaf.path)
+		}
+		names = append(names, leaf.path[0])
+	}
+	if want := []string{"label", "pixel0", "pixel1", "pixel2"}; !slices.Equal(names, want) {
+		t.Errorf("got columns %v, want %v", names, want)
+	}
+
+	// The loader reads the file back in the wide layout
+	loaded, loadedLabels, err := loadParquetDataset(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != len(samples) {
+		t.Fatalf("read %d samples, want %d", len(loaded), len(samples))
+	}
+	for i := range samples {
+		if !slices.Equal(loaded[i], samples[i]) {
+			t.Errorf("sample %d is %v, want %v", i, loaded[i], samples[i])
+		}
+	}
+	if !slices.Equal(loadedLabels, labels) {
+		t.Errorf("got labels %v, want %v", loadedLabels, labels)
+	}
+}
+
+// Without the reference file the written files are only checked with our own
+// reader, which shares its idea of the format with the writer
+func TestWriteParquetSamplesMatchesReference(t *testing.T) {
+	const reference = "testdata/reference/samples-pyarrow.parquet"
+	if _, err := os.Stat(reference); err != nil {
+		t.Skip("no reference file, run testdata/gen_parquet_reference.py")
+	}
+	path := writeTestParquet(t)
+
+	leaves, rowGroups := parquetFooter(t, path)
+	wantLeaves, wantRowGroups := parquetFooter(t, reference)
+	if !reflect.DeepEqual(leaves, wantLeaves) {
+		t.Errorf("got schema %+v, pyarrow wrote %+v", leaves, wantLeaves)
+	}
+	if len(rowGroups) != len(wantRowGroups) {
+		t.Fatalf("got %d row groups, pyarrow wrote %d", len(rowGroups), len(wantRowGroups))
+	}
+	for i := range rowGroups {
+		if len(rowGroups[i]) != len(wantRowGroups[i]) {
+			t.Fatalf("row group %d has %d column chunks, pyarrow wrote %d", i, len(rowGroups[i]), len(wantRowGroups[i]))
+		}
+		for j, chunk := range rowGroups[i] {
+			want := wantRowGroups[i][j]
+			if !slices.Equal(chunk.path, want.path) || chunk.codec != want.codec || chunk.numValues != want.numValues {
+				t.Errorf("column chunk %d of row group %d is %v (codec %d, %d values), pyarrow wrote %v (codec %d, %d values)",
+					j, i, chunk.path, chunk.codec, chunk.numValues, want.path, want.codec, want.numValues)
+			}
+		}
+	}
+
+	samples, labels, err := loadParquetDataset(reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(samples, testSamples) || !slices.Equal(labels, testLabels) {
+		t.Errorf("read %v labelled %v from the reference, want %v labelled %v", samples, labels, testSamples, testLabels)
+	}
+}
+
+func TestWriteParquetSamplesRaggedRows(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "samples.parquet")
+	if err := writeParquetSamples(path, [][]float64{{0, 1}, {0}}, nil); err == nil {
+		t.Error("writing samples of different lengths succeeded")
+	}
+}
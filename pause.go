@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// pausedAt is when the bots were paused with p, zero while they run. It is
+// guarded by metricsMutex.
+var pausedAt time.Time
+
+// togglePause pauses the bots, or resumes them when paused
+func togglePause() {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	if pausedAt.IsZero() {
+		pausedAt = time.Now()
+		logToWidget("Paused, press p to resume")
+		return
+	}
+	logToWidget(fmt.Sprintf("Resumed after %s", time.Since(pausedAt).Round(time.Second)))
+	pausedAt = time.Time{}
+}
+
+// isPaused reports whether bots should skip their requests
+func isPaused() bool {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	return !pausedAt.IsZero()
+}
+
+// pauseRows is the metrics row shown while paused. Callers must hold
+// metricsMutex.
+func pauseRows() [][]string {
+	if pausedAt.IsZero() {
+		return nil
+	}
+	return [][]string{{"Paused", fmt.Sprintf("for %s (press p to resume)", time.Since(pausedAt).Round(time.Second))}}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// sendJob is a request waiting for a free worker
+type sendJob struct {
+	target   Target
+	bot      int // index of the bot sending it, -1 for replayed requests
+	endpoint string
+	batch    []labelledSample
+	done     chan struct{} // closed once answered or discarded, if set
+}
+
+var (
+	// sendQueue holds requests waiting for one of the workers
+	sendQueue chan sendJob
+	// workerCount is the size of the pool and busyWorkers how many of its
+	// workers are sending a request
+	workerCount, busyWorkers int
+	// queueOverflows counts requests dropped because every worker was busy
+	// and the queue was full
+	queueOverflows int
+	// pendingJobs counts requests queued or being sent
+	pendingJobs int
+)
+
+// setupPool sizes the worker pool and its queue
+func setupPool(workers, queueSize int) error {
+	if workers <= 0 {
+		return fmt.Errorf("--max-in-flight must be at least 1")
+	}
+	if queueSize < 0 {
+		return fmt.Errorf("--queue-size must not be negative")
+	}
+	workerCount = workers
+	sendQueue = make(chan sendJob, queueSize)
+	return nil
+}
+
+// startWorkers starts a fixed pool of workers sending the queued requests,
+// so a slow server can't pile up goroutines
+func startWorkers(wg *sync.WaitGroup, quit <-chan struct{}) {
+	for i := 0; i < workerCount; i++ {
+		go runWorker(wg, quit)
+	}
+}
+
+// runWorker sends queued requests one at a time. Once the bots are stopping,
+// requests still queued are discarded rather than sent.
+func runWorker(wg *sync.WaitGroup, quit <-chan struct{}) {
+	for job := range sendQueue {
+		select {
+		case <-quit:
+			wg.Done()
+		default:
+			metricsMutex.Lock()
+			busyWorkers++
+			metricsMutex.Unlock()
+			dispatch(job.target, job.bot, job.endpoint, job.batch, wg)
+			metricsMutex.Lock()
+			busyWorkers--
+			metricsMutex.Unlock()
+		}
+		metricsMutex.Lock()
+		pendingJobs--
+		metricsMutex.Unlock()
+		if job.done != nil {
+			close(job.done)
+		}
+	}
+}
+
+// enqueueSend queues a request without waiting, reporting false (and counting
+// an overflow) when the queue is full
+func enqueueSend(job sendJob, wg *sync.WaitGroup) bool {
+	wg.Add(1)
+	metricsMutex.Lock()
+	pendingJobs++
+	metricsMutex.Unlock()
+	select {
+	case sendQueue <- job:
+		return true
+	default:
+		wg.Done()
+		metricsMutex.Lock()
+		pendingJobs--
+		queueOverflows++
+		metricsMutex.Unlock()
+		return false
+	}
+}
+
+// sendAndWait queues a request, waiting for room in the queue, and then for
+// its answer. It reports false if quit is closed meanwhile.
+func sendAndWait(job sendJob, wg *sync.WaitGroup, quit <-chan struct{}) bool {
+	job.done = make(chan struct{})
+	wg.Add(1)
+	metricsMutex.Lock()
+	pendingJobs++
+	metricsMutex.Unlock()
+	select {
+	case sendQueue <- job:
+	case <-quit:
+		wg.Done()
+		metricsMutex.Lock()
+		pendingJobs--
+		metricsMutex.Unlock()
+		return false
+	}
+	select {
+	case <-job.done:
+		return true
+	case <-quit:
+		return false
+	}
+}
+
+// waitForPending blocks until every queued request has been sent and
+// answered, or quit is closed
+func waitForPending(quit <-chan struct{}) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		metricsMutex.Lock()
+		pending := pendingJobs
+		metricsMutex.Unlock()
+		if pending == 0 {
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// poolRows are the metrics rows of the worker pool. Callers must hold
+// metricsMutex.
+func poolRows() [][]string {
+	rows := [][]string{
+		{"In Flight", fmt.Sprintf("%d (max %d)", busyWorkers, workerCount)},
+		{"Queued", fmt.Sprintf("%d (max %d)", len(sendQueue), cap(sendQueue))},
+	}
+	if queueOverflows > 0 {
+		rows = append(rows, []string{"Queue Overflows", fmt.Sprintf("%d", queueOverflows)})
+	}
+	return rows
+}
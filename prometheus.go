@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the buckets of the
+// Prometheus latency histogram
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// serveMetrics serves the metrics in the Prometheus text format on
+// addr/metrics for as long as the process runs
+func serveMetrics(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writePrometheus(w)
+	})
+	go http.Serve(listener, mux)
+	return nil
+}
+
+// writePrometheus writes the metrics in the Prometheus text format
+func writePrometheus(w io.Writer) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	fmt.Fprintln(w, "# HELP mnist_bot_requests_total Requests finished, by result.")
+	fmt.Fprintln(w, "# TYPE mnist_bot_requests_total counter")
+	fmt.Fprintf(w, "mnist_bot_requests_total{result=\"success\"} %d\n", successRequests)
+	fmt.Fprintf(w, "mnist_bot_requests_total{result=\"failure\"} %d\n", failedRequests)
+	writeLabelledCounts(w, "mnist_bot_failures_total", "Failed requests, by kind.", "kind", failureCounts)
+	writeLabelledCounts(w, "mnist_bot_responses_total", "Answers, by status code.", "code", statusCounts)
+
+	fmt.Fprintln(w, "# HELP mnist_bot_request_duration_seconds Latency of successful requests.")
+	fmt.Fprintln(w, "# TYPE mnist_bot_request_duration_seconds histogram")
+	bars := latencyHistogram.Distribution()
+	cumulative, next := int64(0), 0
+	for _, bound := range latencyBuckets {
+		for ; next < len(bars) && float64(bars[next].To)/1e6 <= bound; next++ {
+			cumulative += bars[next].Count
+		}
+		fmt.Fprintf(w, "mnist_bot_request_duration_seconds_bucket{le=\"%g\"} %d\n", bound, cumulative)
+	}
+	fmt.Fprintf(w, "mnist_bot_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", latencyHistogram.TotalCount())
+	fmt.Fprintf(w, "mnist_bot_request_duration_seconds_sum %g\n", latencySum/1000)
+	fmt.Fprintf(w, "mnist_bot_request_duration_seconds_count %d\n", latencyHistogram.TotalCount())
+
+	writeGauge(w, "mnist_bot_in_flight", "Requests being sent.", busyWorkers)
+	writeGauge(w, "mnist_bot_queued", "Requests waiting for a worker.", len(sendQueue))
+	writeGauge(w, "mnist_bot_bots", "Bots running.", activeBots)
+	writeBotPrometheus(w)
+}
+
+// writeLabelledCounts writes a counter with one series per label value
+func writeLabelledCounts(w io.Writer, name, help, label string, counts map[string]int) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	values := make([]string, 0, len(counts))
+	for value := range counts {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, value, counts[value])
+	}
+}
+
+// writeGauge writes a gauge with one series
+func writeGauge(w io.Writer, name, help string, value int) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, value)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// pushgatewayURL, when set, is the Prometheus Pushgateway the metrics
+	// are pushed to every pushInterval and at the end of the run
+	pushgatewayURL string
+	pushInterval   time.Duration
+	// pushRunID labels the pushed metrics, so runs don't overwrite each other
+	pushRunID string
+)
+
+// pushClient sends the pushes, which mustn't hold up the end of a run
+var pushClient = &http.Client{Timeout: 10 * time.Second}
+
+// pushMetrics replaces the metrics of this run on the Pushgateway
+func pushMetrics() error {
+	var body bytes.Buffer
+	writePrometheus(&body)
+	target := fmt.Sprintf("%s/metrics/job/mnist_bot/run_id/%s", strings.TrimSuffix(pushgatewayURL, "/"), url.PathEscape(pushRunID))
+	req, err := http.NewRequest(http.MethodPut, target, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := pushClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Pushgateway answered %s", resp.Status)
+	}
+	return nil
+}
+
+// startPushing pushes the metrics every --push-interval until quit is closed
+func startPushing(quit <-chan struct{}) {
+	if pushInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(pushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := pushMetrics(); err != nil {
+					logToWidget(fmt.Sprintf("Failed to push metrics: %v", err))
+				}
+			case <-quit:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// emptySHA256 is the hex SHA-256 of an empty body, sent with S3 GETs
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// dataCacheDir holds datasets fetched from URLs; empty means a mnist-bot
+// directory in the user's cache directory
+var dataCacheDir string
+
+// isRemoteData reports whether a dataset name is a URL rather than a path
+func isRemoteData(name string) bool {
+	for _, scheme := range []string{"http://", "https://", "s3://", "gs://"} {
+		if strings.HasPrefix(name, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveDataFile returns the local path of a dataset, fetching URLs into the
+// cache first
+func resolveDataFile(name string) (string, error) {
+	if !isRemoteData(name) {
+		return name, nil
+	}
+	return fetchRemoteData(name)
+}
+
+// fetchRemoteData downloads a dataset into the cache and returns its path. A
+// cached copy is revalidated with its ETag and reused when unchanged, or when
+// the server cannot be reached.
+func fetchRemoteData(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid data URL: %v", err)
+	}
+	dir := dataCacheDir
+	if dir == "" {
+		base, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf("no cache directory (use --data-cache): %v", err)
+		}
+		dir = filepath.Join(base, "mnist-bot")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	// the file name is kept so the format can still be detected from it
+	sum := sha256.Sum256([]byte(rawURL))
+	cached := filepath.Join(dir, hex.EncodeToString(sum[:8])+"-"+path.Base("/"+u.Path))
+	etagFile := cached + ".etag"
+	etag := ""
+	if _, err := os.Stat(cached); err == nil {
+		if saved, err := os.ReadFile(etagFile); err == nil {
+			etag = string(saved)
+		}
+	}
+
+	resp, err := getRemoteData(u, etag)
+	if err != nil {
+		if _, statErr := os.Stat(cached); statErr == nil {
+			logger.Warnf("Failed to fetch %s, using cached %s: %v", rawURL, cached, err)
+			return cached, nil
+		}
+		return "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		logger.Infof("Using cached %s", cached)
+		return cached, nil
+	case http.StatusOK:
+	default:
+		return "", fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+
+	logger.Infof("Downloading %s", rawURL)
+	partial := cached + ".part"
+	file, err := os.Create(partial)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(file, resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+	if err := os.Rename(partial, cached); err != nil {
+		return "", err
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		os.WriteFile(etagFile, []byte(etag), 0o644)
+	} else {
+		os.Remove(etagFile)
+	}
+	return cached, nil
+}
+
+// getRemoteData GETs a dataset URL. s3:// objects are fetched with SigV4
+// credentials and gs:// objects with application default credentials, when
+// there are any.
+func getRemoteData(u *url.URL, etag string) (*http.Response, error) {
+	switch u.Scheme {
+	case "s3":
+		return getS3Object(u.Host, strings.TrimPrefix(u.Path, "/"), etag)
+	case "gs":
+		req, err := newDataRequest("https://storage.googleapis.com/"+u.Host+u.Path, etag)
+		if err != nil {
+			return nil, err
+		}
+		if source, err := newADCTokenSource(); err == nil {
+			token, err := source.Token()
+			if err != nil {
+				return nil, fmt.Errorf("error obtaining access token: %v", err)
+			}
+			token.SetAuthHeader(req)
+		}
+		return http.DefaultClient.Do(req)
+	}
+	req, err := newDataRequest(u.String(), etag)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// getS3Object GETs an S3 object from the region of the AWS configuration,
+// following S3 once to the bucket's region if it lives elsewhere
+func getS3Object(bucket, key, etag string) (*http.Response, error) {
+	signer, err := newSigV4Signer("", "s3")
+	if err != nil {
+		return nil, err
+	}
+	// S3 expects the path to be escaped once, not twice as other services
+	signer.signer = v4.NewSigner(func(o *v4.SignerOptions) {
+		o.DisableURIPathEscaping = true
+	})
+
+	for attempt := 0; ; attempt++ {
+		objectURL := url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, signer.region), Path: "/" + key}
+		req, err := newDataRequest(objectURL.String(), etag)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+		if err := signer.sign(req, nil); err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		region := resp.Header.Get("X-Amz-Bucket-Region")
+		if resp.StatusCode != http.StatusMovedPermanently || region == "" || region == signer.region || attempt > 0 {
+			return resp, nil
+		}
+		resp.Body.Close()
+		signer.region = region
+	}
+}
+
+// newDataRequest builds a GET, conditional on the ETag of a cached copy
+func newDataRequest(rawURL, etag string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	return req, nil
+}
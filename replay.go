@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// replayRequest is one request of a --replay file
+type replayRequest struct {
+	offset  time.Duration // since the first request of the recording
+	samples []resultSample
+}
+
+var (
+	// replayRequests, when set, are sent instead of running bots
+	replayRequests []replayRequest
+	// replaySpeed scales the recorded gaps: 2 replays twice as fast
+	replaySpeed float64
+	// replayedRequests and replaySkipped count the requests replayed so far
+	// and those whose samples aren't in the loaded data
+	replayedRequests, replaySkipped int
+)
+
+// loadReplay reads the requests of a --results file in the order they were
+// sent
+func loadReplay(filename string) ([]replayRequest, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []resultEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var entry resultEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if entry.Sent.IsZero() {
+			return nil, fmt.Errorf("line %d has no send time (recorded by an older version?)", line)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no requests in %s", filename)
+	}
+
+	// results are written as requests finish, not as they are sent
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Sent.Before(entries[j].Sent) })
+	requests := make([]replayRequest, len(entries))
+	for i, entry := range entries {
+		requests[i] = replayRequest{offset: entry.Sent.Sub(entries[0].Sent), samples: entry.Samples}
+	}
+	return requests, nil
+}
+
+// replayLength is how long replaying the requests takes
+func replayLength(requests []replayRequest) time.Duration {
+	return time.Duration(float64(requests[len(requests)-1].offset) / replaySpeed)
+}
+
+// sampleFinder looks up loaded samples by their source and position in it
+type sampleFinder map[string]map[int]int
+
+// newSampleFinder indexes the loaded samples
+func newSampleFinder() sampleFinder {
+	finder := sampleFinder{}
+	for _, source := range dataSources {
+		rows := map[int]int{}
+		for position := source.start; position < source.end; position++ {
+			rows[mnistRows[position]] = position
+		}
+		finder[source.name] = rows
+	}
+	return finder
+}
+
+// batch returns the loaded samples a request was sent with
+func (f sampleFinder) batch(samples []resultSample) ([]labelledSample, error) {
+	batch := make([]labelledSample, len(samples))
+	for i, sample := range samples {
+		position, ok := f[sample.Source][sample.Index]
+		if !ok {
+			return nil, fmt.Errorf("sample %d of %s isn't loaded", sample.Index, sample.Source)
+		}
+		batch[i] = loadedSample(position)
+		if augmentations != nil {
+			batch[i] = augmentSample(batch[i], augmentations, rng)
+		}
+	}
+	return batch, nil
+}
+
+// startReplay sends the requests with their recorded gaps, scaled by
+// --replay-speed, through the worker pool. The returned channel is closed
+// once the last one has been answered.
+func startReplay(requests []replayRequest, newTarget newTargetFunc, wg *sync.WaitGroup, quit <-chan struct{}) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		finder := newSampleFinder()
+		targets := map[string]Target{}
+		start := time.Now()
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for _, request := range requests {
+			timer.Reset(time.Until(start.Add(time.Duration(float64(request.offset) / replaySpeed))))
+			select {
+			case <-timer.C:
+			case <-quit:
+				return
+			}
+			if isPaused() {
+				continue
+			}
+
+			batch, err := finder.batch(request.samples)
+			if err != nil {
+				metricsMutex.Lock()
+				replaySkipped++
+				metricsMutex.Unlock()
+				logToWidget(fmt.Sprintf("Skipping request: %v", err))
+				continue
+			}
+			endpoint := routeEndpoint(pickEndpoint())
+			t, ok := targets[endpoint]
+			if !ok {
+				if t, err = newTarget(endpoint); err != nil {
+					logToWidget(fmt.Sprintf("Error setting up %s: %v", endpoint, err))
+					continue
+				}
+				targets[endpoint] = t
+			}
+			if allowRequest(endpoint) {
+				enqueueSend(sendJob{target: t, bot: -1, endpoint: endpoint, batch: batch}, wg)
+			}
+			metricsMutex.Lock()
+			replayedRequests++
+			metricsMutex.Unlock()
+		}
+		waitForPending(quit)
+	}()
+	return done
+}
+
+// replayRows is the metrics row of the replay. Callers must hold
+// metricsMutex.
+func replayRows() [][]string {
+	if replayRequests == nil {
+		return nil
+	}
+	progress := fmt.Sprintf("%d of %d requests", replayedRequests, len(replayRequests))
+	if replaySkipped > 0 {
+		progress += fmt.Sprintf(" (%d skipped)", replaySkipped)
+	}
+	return [][]string{{"Replayed", progress}}
+}
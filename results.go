@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Headers tagging each HTTP request (and gRPC call, as metadata) with its
+// samples, comma-separated for batches
+const (
+	sampleIndexHeader = "X-Sample-Index"
+	sampleLabelHeader = "X-Sample-Label"
+)
+
+var (
+	// resultsFile, when set, gets one JSON line per request
+	resultsFile    *os.File
+	resultsEncoder *json.Encoder
+	resultsMutex   sync.Mutex
+)
+
+// resultEntry is one line of the --results file. Time is when the request
+// finished, Sent when it was sent.
+type resultEntry struct {
+	Time      time.Time      `json:"time"`
+	Sent      time.Time      `json:"sent"`
+	RequestID string         `json:"request_id"`
+	Endpoint  string         `json:"endpoint"`
+	Protocol  string         `json:"protocol,omitempty"`
+	LatencyMs float64        `json:"latency_ms"`
+	Error     string         `json:"error,omitempty"`
+	Samples   []resultSample `json:"samples"`
+}
+
+// resultSample ties a sample of a request back to its input
+type resultSample struct {
+	Source    string `json:"source"`
+	Index     int    `json:"index"`               // -1 for generated samples
+	Label     int    `json:"label"`               // -1 when unlabelled
+	Predicted *int   `json:"predicted,omitempty"` // missing unless the response was parsed
+}
+
+// openResults creates the --results file
+func openResults(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	resultsFile = file
+	resultsEncoder = json.NewEncoder(file)
+	return nil
+}
+
+// closeResults flushes and closes the --results file, if any
+func closeResults() {
+	if resultsFile != nil {
+		resultsFile.Close()
+	}
+}
+
+// saveResult appends the outcome of one request to the --results file
+func saveResult(endpoint string, info requestInfo, batch []labelledSample, result Result, err error) {
+	if resultsFile == nil {
+		return
+	}
+	entry := resultEntry{
+		Time:      time.Now().UTC(),
+		Sent:      info.sent.UTC(),
+		RequestID: info.id,
+		Endpoint:  endpoint,
+		Protocol:  result.Protocol,
+		LatencyMs: result.Latency,
+		Samples:   make([]resultSample, len(batch)),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	for i, sample := range batch {
+		entry.Samples[i] = resultSample{Source: sample.source, Index: sample.index, Label: sample.label}
+		if i < len(result.Predicted) && result.Predicted[i] >= 0 {
+			entry.Samples[i].Predicted = &result.Predicted[i]
+		}
+	}
+
+	resultsMutex.Lock()
+	defer resultsMutex.Unlock()
+	if err := resultsEncoder.Encode(entry); err != nil {
+		logger.Warnf("Failed to save result: %v", err)
+	}
+}
+
+// sampleTags returns the headers tagging a request with the index and label
+// of its samples, leaving out generated indexes and unknown labels
+func sampleTags(info requestInfo) map[string]string {
+	tags := map[string]string{}
+	for _, index := range info.indexes {
+		if index >= 0 {
+			tags[sampleIndexHeader] = joinDigits(info.indexes)
+			break
+		}
+	}
+	for _, label := range info.labels {
+		if label >= 0 {
+			tags[sampleLabelHeader] = joinDigits(info.labels)
+			break
+		}
+	}
+	return tags
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	// maxRetries is how many times a request failing with a transient error
+	// is sent again
+	maxRetries int
+	// retryBackoff is the base of the exponential backoff between retries,
+	// capped at maxRetryBackoff
+	retryBackoff, maxRetryBackoff time.Duration
+	// retriedRequests counts retries sent, retrySuccesses requests that
+	// succeeded on a retry and retryFailures requests still failing after
+	// their last retry
+	retriedRequests, retrySuccesses, retryFailures int
+)
+
+// checkRetryOptions validates the --retry* flags
+func checkRetryOptions() error {
+	if maxRetries < 0 {
+		return fmt.Errorf("--retries must not be negative")
+	}
+	if retryBackoff <= 0 || maxRetryBackoff < retryBackoff {
+		return fmt.Errorf("--retry-backoff must be positive and at most --retry-max-backoff")
+	}
+	return nil
+}
+
+// isTransient reports whether a request failed in a way worth retrying: the
+// connection was reset, or the server was overloaded (429, gRPC
+// RESOURCE_EXHAUSTED) or unavailable (503, gRPC UNAVAILABLE)
+func isTransient(err error) bool {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code == http.StatusTooManyRequests || statusErr.code == http.StatusServiceUnavailable
+	}
+	if st := grpcStatus(err); st != nil {
+		return st.Code() == codes.Unavailable || st.Code() == codes.ResourceExhausted
+	}
+	return errors.Is(err, syscall.ECONNRESET)
+}
+
+// retryDelay is the "full jitter" backoff before a retry: a random time up to
+// --retry-backoff doubled for every earlier attempt, capped at
+// --retry-max-backoff
+func retryDelay(attempt int) time.Duration {
+	limit := maxRetryBackoff
+	if attempt < 32 && retryBackoff<<(attempt-1) < limit {
+		limit = retryBackoff << (attempt - 1)
+	}
+	return time.Duration(rng.Int63n(int64(limit) + 1))
+}
+
+// sendWithRetries sends a batch, retrying transient failures with backoff for
+// as long as ctx allows
+func sendWithRetries(ctx context.Context, t Target, batch [][]float64) (Result, error) {
+	result, err := t.Send(ctx, batch)
+	attempt := 0
+	for ; err != nil && attempt < maxRetries && isTransient(err); attempt++ {
+		select {
+		case <-time.After(retryDelay(attempt + 1)):
+		case <-ctx.Done():
+			return result, err
+		}
+		metricsMutex.Lock()
+		retriedRequests++
+		metricsMutex.Unlock()
+		result, err = t.Send(ctx, batch)
+	}
+	if attempt > 0 {
+		metricsMutex.Lock()
+		if err == nil {
+			retrySuccesses++
+		} else {
+			retryFailures++
+		}
+		metricsMutex.Unlock()
+	}
+	return result, err
+}
+
+// retryRows are the metrics rows of the retries. Callers must hold
+// metricsMutex.
+func retryRows() [][]string {
+	if maxRetries == 0 {
+		return nil
+	}
+	return [][]string{
+		{"Retries", fmt.Sprintf("%d", retriedRequests)},
+		{"Succeeded on Retry", fmt.Sprintf("%d", retrySuccesses)},
+		{"Failed after Retries", fmt.Sprintf("%d", retryFailures)},
+	}
+}
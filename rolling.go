@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// rollingSeconds is how far back the rolling windows reach
+const rollingSeconds = 300
+
+// rollingWindows are the recent windows shown next to the run's totals
+var rollingWindows = []struct {
+	name    string
+	seconds int64
+}{{"Last 10s", 10}, {"Last 1m", 60}, {"Last 5m", 300}}
+
+// secondStats are the results of the requests finishing within one second
+type secondStats struct {
+	second          int64 // Unix time
+	success, failed int
+	latencySum      float64 // of the successes, in ms
+}
+
+// recentSeconds holds the last rollingSeconds seconds, indexed by Unix time
+// modulo their number
+var recentSeconds [rollingSeconds]secondStats
+
+// recordRolling counts a result towards the current second. Callers must
+// hold metricsMutex.
+func recordRolling(success bool, latency float64) {
+	now := time.Now().Unix()
+	stats := &recentSeconds[now%rollingSeconds]
+	if stats.second != now {
+		*stats = secondStats{second: now}
+	}
+	if success {
+		stats.success++
+		stats.latencySum += latency
+	} else {
+		stats.failed++
+	}
+}
+
+// recentRate is the rate requests finished at over the last full seconds
+// before end, or since the run started if that's sooner. Callers must hold
+// metricsMutex.
+func recentRate(seconds int64, end time.Time) float64 {
+	now := end.Unix()
+	seconds = min(seconds, now-runStarted.Unix())
+	if seconds <= 0 {
+		return 0
+	}
+	finished := 0
+	for _, stats := range recentSeconds {
+		if stats.second >= now-seconds && stats.second < now {
+			finished += stats.success + stats.failed
+		}
+	}
+	return float64(finished) / float64(seconds)
+}
+
+// rollingRows are the metrics rows of the recent windows. Callers must hold
+// metricsMutex.
+func rollingRows() [][]string {
+	if successRequests+failedRequests == 0 {
+		return nil
+	}
+	now := time.Now().Unix()
+	var rows [][]string
+	for _, window := range rollingWindows {
+		var total secondStats
+		for _, stats := range recentSeconds {
+			if stats.second > now-window.seconds {
+				total.success += stats.success
+				total.failed += stats.failed
+				total.latencySum += stats.latencySum
+			}
+		}
+		value := "no requests"
+		if finished := total.success + total.failed; finished > 0 {
+			value = fmt.Sprintf("%d requests, %.2f%% errors", finished, 100*float64(total.failed)/float64(finished))
+			if total.success > 0 {
+				value += fmt.Sprintf(", %.2f ms", total.latencySum/float64(total.success))
+			}
+		}
+		rows = append(rows, []string{window.name, value})
+	}
+	return rows
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// scenarioFile is a --scenario file: phases run one after the other
+type scenarioFile struct {
+	Phases []scenarioPhase `yaml:"phases"`
+}
+
+// scenarioPhase is one phase of a --scenario file. Fields left out keep the
+// command line's settings.
+type scenarioPhase struct {
+	Name      string        `yaml:"name"`
+	Rate      float64       `yaml:"rate"`
+	Duration  time.Duration `yaml:"duration"`
+	Data      string        `yaml:"data"`
+	BatchSize string        `yaml:"batch-size"`
+	Augment   string        `yaml:"augment"`
+}
+
+// phaseSettings are the settings a phase overrides
+type phaseSettings struct {
+	batchSizes    *batchDistribution
+	augmentations []augmentation
+	augment       bool   // augmentations replaces --augment, even when empty
+	data          string // dataset of the phase, empty for --data
+	source        int    // index in dataSources of data, -1 for --data
+}
+
+var (
+	// scenarioPhases holds the settings of each stage of a --scenario
+	scenarioPhases []phaseSettings
+	// activePhase is the phase being run, nil outside of scenarios
+	activePhase atomic.Pointer[phaseSettings]
+)
+
+// loadScenario reads a --scenario file into stages and the settings of their
+// phases
+func loadScenario(filename string) ([]loadStage, []phaseSettings, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	var file scenarioFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, nil, err
+	}
+	if len(file.Phases) == 0 {
+		return nil, nil, fmt.Errorf("no phases in %s", filename)
+	}
+
+	var stages []loadStage
+	var phases []phaseSettings
+	for i, phase := range file.Phases {
+		name := phase.Name
+		if name == "" {
+			name = fmt.Sprintf("phase %d", i+1)
+		}
+		if phase.Rate < 0 || phase.Duration <= 0 {
+			return nil, nil, fmt.Errorf("%s needs a rate of at least 0 and a positive duration", name)
+		}
+		stages = append(stages, loadStage{name: phase.Name, rate: phase.Rate, duration: phase.Duration})
+
+		settings := phaseSettings{data: phase.Data, source: -1}
+		if phase.BatchSize != "" {
+			sizes, err := parseBatchSizes(phase.BatchSize)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: invalid batch-size: %v", name, err)
+			}
+			settings.batchSizes = &sizes
+		}
+		if phase.Augment != "" {
+			settings.augment = true
+			if phase.Augment != "none" {
+				if settings.augmentations, err = parseAugmentations(phase.Augment); err != nil {
+					return nil, nil, fmt.Errorf("%s: invalid augment: %v", name, err)
+				}
+			}
+		}
+		phases = append(phases, settings)
+	}
+	return stages, phases, nil
+}
+
+// addPhaseSources points the phases with their own data at its source,
+// adding the datasets that aren't among the --data sources. Only the phases
+// naming them draw from added sources.
+func addPhaseSources(sources []dataSource, phases []phaseSettings) []dataSource {
+	for i := range phases {
+		phase := &phases[i]
+		if phase.data == "" {
+			continue
+		}
+		for j, source := range sources {
+			if source.name == phase.data {
+				phase.source = j
+			}
+		}
+		if phase.source < 0 {
+			phase.source = len(sources)
+			sources = append(sources, dataSource{name: phase.data, file: phase.data, weight: 1, phaseOnly: true})
+		}
+	}
+	return sources
+}
+
+// phasesNeedData reports whether a phase has data of its own
+func phasesNeedData(phases []phaseSettings) bool {
+	for _, phase := range phases {
+		if phase.data != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// activatePhase applies the settings of a scenario's phase
+func activatePhase(index int) {
+	if scenarioPhases != nil {
+		activePhase.Store(&scenarioPhases[index])
+	}
+}
+
+// currentBatchSizes is the batch size distribution of the running phase
+func currentBatchSizes() batchDistribution {
+	if phase := activePhase.Load(); phase != nil && phase.batchSizes != nil {
+		return *phase.batchSizes
+	}
+	return batchSizes
+}
+
+// currentAugmentations are the augmentations of the running phase
+func currentAugmentations() []augmentation {
+	if phase := activePhase.Load(); phase != nil && phase.augment {
+		return phase.augmentations
+	}
+	return augmentations
+}
+
+// currentSource is the data source the running phase draws from, -1 for the
+// --data mix
+func currentSource() int {
+	if phase := activePhase.Load(); phase != nil {
+		return phase.source
+	}
+	return -1
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	// randomSeed seeds rng: --seed, or one picked from the clock
+	randomSeed int64
+	// rng draws what isn't drawn by a bot, such as poisson gaps, retry
+	// jitter and the augmentations of --replay, so runs with the same --seed
+	// repeat them
+	rng = rand.New(&lockedSource{source: rand.NewSource(time.Now().UnixNano()).(rand.Source64)})
+)
+
+// lockedSource lets the bots share one random source
+type lockedSource struct {
+	mu     sync.Mutex
+	source rand.Source64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.source.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.source.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.source.Seed(seed)
+}
+
+// seedRandom seeds rng with --seed, or with a seed picked from the clock when
+// it wasn't given, which is logged so the run can be repeated
+func seedRandom(seed int64, given bool) {
+	if !given {
+		seed = time.Now().UnixNano()
+		logToWidget(fmt.Sprintf("Random seed: %d (pass --seed %d to repeat this run)", seed, seed))
+	}
+	randomSeed = seed
+	rng.Seed(seed)
+}
+
+// botRandom returns the random source of a bot, which draws its samples,
+// augmentations, batch sizes and think times. Its seed is derived from the
+// run's, so each bot repeats its choices under the same --seed without
+// contending with the others for rng.
+func botRandom(index int) *rand.Rand {
+	return rand.New(rand.NewSource(int64(uint64(randomSeed) + uint64(index+1)*0x9e3779b97f4a7c15)))
+}
+
+// seedRows is the metrics row of the random seed
+func seedRows() [][]string {
+	return [][]string{{"Seed", fmt.Sprintf("%d", randomSeed)}}
+}
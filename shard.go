@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+var (
+	// shardMode splits the loaded samples between bots: none, round-robin or
+	// contiguous
+	shardMode string
+	// samplingStrategy orders the samples picked from a pool: random,
+	// sequential, shuffled-epoch or balanced
+	samplingStrategy string
+)
+
+// checkShardMode validates --shard for the number of samples and bots
+func checkShardMode(samples, bots int) error {
+	switch shardMode {
+	case "none":
+		return nil
+	case "round-robin", "contiguous":
+		if samples < bots {
+			return fmt.Errorf("cannot split %d samples between %d bots", samples, bots)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown --shard mode %q (expected none, round-robin or contiguous)", shardMode)
+}
+
+// checkSamplingStrategy validates --sampling, defaulting to sequential order
+// for sharded data and random picks otherwise
+func checkSamplingStrategy() error {
+	switch samplingStrategy {
+	case "":
+		samplingStrategy = "random"
+		if shardMode != "none" {
+			samplingStrategy = "sequential"
+		}
+		return nil
+	case "random", "sequential", "shuffled-epoch":
+		return nil
+	case "balanced":
+		if mnistLabels == nil {
+			return fmt.Errorf("--sampling balanced needs labelled data")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown --sampling strategy %q (expected random, sequential, shuffled-epoch or balanced)", samplingStrategy)
+}
+
+// sampler picks samples from a pool of the loaded samples in the order of
+// --sampling, or from the samplers of several --data sources by weight. It is
+// shared by all bots without --shard.
+type sampler struct {
+	mu      sync.Mutex
+	indexes []int   // the pool
+	order   []int   // positions in the pool in the current epoch's order
+	next    int     // position of the next sample, for the ordered strategies
+	classes [][]int // positions in the pool by label, for balanced sampling
+
+	mix        []*sampler // one per source when mixing
+	mixWeights []float64  // cumulative
+}
+
+// newSamplers returns the sampler of each bot. With --shard every bot gets
+// its own part of each source's samples: every bots-th sample (round-robin)
+// or a consecutive range (contiguous); otherwise all bots share one sampler,
+// so the ordered strategies cover the samples once per pass between them.
+func newSamplers(bots int) []*sampler {
+	samplers := make([]*sampler, bots)
+	if syntheticPatterns != nil || dataStream != nil {
+		shared := &sampler{}
+		for bot := range samplers {
+			samplers[bot] = shared
+		}
+		return samplers
+	}
+
+	for bot := range samplers {
+		if shardMode == "none" && bot > 0 {
+			samplers[bot] = samplers[0]
+			continue
+		}
+		parts := make([]*sampler, len(dataSources))
+		for i, source := range dataSources {
+			parts[i] = &sampler{indexes: shardIndexes(source.start, source.end, bot, bots)}
+		}
+		samplers[bot] = mixSamplers(parts)
+	}
+	return samplers
+}
+
+// shardIndexes returns a bot's part of the samples from start to end
+func shardIndexes(start, end, bot, bots int) []int {
+	var indexes []int
+	n := end - start
+	switch shardMode {
+	case "round-robin":
+		for index := start + bot; index < end; index += bots {
+			indexes = append(indexes, index)
+		}
+	case "contiguous":
+		for index := start + bot*n/bots; index < start+(bot+1)*n/bots; index++ {
+			indexes = append(indexes, index)
+		}
+	default:
+		for index := start; index < end; index++ {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+// size is the number of samples in the pool
+func (s *sampler) size() int {
+	return len(s.indexes)
+}
+
+// index returns the sample at a position in the pool
+func (s *sampler) index(position int) int {
+	return s.indexes[position]
+}
+
+// sample returns the next sample of the pool: a random one, the next one in
+// order, the next one of a fresh shuffle of the pool every epoch, or a random
+// one of a random class. Streamed samples are shared by all bots in file
+// order, and generated samples are fabricated on demand. Random choices are
+// drawn from random.
+func (s *sampler) sample(random *rand.Rand) (labelledSample, error) {
+	if syntheticPatterns != nil {
+		return syntheticSample(random), nil
+	}
+	if dataStream != nil {
+		return dataStream.next(random)
+	}
+	if s.mix != nil {
+		return s.pickPart(random).sample(random)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var position int
+	switch samplingStrategy {
+	case "sequential":
+		position = s.next
+		s.next = (s.next + 1) % s.size()
+	case "shuffled-epoch":
+		if s.next == 0 {
+			s.order = random.Perm(s.size())
+		}
+		position = s.order[s.next]
+		s.next = (s.next + 1) % s.size()
+	case "balanced":
+		if s.classes == nil {
+			s.classes = groupByClass(s.size(), s.index)
+		}
+		class := s.classes[random.Intn(len(s.classes))]
+		position = class[random.Intn(len(class))]
+	default:
+		position = random.Intn(s.size())
+	}
+	return loadedSample(s.index(position)), nil
+}
+
+// batch draws the samples of one request, augmented with --augment
+func (s *sampler) batch(size int, random *rand.Rand) ([]labelledSample, error) {
+	batch := make([]labelledSample, 0, size)
+	for len(batch) < size {
+		sample, err := s.sample(random)
+		if err != nil {
+			return nil, err
+		}
+		if steps := currentAugmentations(); steps != nil {
+			sample = augmentSample(sample, steps, random)
+		}
+		batch = append(batch, sample)
+	}
+	return batch, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loadStage is one step of a --stages profile
+type loadStage struct {
+	name     string // of a --scenario phase, if given
+	rate     float64
+	duration time.Duration
+}
+
+var (
+	// loadStages, when set, replace --rps with a rate per stage; the run ends
+	// after the last one
+	loadStages []loadStage
+	// currentStage is the index of the stage being run
+	currentStage int
+	// stageMetrics counts the results of each stage like endpointStats does
+	// for endpoints
+	stageMetrics []endpointStats
+)
+
+// parseStages parses --stages: "RATE:DURATION" stages separated by commas
+// ("10:2m,50:2m,100:5m"), or a file with one "RATE DURATION" stage per line
+func parseStages(spec string) ([]loadStage, error) {
+	fields := strings.Split(spec, ",")
+	if info, err := os.Stat(spec); err == nil && !info.IsDir() {
+		file, err := os.Open(spec)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		fields = nil
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			fields = append(fields, strings.Join(strings.Fields(line), ":"))
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var stages []loadStage
+	for _, field := range fields {
+		rateText, durationText, ok := strings.Cut(strings.TrimSpace(field), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid stage %q (expected RATE:DURATION)", field)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSuffix(rateText, "rps"), 64)
+		if err != nil || rate < 0 {
+			return nil, fmt.Errorf("invalid rate %q in stage %q", rateText, field)
+		}
+		duration, err := time.ParseDuration(durationText)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid duration %q in stage %q", durationText, field)
+		}
+		stages = append(stages, loadStage{rate: rate, duration: duration})
+	}
+	if len(stages) == 0 {
+		return nil, fmt.Errorf("no stages given")
+	}
+	return stages, nil
+}
+
+// stagesLength is the total duration of the stages
+func stagesLength(stages []loadStage) time.Duration {
+	var total time.Duration
+	for _, stage := range stages {
+		total += stage.duration
+	}
+	return total
+}
+
+// stageAt returns the index of the stage running elapsed into the run, or
+// the last one after the end
+func stageAt(stages []loadStage, elapsed time.Duration) int {
+	for i, stage := range stages {
+		if elapsed < stage.duration {
+			return i
+		}
+		elapsed -= stage.duration
+	}
+	return len(stages) - 1
+}
+
+// stageRate is the rate profile of the stages. It moves currentStage along
+// and logs every new stage.
+func stageRate(elapsed time.Duration) float64 {
+	index := stageAt(loadStages, elapsed)
+	stage := loadStages[index]
+	metricsMutex.Lock()
+	changed := index != currentStage
+	currentStage = index
+	targetRPS = stage.rate
+	metricsMutex.Unlock()
+	if changed {
+		activatePhase(index)
+		logToWidget(fmt.Sprintf("%s: %.1f rps for %s", stageTitle(index), stage.rate, stage.duration))
+	}
+	return stage.rate
+}
+
+// stageTitle names a stage in the log, with the phase name if it has one
+func stageTitle(index int) string {
+	title := fmt.Sprintf("Stage %d/%d", index+1, len(loadStages))
+	if name := loadStages[index].name; name != "" {
+		title += " (" + name + ")"
+	}
+	return title
+}
+
+// recordStage counts a result towards the running stage. Callers must hold
+// metricsMutex.
+func recordStage(success bool, latency float64) {
+	if loadStages == nil {
+		return
+	}
+	stats := &stageMetrics[currentStage]
+	if success {
+		stats.success++
+		stats.latencySum += latency
+	} else {
+		stats.failed++
+	}
+}
+
+// stageRows renders one metrics row per stage started so far. Callers must
+// hold metricsMutex.
+func stageRows() [][]string {
+	var rows [][]string
+	for i := 0; i <= currentStage && i < len(loadStages); i++ {
+		stats := stageMetrics[i]
+		average := 0.0
+		if stats.success > 0 {
+			average = stats.latencySum / float64(stats.success)
+		}
+		name := fmt.Sprintf("Stage %d (%.0f rps)", i+1, loadStages[i].rate)
+		if loadStages[i].name != "" {
+			name = fmt.Sprintf("%s (%.0f rps)", loadStages[i].name, loadStages[i].rate)
+		}
+		if i == currentStage {
+			name += " *"
+		}
+		rows = append(rows, []string{name, fmt.Sprintf("ok %d / failed %d, avg %.2f ms", stats.success, stats.failed, average)})
+	}
+	return rows
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// statsdGaugeInterval is how often the gauges are sent to StatsD
+const statsdGaugeInterval = 10 * time.Second
+
+var (
+	// statsdConn, when set, receives a StatsD metric for every finished
+	// request and the gauges every statsdGaugeInterval
+	statsdConn net.Conn
+	// statsdPrefix starts every metric name
+	statsdPrefix string
+	// statsdTags are the DogStatsD tags added to every metric, as sent
+	statsdTags string
+)
+
+// setupStatsD connects to the StatsD agent at addr. tags is a
+// comma-separated list of DogStatsD tags (env:perf,team:ml).
+func setupStatsD(addr, prefix, tags string) error {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+	statsdConn, statsdPrefix = conn, prefix
+	if tags != "" {
+		statsdTags = "|#" + strings.ReplaceAll(tags, " ", "")
+	}
+	return nil
+}
+
+// sendStatsD sends one metric with extra tags (key:value), if StatsD is set
+// up. Delivery isn't checked, as is usual over UDP.
+func sendStatsD(name, value, kind string, tags ...string) {
+	if statsdConn == nil {
+		return
+	}
+	all := statsdTags
+	if len(tags) > 0 {
+		if all == "" {
+			all = "|#"
+		} else {
+			all += ","
+		}
+		all += strings.Join(tags, ",")
+	}
+	fmt.Fprintf(statsdConn, "%s%s:%s|%s%s", statsdPrefix, name, value, kind, all)
+}
+
+// statsdRequest sends the metrics of a finished request: a timing for
+// successes, a counter by result and failure kind
+func statsdRequest(latency float64, failure string) {
+	if failure != "" {
+		sendStatsD("requests", "1", "c", "result:failure", "kind:"+strings.ReplaceAll(failure, " ", "_"))
+		return
+	}
+	sendStatsD("requests", "1", "c", "result:success")
+	sendStatsD("latency", fmt.Sprintf("%.3f", latency), "ms")
+}
+
+// startStatsDGauges sends the gauges every statsdGaugeInterval until quit is
+// closed
+func startStatsDGauges(quit <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(statsdGaugeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				metricsMutex.Lock()
+				inFlight, queued, bots := busyWorkers, len(sendQueue), activeBots
+				metricsMutex.Unlock()
+				sendStatsD("in_flight", fmt.Sprintf("%d", inFlight), "g")
+				sendStatsD("queued", fmt.Sprintf("%d", queued), "g")
+				sendStatsD("bots", fmt.Sprintf("%d", bots), "g")
+			case <-quit:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+)
+
+// stdinData is the --data value reading samples from standard input
+const stdinData = "-"
+
+// errStdinClosed is returned once standard input has no more samples
+var errStdinClosed = errors.New("stdin closed, no more samples")
+
+// sampleStream reads samples lazily from a CSV, JSON or JSONL file, starting
+// over at the end of the file, or from standard input until it is closed.
+// With a ring buffer the last records read are kept in memory and samples are
+// picked at random from them; without one samples are sent in file order.
+// Labels come from the first CSV column or are read from the --labels file
+// in step with the samples.
+type sampleStream struct {
+	mu       sync.Mutex
+	filename string
+	name     string // filename, or "stdin"
+	format   string
+	file     *os.File
+	gz       *gzip.Reader
+	csv      *csv.Reader
+	first    []string // first CSV record, read while looking for a header
+	json     *json.Decoder
+	labelled bool
+	labels   *os.File
+	scanner  *bufio.Scanner
+	index    int // position of the next record in the file
+	valid    int // samples that passed validation so far
+	ring     []labelledSample
+	ringNext int
+	ringFull bool
+	scale    string        // "raw" or "scaled" once the input scale is known
+	ended    chan struct{} // closed once stdin ends with no samples to resend
+	endOnce  sync.Once
+}
+
+// dataStream, when set, replaces the in-memory samples loaded by loadMNISTData
+var dataStream *sampleStream
+
+// openSampleStream opens a CSV, JSON or JSONL file (optionally gzip-compressed)
+// or, for "-", standard input for streaming, keeping up to bufferSize samples
+// in memory
+func openSampleStream(filename string, bufferSize int) (*sampleStream, error) {
+	s := &sampleStream{filename: filename, name: filename}
+	if filename == stdinData {
+		// the format is sniffed from the data once it arrives
+		s.name = "stdin"
+		s.ended = make(chan struct{})
+	} else if s.format = sampleFormat(filename); s.format == "csv" && !strings.HasSuffix(strings.TrimSuffix(filename, ".gz"), ".csv") {
+		return nil, fmt.Errorf("streaming supports CSV, JSON and JSONL files, not %s", filename)
+	}
+	if bufferSize > 0 {
+		s.ring = make([]labelledSample, bufferSize)
+	}
+	if err := s.rewind(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// rewind (re)opens the files and positions the readers at the first sample
+func (s *sampleStream) rewind() error {
+	s.close()
+	s.index = 0
+
+	if labelsFile != "" {
+		labels, err := os.Open(labelsFile)
+		if err != nil {
+			return fmt.Errorf("failed to open labels file: %v", err)
+		}
+		s.labels = labels
+		s.scanner = newLabelScanner(labels)
+	}
+
+	reader, err := s.openData()
+	if err != nil {
+		return err
+	}
+	switch s.format {
+	case "csv":
+		s.csv = csv.NewReader(reader)
+		s.csv.FieldsPerRecord = -1
+		s.labelled = csvLabelColumn
+		record, err := s.csv.Read()
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read CSV: %v", err)
+		}
+		if err == nil && isCSVHeader(record) {
+			s.labelled = s.labelled || strings.EqualFold(strings.TrimSpace(record[0]), "label")
+		} else {
+			// no header row: the first record is a sample
+			s.first = record
+		}
+		s.csv.ReuseRecord = true
+	case "jsonl":
+		s.json = json.NewDecoder(reader)
+	case "json":
+		s.json = json.NewDecoder(reader)
+		if token, err := s.json.Token(); err != nil || token != json.Delim('[') {
+			return fmt.Errorf("%s is not a JSON array of samples", s.name)
+		}
+	}
+	return nil
+}
+
+// sniffFormat tells CSV, JSON and JSONL data apart from its first bytes: a
+// JSON array of samples starts with two brackets, JSONL with one
+func sniffFormat(reader *bufio.Reader) (string, error) {
+	brackets := 0
+	for n := 1; ; n++ {
+		peeked, err := reader.Peek(n)
+		if len(peeked) < n {
+			if err == io.EOF && brackets == 0 {
+				return "csv", nil
+			}
+			return "", fmt.Errorf("failed to read data: %v", err)
+		}
+		switch c := peeked[n-1]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+		case c == '[' && brackets == 0:
+			brackets++
+		case c == '[':
+			return "json", nil
+		case brackets == 1:
+			return "jsonl", nil
+		default:
+			return "csv", nil
+		}
+	}
+}
+
+// openData opens the data file, replacing any reader opened before.
+// Standard input is decompressed when it starts with the gzip magic number.
+func (s *sampleStream) openData() (io.Reader, error) {
+	s.closeData()
+
+	if s.filename == stdinData {
+		reader := bufio.NewReader(os.Stdin)
+		if magic, _ := reader.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+			gz, err := gzip.NewReader(reader)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decompress stdin: %v", err)
+			}
+			s.gz = gz
+			reader = bufio.NewReader(gz)
+		}
+		format, err := sniffFormat(reader)
+		if err != nil {
+			return nil, err
+		}
+		s.format = format
+		return reader, nil
+	}
+
+	file, err := os.Open(s.filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	s.file = file
+
+	var reader io.Reader = bufio.NewReader(file)
+	if strings.HasSuffix(s.filename, ".gz") {
+		if s.gz, err = gzip.NewReader(reader); err != nil {
+			return nil, fmt.Errorf("failed to decompress file: %v", err)
+		}
+		reader = s.gz
+	}
+	return reader, nil
+}
+
+// closeData closes the data file
+func (s *sampleStream) closeData() {
+	if s.gz != nil {
+		s.gz.Close()
+		s.gz = nil
+	}
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+}
+
+// close closes the data and labels files
+func (s *sampleStream) close() {
+	s.closeData()
+	if s.labels != nil {
+		s.labels.Close()
+		s.labels = nil
+	}
+}
+
+// next returns the next sample to send
+func (s *sampleStream) next(random *rand.Rand) (labelledSample, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sample, err := s.nextValid()
+	if err == errStdinClosed && (s.ringFull || s.ringNext > 0) {
+		// keep sending the last samples the generator wrote
+		return s.pick(random), nil
+	}
+	if err == errStdinClosed {
+		// nothing left to send, so the run ends
+		s.endOnce.Do(func() { close(s.ended) })
+	}
+	if err != nil {
+		return labelledSample{}, err
+	}
+
+	if s.ring == nil {
+		return sample, nil
+	}
+	s.ring[s.ringNext] = sample
+	s.ringNext = (s.ringNext + 1) % len(s.ring)
+	if s.ringNext == 0 {
+		s.ringFull = true
+	}
+	return s.pick(random), nil
+}
+
+// inputEnded is closed once standard input has ended and, without a ring
+// buffer holding samples, there is nothing more to send. It is nil for files,
+// which start over at the end.
+func (s *sampleStream) inputEnded() <-chan struct{} {
+	return s.ended
+}
+
+// pick returns a random sample from the ring buffer
+func (s *sampleStream) pick(random *rand.Rand) labelledSample {
+	filled := s.ringNext
+	if s.ringFull {
+		filled = len(s.ring)
+	}
+	return s.ring[random.Intn(filled)]
+}
+
+// nextValid reads the next sample, starting over at the end of the file.
+// With --validate-data, bad samples fail, are skipped or are clamped. Samples
+// are normalized after validation.
+func (s *sampleStream) nextValid() (labelledSample, error) {
+	for {
+		sample, err := s.read()
+		if err == io.EOF && s.filename == stdinData {
+			return sample, errStdinClosed
+		}
+		if err == io.EOF {
+			if s.index == 0 {
+				return sample, fmt.Errorf("%s holds no samples", s.name)
+			}
+			if s.valid == 0 {
+				return sample, fmt.Errorf("%s holds no valid samples", s.name)
+			}
+			if err := s.rewind(); err != nil {
+				return sample, err
+			}
+			continue
+		}
+		if err != nil {
+			return sample, err
+		}
+		index := s.index
+		s.index++
+
+		problems := []string(nil)
+		if validateData {
+			problems = sampleProblems(sample.pixels)
+		}
+		sample.source, sample.index = s.name, index
+		if len(problems) == 0 {
+			s.valid++
+			sample.pixels = normalizeSample(sample.pixels, s.isRaw(sample.pixels))
+			return sample, nil
+		}
+		description := strings.Join(problems, ", ")
+		if badRows == "fail" {
+			return sample, fmt.Errorf("sample %d of %s has %s", index, s.name, description)
+		}
+		if pixels := fixSample(sample.pixels); pixels != nil {
+			logToWidget(fmt.Sprintf("Sample %d has %s, clamped", index, description))
+			sample.pixels = normalizeSample(pixels, s.isRaw(pixels))
+			s.valid++
+			return sample, nil
+		}
+		logToWidget(fmt.Sprintf("Sample %d has %s, skipped", index, description))
+	}
+}
+
+// isRaw reports whether the stream holds raw 0-255 pixels. In auto mode the
+// first valid sample decides for the whole stream, and for rawPixels.
+func (s *sampleStream) isRaw(pixels []float64) bool {
+	if s.scale == "" {
+		s.scale = "scaled"
+		if isRawScale(pixels) {
+			s.scale = "raw"
+		}
+		rawPixels = normalizedRaw(s.scale == "raw")
+	}
+	return s.scale == "raw"
+}
+
+// read decodes one sample and its label, returning io.EOF at the end of the
+// file
+func (s *sampleStream) read() (labelledSample, error) {
+	sample, err := s.readSample()
+	if err != nil || s.scanner == nil {
+		return sample, err
+	}
+	if sample.label, err = scanLabel(s.scanner); err == io.EOF {
+		return sample, fmt.Errorf("%s holds fewer labels than %s has samples", labelsFile, s.name)
+	}
+	return sample, err
+}
+
+// readSample decodes one sample from the data file
+func (s *sampleStream) readSample() (labelledSample, error) {
+	if s.format == "csv" {
+		record, err := s.first, error(nil)
+		if record != nil {
+			s.first = nil
+		} else {
+			record, err = s.csv.Read()
+		}
+		if err == io.EOF {
+			return labelledSample{}, err
+		}
+		if err != nil {
+			return labelledSample{}, fmt.Errorf("failed to read CSV: %v", err)
+		}
+		return parseCSVRecord(record, s.labelled)
+	}
+
+	sample := labelledSample{label: -1}
+	if s.format == "json" && !s.json.More() {
+		return sample, io.EOF
+	}
+	if err := s.json.Decode(&sample.pixels); err != nil {
+		if err == io.EOF {
+			return sample, err
+		}
+		return sample, fmt.Errorf("failed to decode JSON: %v", err)
+	}
+	return sample, nil
+}
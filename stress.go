@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// stressOptions configure the search for the highest sustainable rate
+type stressOptions struct {
+	enabled   bool
+	errorRate float64       // highest acceptable share of failed requests
+	p99       time.Duration // highest acceptable p99 latency
+	window    time.Duration // how long each rate is held
+	precision float64       // relative gap between passing and failing rates to stop at
+}
+
+var (
+	stressOpts stressOptions
+	// stressRate is the rate of the window being measured
+	stressRate float64
+	// stressStatus describes the search for the metrics table
+	stressStatus string
+	// windowLatencies and windowFailures collect the results of the window
+	// being measured by --stress or --sweep
+	measuringWindows bool
+	windowLatencies  []float64
+	windowFailures   int
+)
+
+// checkStressOptions validates the --stress thresholds
+func checkStressOptions() error {
+	if stressOpts.errorRate < 0 || stressOpts.errorRate >= 1 {
+		return fmt.Errorf("--stress-error-rate must be between 0 and 1")
+	}
+	if stressOpts.p99 <= 0 || stressOpts.window <= 0 {
+		return fmt.Errorf("--stress-p99 and --stress-window must be positive")
+	}
+	if stressOpts.precision <= 0 {
+		return fmt.Errorf("--stress-precision must be positive")
+	}
+	return nil
+}
+
+// stressRateProfile is the rate profile of the search
+func stressRateProfile(time.Duration) float64 {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	return stressRate
+}
+
+// recordWindow counts a result towards the window being measured. Callers
+// must hold metricsMutex.
+func recordWindow(success bool, latency float64) {
+	if !measuringWindows {
+		return
+	}
+	if success {
+		windowLatencies = append(windowLatencies, latency)
+	} else {
+		windowFailures++
+	}
+}
+
+// findMaxRate doubles the rate from start until a window breaches the error
+// rate or p99 thresholds, then bisects between the last passing and the
+// first failing rate. The returned channel is closed once the search is over.
+func findMaxRate(start float64, quit <-chan struct{}) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		good, bad := 0.0, 0.0
+		rate := start
+		for window := 1; ; window++ {
+			passed, ok := measureWindow(window, rate, good, bad, quit)
+			if !ok {
+				return
+			}
+			if passed {
+				good = rate
+			} else {
+				bad = rate
+			}
+			switch {
+			case bad == 0:
+				rate *= 2
+			case good == 0:
+				logToWidget(fmt.Sprintf("Stress test: even %.1f rps breaches the thresholds", bad))
+				setStressStatus(fmt.Sprintf("below %.1f rps", bad))
+				return
+			case (bad-good)/good <= stressOpts.precision:
+				logToWidget(fmt.Sprintf("Stress test: highest sustainable rate is %.1f rps (%.1f rps fails)", good, bad))
+				setStressStatus(fmt.Sprintf("%.1f rps (%.1f rps fails)", good, bad))
+				return
+			default:
+				rate = (good + bad) / 2
+			}
+		}
+	}()
+	return done
+}
+
+// measureWindow holds a rate for one window and checks its results against
+// the thresholds. It reports false when the run is stopped meanwhile.
+func measureWindow(window int, rate, good, bad float64, quit <-chan struct{}) (passed, ok bool) {
+	metricsMutex.Lock()
+	stressRate, targetRPS = rate, rate
+	stressStatus = fmt.Sprintf("window %d at %.1f rps (passing %.1f, failing %s)", window, rate, good, formatRate(bad))
+	metricsMutex.Unlock()
+
+	latencies, failures, ok := collectWindow(stressOpts.window, quit)
+	if !ok {
+		return false, false
+	}
+	total := len(latencies) + failures
+	errorRate := 0.0
+	if total > 0 {
+		errorRate = float64(failures) / float64(total)
+	}
+	p99 := latencyPercentile(latencies, 99)
+	passed = total > 0 && errorRate <= stressOpts.errorRate && p99 <= float64(stressOpts.p99)/float64(time.Millisecond)
+	verdict := "passed"
+	if !passed {
+		verdict = "failed"
+	}
+	logToWidget(fmt.Sprintf("Stress window %d at %.1f rps %s: %d requests, %.2f%% errors, p99 %.1f ms", window, rate, verdict, total, 100*errorRate, p99))
+	return passed, true
+}
+
+// collectWindow gathers the results of the requests finishing within length,
+// with their latencies sorted. Arrivals dropped because the bots or the send
+// queue were full count as failures: the endpoint didn't keep up. It reports
+// false when the run is stopped meanwhile.
+func collectWindow(length time.Duration, quit <-chan struct{}) ([]float64, int, bool) {
+	metricsMutex.Lock()
+	windowLatencies, windowFailures = nil, 0
+	dropped := droppedRequests + queueOverflows
+	metricsMutex.Unlock()
+
+	select {
+	case <-time.After(length):
+	case <-quit:
+		return nil, 0, false
+	}
+
+	metricsMutex.Lock()
+	latencies := append([]float64(nil), windowLatencies...)
+	failures := windowFailures + droppedRequests + queueOverflows - dropped
+	metricsMutex.Unlock()
+	sort.Float64s(latencies)
+	return latencies, failures, true
+}
+
+// latencyPercentile picks a percentile of sorted latencies, 0 without any
+func latencyPercentile(sorted []float64, percentile int) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[(len(sorted)*percentile-1)/100]
+}
+
+// formatRate prints a rate found so far, or "-" when there is none yet
+func formatRate(rate float64) string {
+	if rate == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f", rate)
+}
+
+// setStressStatus records the outcome of the search for the metrics table
+func setStressStatus(status string) {
+	metricsMutex.Lock()
+	stressStatus = status
+	metricsMutex.Unlock()
+}
+
+// stressRows is the metrics row of the search. Callers must hold
+// metricsMutex.
+func stressRows() [][]string {
+	if !stressOpts.enabled {
+		return nil
+	}
+	return [][]string{{"Max Sustainable Rate", stressStatus}}
+}
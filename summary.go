@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// printSummary writes the final metrics to stdout once the UI is closed
+func printSummary(reason string, rows [][]string) {
+	writeSummary(os.Stdout, reason, rows)
+}
+
+// saveSummary writes the final metrics to a file as well
+func saveSummary(filename, reason string, rows [][]string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	writeSummary(file, reason, rows)
+	return file.Close()
+}
+
+// writeSummary renders the stop reason and the metrics as a plain table
+func writeSummary(w io.Writer, reason string, rows [][]string) {
+	fmt.Fprintf(w, "MNIST bot stopped: %s\n\n", reason)
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintf(writer, "%s\t%s\n", row[0], row[1])
+	}
+	writer.Flush()
+}
+
+// runExitCode is the exit status of a finished run: 1 when requests were
+// sent and none of them succeeded, 2 when the run missed the --slo-* pass
+// criteria, else 0. Callers must hold metricsMutex.
+func runExitCode() int {
+	if failedRequests > 0 && successRequests == 0 {
+		return 1
+	}
+	if criteriaBreaches() != nil {
+		return 2
+	}
+	return 0
+}
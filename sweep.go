@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sweepLevel is the measurement of one level of a --sweep
+type sweepLevel struct {
+	level      float64 // bots or rps
+	requests   int
+	failures   int
+	throughput float64 // successful requests per second
+	average    float64 // latencies in ms
+	p50, p99   float64
+}
+
+var (
+	// sweepMode is "bots" or "rps", empty without --sweep
+	sweepMode string
+	// sweepFrom and sweepTo bound the levels, doubling from sweepFrom
+	sweepFrom, sweepTo float64
+	// sweepWindow is how long each level is measured
+	sweepWindow time.Duration
+	// sweepRate is the rate of the level being measured in rps mode
+	sweepRate float64
+	// sweepResults holds the levels measured so far
+	sweepResults []sweepLevel
+)
+
+// parseSweep parses --sweep: "bots:1-64" or "rps:10-640"
+func parseSweep(spec string) error {
+	mode, levels, ok := strings.Cut(spec, ":")
+	low, high, isRange := strings.Cut(levels, "-")
+	if !ok || !isRange || (mode != "bots" && mode != "rps") {
+		return fmt.Errorf("invalid --sweep %q (expected bots:FROM-TO or rps:FROM-TO)", spec)
+	}
+	from, err := strconv.ParseFloat(low, 64)
+	if err != nil || from <= 0 {
+		return fmt.Errorf("invalid --sweep start %q", low)
+	}
+	to, err := strconv.ParseFloat(high, 64)
+	if err != nil || to < from {
+		return fmt.Errorf("invalid --sweep end %q", high)
+	}
+	if mode == "bots" && (from != float64(int(from)) || to != float64(int(to))) {
+		return fmt.Errorf("--sweep bots needs whole numbers of bots")
+	}
+	sweepMode, sweepFrom, sweepTo = mode, from, to
+	return nil
+}
+
+// sweepLevels doubles from sweepFrom up to sweepTo, which is always the last
+func sweepLevels() []float64 {
+	var levels []float64
+	for level := sweepFrom; level < sweepTo; level *= 2 {
+		levels = append(levels, level)
+	}
+	return append(levels, sweepTo)
+}
+
+// sweepRateProfile is the rate profile of an rps sweep
+func sweepRateProfile(time.Duration) float64 {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	return sweepRate
+}
+
+// runSweep measures every level for --sweep-window, switching levels with
+// setLevel. The returned channel is closed once the last level is done.
+func runSweep(setLevel func(level float64), quit <-chan struct{}) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		levels := sweepLevels()
+		for i, level := range levels {
+			setLevel(level)
+			logToWidget(fmt.Sprintf("Sweep level %d/%d: %s for %s", i+1, len(levels), formatLevel(level), sweepWindow))
+			latencies, failures, ok := collectWindow(sweepWindow, quit)
+			if !ok {
+				return
+			}
+			result := sweepLevel{
+				level:      level,
+				requests:   len(latencies) + failures,
+				failures:   failures,
+				throughput: float64(len(latencies)) / sweepWindow.Seconds(),
+				p50:        latencyPercentile(latencies, 50),
+				p99:        latencyPercentile(latencies, 99),
+			}
+			for _, latency := range latencies {
+				result.average += latency / float64(len(latencies))
+			}
+			logToWidget(fmt.Sprintf("Sweep %s: %s", formatLevel(level), result))
+			metricsMutex.Lock()
+			sweepResults = append(sweepResults, result)
+			metricsMutex.Unlock()
+		}
+	}()
+	return done
+}
+
+// formatLevel names a sweep level
+func formatLevel(level float64) string {
+	if sweepMode == "bots" {
+		return fmt.Sprintf("%.0f bots", level)
+	}
+	return fmt.Sprintf("%.1f rps", level)
+}
+
+// String summarizes a level for the log and the metrics table
+func (l sweepLevel) String() string {
+	errors := 0.0
+	if l.requests > 0 {
+		errors = 100 * float64(l.failures) / float64(l.requests)
+	}
+	return fmt.Sprintf("%.1f rps ok, p50 %.1f ms, p99 %.1f ms, %.1f%% errors", l.throughput, l.p50, l.p99, errors)
+}
+
+// sweepRows renders one metrics row per level measured. Callers must hold
+// metricsMutex.
+func sweepRows() [][]string {
+	var rows [][]string
+	for _, result := range sweepResults {
+		rows = append(rows, []string{"Sweep " + formatLevel(result.level), result.String()})
+	}
+	return rows
+}
+
+// saveSweep writes the throughput-vs-latency curve as CSV, one line per level
+func saveSweep(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(file)
+	writer.Write([]string{sweepMode, "requests", "failures", "throughput_rps", "avg_ms", "p50_ms", "p99_ms"})
+	for _, result := range sweepResults {
+		writer.Write([]string{
+			strconv.FormatFloat(result.level, 'f', -1, 64),
+			strconv.Itoa(result.requests),
+			strconv.Itoa(result.failures),
+			fmt.Sprintf("%.3f", result.throughput),
+			fmt.Sprintf("%.3f", result.average),
+			fmt.Sprintf("%.3f", result.p50),
+			fmt.Sprintf("%.3f", result.p99),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// syntheticPatterns are the --generate patterns samples are fabricated from,
+// nil when samples come from --data
+var syntheticPatterns []string
+
+// syntheticGenerators fabricate 28x28 samples, with pixels scaled to 0-1
+var syntheticGenerators = map[string]func(random *rand.Rand) []float64{
+	"noise": func(random *rand.Rand) []float64 {
+		pixels := make([]float64, mnistSide*mnistSide)
+		for i := range pixels {
+			pixels[i] = random.Float64()
+		}
+		return pixels
+	},
+	"blank": func(random *rand.Rand) []float64 {
+		return make([]float64, mnistSide*mnistSide)
+	},
+	"white": func(random *rand.Rand) []float64 {
+		pixels := make([]float64, mnistSide*mnistSide)
+		for i := range pixels {
+			pixels[i] = 1
+		}
+		return pixels
+	},
+	"gradient": func(random *rand.Rand) []float64 {
+		// a horizontal, vertical or diagonal ramp, in either direction
+		dx, dy := float64(random.Intn(2)), float64(random.Intn(2))
+		if dx == 0 && dy == 0 {
+			dx = 1
+		}
+		reversed := random.Intn(2) == 1
+		pixels := make([]float64, mnistSide*mnistSide)
+		for y := 0; y < mnistSide; y++ {
+			for x := 0; x < mnistSide; x++ {
+				value := (dx*float64(x) + dy*float64(y)) / ((dx + dy) * (mnistSide - 1))
+				if reversed {
+					value = 1 - value
+				}
+				pixels[y*mnistSide+x] = value
+			}
+		}
+		return pixels
+	},
+}
+
+// parseSyntheticPatterns parses --generate, a comma-separated list of
+// patterns or "all"
+func parseSyntheticPatterns(spec string) ([]string, error) {
+	var names []string
+	for name := range syntheticGenerators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if spec == "all" {
+		return names, nil
+	}
+
+	var patterns []string
+	for _, field := range strings.Split(spec, ",") {
+		pattern := strings.TrimSpace(field)
+		if _, ok := syntheticGenerators[pattern]; !ok {
+			return nil, fmt.Errorf("unknown pattern %q (expected all or %s)", pattern, strings.Join(names, ", "))
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// syntheticSample fabricates an unlabelled sample from a random pattern
+func syntheticSample(random *rand.Rand) labelledSample {
+	pattern := syntheticPatterns[random.Intn(len(syntheticPatterns))]
+	return labelledSample{pixels: normalizeSample(syntheticGenerators[pattern](random), false), label: -1, source: pattern, index: -1}
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+)
+
+// targetAdapter describes how a REST serving API expects its requests and
+// how its responses should be read back
+type targetAdapter struct {
+	contentType string
+	// buildPayload encodes a batch of samples and parseResponse returns the
+	// scores of each
+	buildPayload  func(batch [][]float64) ([]byte, error)
+	parseResponse func(body []byte) ([][]float64, error)
+	// modelPath builds the request path for the configured model, for
+	// servers that address models by name in the URL
+	modelPath func() string
+}
+
+// targetAdapters lists the supported --target-type values
+var targetAdapters = map[string]targetAdapter{
+	"tfserving": {
+		contentType:   "application/json",
+		buildPayload:  buildTFServingPayload,
+		parseResponse: parseTFServingResponse,
+		modelPath:     tfServingModelPath,
+	},
+	"triton": {
+		contentType:   "application/json",
+		buildPayload:  buildV2Payload,
+		parseResponse: parseV2Response,
+		modelPath:     v2ModelPath,
+	},
+	"torchserve": {
+		contentType:   "application/json",
+		buildPayload:  buildTorchServePayload,
+		parseResponse: parseTorchServeResponse,
+		modelPath:     torchServeModelPath,
+	},
+	"seldon": {
+		contentType:   "application/json",
+		buildPayload:  buildSeldonPayload,
+		parseResponse: parseSeldonResponse,
+	},
+	"onnx": {
+		contentType:   "application/json",
+		buildPayload:  buildONNXPayload,
+		parseResponse: parseONNXResponse,
+		modelPath:     onnxModelPath,
+	},
+	"bentoml": {
+		contentType:   "application/json",
+		buildPayload:  buildBentoMLPayload,
+		parseResponse: parseBentoMLResponse,
+	},
+	"mlflow": {
+		contentType:   "application/json",
+		buildPayload:  buildMLflowPayload,
+		parseResponse: parseMLflowResponse,
+	},
+}
+
+// target is the adapter selected for the current run
+var target = targetAdapters["tfserving"]
+
+var (
+	// modelName, modelVersion and signatureName identify the served model
+	modelName     string
+	modelVersion  string
+	signatureName string
+
+	// inputName is the input tensor name for protocols that address inputs by name
+	inputName string
+)
+
+// mlflowFormat is the MLflow scoring input schema (dataframe_split or instances)
+var mlflowFormat string
+
+// resolveModelURL completes a bare server address such as
+// http://localhost:8501 with the selected target's model path. URLs that
+// already carry a path are used as given.
+func resolveModelURL(apiURL string) (string, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("%q is not an absolute URL", apiURL)
+	}
+	if (u.Path != "" && u.Path != "/") || target.modelPath == nil || modelName == "" {
+		return apiURL, nil
+	}
+	u.Path = target.modelPath()
+	return u.String(), nil
+}
+
+// tfServingModelPath returns the TF Serving REST predict path
+func tfServingModelPath() string {
+	path := "/v1/models/" + url.PathEscape(modelName)
+	if modelVersion != "" {
+		path += "/versions/" + modelVersion
+	}
+	return path + ":predict"
+}
+
+// v2ModelPath returns the KServe/Triton v2 infer path
+func v2ModelPath() string {
+	path := "/v2/models/" + url.PathEscape(modelName)
+	if modelVersion != "" {
+		path += "/versions/" + modelVersion
+	}
+	return path + "/infer"
+}
+
+// torchServeModelPath returns the TorchServe inference API path
+func torchServeModelPath() string {
+	path := "/predictions/" + url.PathEscape(modelName)
+	if modelVersion != "" {
+		path += "/" + modelVersion
+	}
+	return path
+}
+
+// onnxModelPath returns the ONNX Runtime Server predict path, which always
+// carries a version
+func onnxModelPath() string {
+	version := modelVersion
+	if version == "" {
+		version = "1"
+	}
+	return "/v1/models/" + url.PathEscape(modelName) + "/versions/" + version + ":predict"
+}
+
+// buildTFServingPayload builds the TF Serving REST row format
+func buildTFServingPayload(batch [][]float64) ([]byte, error) {
+	return json.Marshal(MNISTData{SignatureName: signatureName, Instances: batch})
+}
+
+// parseTFServingResponse reads the predictions from a TF Serving response
+func parseTFServingResponse(body []byte) ([][]float64, error) {
+	var resp struct {
+		Predictions [][]float64 `json:"predictions"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode TF Serving response: %v", err)
+	}
+	if len(resp.Predictions) == 0 {
+		return nil, fmt.Errorf("TF Serving response has no predictions")
+	}
+	return resp.Predictions, nil
+}
+
+// v2Tensor is a tensor in the KServe/Triton v2 inference protocol
+type v2Tensor struct {
+	Name     string    `json:"name"`
+	Shape    []int     `json:"shape"`
+	Datatype string    `json:"datatype"`
+	Data     []float64 `json:"data"`
+}
+
+// buildV2Payload builds a KServe/Triton v2 inference request
+func buildV2Payload(batch [][]float64) ([]byte, error) {
+	request := struct {
+		Inputs []v2Tensor `json:"inputs"`
+	}{
+		Inputs: []v2Tensor{{
+			Name:     inputName,
+			Shape:    []int{len(batch), len(batch[0])},
+			Datatype: "FP32",
+			Data:     flatten(batch),
+		}},
+	}
+	return json.Marshal(request)
+}
+
+// parseV2Response reads the rows of the first output tensor from a v2
+// inference response. An output of shape [N] holds the classes of N samples.
+func parseV2Response(body []byte) ([][]float64, error) {
+	var resp struct {
+		Outputs []v2Tensor `json:"outputs"`
+		Error   string     `json:"error"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode v2 response: %v", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("v2 inference error: %s", resp.Error)
+	}
+	if len(resp.Outputs) == 0 {
+		return nil, fmt.Errorf("v2 response has no outputs")
+	}
+	output := resp.Outputs[0]
+	return tensorRows(output.Data, output.Shape)
+}
+
+// buildTorchServePayload wraps the pixels the way TorchServe handlers read
+// JSON request bodies: a single sample as a flat list, a batch as a list of
+// samples
+func buildTorchServePayload(batch [][]float64) ([]byte, error) {
+	if len(batch) == 1 {
+		return json.Marshal(map[string][]float64{"data": batch[0]})
+	}
+	return json.Marshal(map[string][][]float64{"data": batch})
+}
+
+// parseTorchServeResponse accepts the shapes TorchServe handlers commonly
+// return: a bare class index, a list of scores, a batch of score lists, or
+// a label to probability map
+func parseTorchServeResponse(body []byte) ([][]float64, error) {
+	var digit int
+	if err := json.Unmarshal(body, &digit); err == nil {
+		return oneHotRow(digit)
+	}
+
+	var scores []float64
+	if err := json.Unmarshal(body, &scores); err == nil {
+		return [][]float64{scores}, nil
+	}
+
+	var batch [][]float64
+	if err := json.Unmarshal(body, &batch); err == nil && len(batch) > 0 {
+		return batch, nil
+	}
+
+	var probabilities map[string]float64
+	if err := json.Unmarshal(body, &probabilities); err != nil {
+		return nil, fmt.Errorf("failed to decode TorchServe response: %v", err)
+	}
+	scores = make([]float64, 10)
+	for label, probability := range probabilities {
+		index, err := strconv.Atoi(label)
+		if err != nil || index < 0 || index >= len(scores) {
+			return nil, fmt.Errorf("unexpected TorchServe label %q", label)
+		}
+		scores[index] = probability
+	}
+	return [][]float64{scores}, nil
+}
+
+// seldonMessage is the SeldonMessage envelope used by Seldon Core
+type seldonMessage struct {
+	Data struct {
+		Names   []string    `json:"names,omitempty"`
+		Ndarray [][]float64 `json:"ndarray,omitempty"`
+		Tensor  *struct {
+			Shape  []int     `json:"shape"`
+			Values []float64 `json:"values"`
+		} `json:"tensor,omitempty"`
+	} `json:"data"`
+	Status *struct {
+		Code   int    `json:"code"`
+		Info   string `json:"info"`
+		Status string `json:"status"`
+	} `json:"status,omitempty"`
+}
+
+// buildSeldonPayload builds a SeldonMessage with an ndarray payload
+func buildSeldonPayload(batch [][]float64) ([]byte, error) {
+	var message seldonMessage
+	message.Data.Ndarray = batch
+	return json.Marshal(message)
+}
+
+// parseSeldonResponse unwraps the rows of a SeldonMessage response
+func parseSeldonResponse(body []byte) ([][]float64, error) {
+	var message seldonMessage
+	if err := json.Unmarshal(body, &message); err != nil {
+		return nil, fmt.Errorf("failed to decode Seldon response: %v", err)
+	}
+	if message.Status != nil && message.Status.Status == "FAILURE" {
+		return nil, fmt.Errorf("Seldon error %d: %s", message.Status.Code, message.Status.Info)
+	}
+	if len(message.Data.Ndarray) > 0 {
+		return message.Data.Ndarray, nil
+	}
+	if tensor := message.Data.Tensor; tensor != nil && len(tensor.Values) > 0 {
+		rows := 1
+		if len(tensor.Shape) > 1 && tensor.Shape[0] > 0 {
+			rows = tensor.Shape[0]
+		}
+		return splitRows(tensor.Values, rows)
+	}
+	return nil, fmt.Errorf("Seldon response has no ndarray or tensor data")
+}
+
+// onnxTensor is the JSON form of an onnx.TensorProto used by ONNX Runtime Server
+type onnxTensor struct {
+	Dims      []string  `json:"dims"`
+	DataType  int       `json:"dataType"`
+	RawData   string    `json:"rawData,omitempty"`
+	FloatData []float64 `json:"floatData,omitempty"`
+}
+
+// onnxFloat is the FLOAT value of the onnx.TensorProto.DataType enum
+const onnxFloat = 1
+
+// buildONNXPayload builds an ONNX Runtime Server PredictRequest. 784 pixel
+// samples are shaped as NCHW images, which is what the ONNX model zoo MNIST
+// graph expects.
+func buildONNXPayload(batch [][]float64) ([]byte, error) {
+	n := strconv.Itoa(len(batch))
+	dims := []string{n, strconv.Itoa(len(batch[0]))}
+	if len(batch[0]) == 28*28 {
+		dims = []string{n, "1", "28", "28"}
+	}
+
+	data := flatten(batch)
+	raw := make([]byte, 4*len(data))
+	for i, value := range data {
+		binary.LittleEndian.PutUint32(raw[4*i:], math.Float32bits(float32(value)))
+	}
+
+	request := struct {
+		Inputs map[string]onnxTensor `json:"inputs"`
+	}{
+		Inputs: map[string]onnxTensor{
+			inputName: {Dims: dims, DataType: onnxFloat, RawData: base64.StdEncoding.EncodeToString(raw)},
+		},
+	}
+	return json.Marshal(request)
+}
+
+// parseONNXResponse reads the rows of the first output tensor of an ONNX
+// Runtime Server PredictResponse
+func parseONNXResponse(body []byte) ([][]float64, error) {
+	var resp struct {
+		Outputs map[string]onnxTensor `json:"outputs"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode ONNX Runtime response: %v", err)
+	}
+	for _, tensor := range resp.Outputs {
+		if tensor.DataType != onnxFloat {
+			return nil, fmt.Errorf("unsupported ONNX output data type %d", tensor.DataType)
+		}
+		rows := 1
+		if len(tensor.Dims) > 1 {
+			if n, err := strconv.Atoi(tensor.Dims[0]); err == nil && n > 0 {
+				rows = n
+			}
+		}
+		if len(tensor.FloatData) > 0 {
+			return splitRows(tensor.FloatData, rows)
+		}
+		raw, err := base64.StdEncoding.DecodeString(tensor.RawData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode ONNX rawData: %v", err)
+		}
+		scores := make([]float64, len(raw)/4)
+		for i := range scores {
+			scores[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(raw[4*i:])))
+		}
+		return splitRows(scores, rows)
+	}
+	return nil, fmt.Errorf("ONNX Runtime response has no outputs")
+}
+
+// buildBentoMLPayload builds the JSON ndarray body read by BentoML's
+// NumpyNdarray input descriptor
+func buildBentoMLPayload(batch [][]float64) ([]byte, error) {
+	return json.Marshal(batch)
+}
+
+// parseBentoMLResponse reads a JSON ndarray output, which is either a batch
+// of score rows or a batch of predicted classes
+func parseBentoMLResponse(body []byte) ([][]float64, error) {
+	var rows [][]float64
+	if err := json.Unmarshal(body, &rows); err == nil && len(rows) > 0 {
+		return rows, nil
+	}
+
+	var classes []float64
+	if err := json.Unmarshal(body, &classes); err != nil {
+		return nil, fmt.Errorf("failed to decode BentoML response: %v", err)
+	}
+	if len(classes) == 0 {
+		return nil, fmt.Errorf("BentoML response is empty")
+	}
+	return oneHotRows(classes)
+}
+
+// buildMLflowPayload builds a request for `mlflow models serve` in the
+// configured input schema
+func buildMLflowPayload(batch [][]float64) ([]byte, error) {
+	switch mlflowFormat {
+	case "dataframe_split":
+		var request struct {
+			DataframeSplit struct {
+				Data [][]float64 `json:"data"`
+			} `json:"dataframe_split"`
+		}
+		request.DataframeSplit.Data = batch
+		return json.Marshal(request)
+	case "instances":
+		return json.Marshal(MNISTData{Instances: batch})
+	default:
+		return nil, fmt.Errorf("unknown MLflow input format %q", mlflowFormat)
+	}
+}
+
+// parseMLflowResponse reads the predictions, which are either score rows or
+// predicted classes depending on the logged model flavor
+func parseMLflowResponse(body []byte) ([][]float64, error) {
+	var resp struct {
+		Predictions json.RawMessage `json:"predictions"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode MLflow response: %v", err)
+	}
+
+	var rows [][]float64
+	if err := json.Unmarshal(resp.Predictions, &rows); err == nil && len(rows) > 0 {
+		return rows, nil
+	}
+
+	var classes []float64
+	if err := json.Unmarshal(resp.Predictions, &classes); err != nil {
+		return nil, fmt.Errorf("unexpected MLflow predictions: %v", err)
+	}
+	if len(classes) == 0 {
+		return nil, fmt.Errorf("MLflow response has no predictions")
+	}
+	return oneHotRows(classes)
+}
+
+// oneHotScores turns a predicted class into a score vector so services that
+// only return the label fit the same pipeline as those returning scores
+func oneHotScores(digit int) ([]float64, error) {
+	if digit < 0 || digit > 9 {
+		return nil, fmt.Errorf("predicted class %d is out of range", digit)
+	}
+	scores := make([]float64, 10)
+	scores[digit] = 1
+	return scores, nil
+}
+
+// oneHotRow is oneHotScores for a response holding a single prediction
+func oneHotRow(digit int) ([][]float64, error) {
+	scores, err := oneHotScores(digit)
+	if err != nil {
+		return nil, err
+	}
+	return [][]float64{scores}, nil
+}
+
+// oneHotRows turns a list of predicted classes into score rows
+func oneHotRows(classes []float64) ([][]float64, error) {
+	rows := make([][]float64, len(classes))
+	for i, class := range classes {
+		scores, err := oneHotScores(int(class))
+		if err != nil {
+			return nil, err
+		}
+		rows[i] = scores
+	}
+	return rows, nil
+}
+
+// tensorRows splits an output tensor into one score row per sample. The
+// first dimension counts the samples, and rows one value wide hold class
+// indices rather than scores.
+func tensorRows(values []float64, shape []int) ([][]float64, error) {
+	rows := 1
+	if len(shape) > 0 {
+		rows = shape[0]
+	}
+	if rows > 0 && len(values) == rows {
+		return oneHotRows(values)
+	}
+	return splitRows(values, rows)
+}
+
+// predictedDigits returns the predicted digit of each score row
+func predictedDigits(rows [][]float64) []int {
+	digits := make([]int, len(rows))
+	for i, scores := range rows {
+		digits[i] = predictedDigit(scores)
+	}
+	return digits
+}
+
+// predictedDigit returns the index of the highest score in a prediction
+func predictedDigit(scores []float64) int {
+	best := -1
+	for i, score := range scores {
+		if best < 0 || score > scores[best] {
+			best = i
+		}
+	}
+	return best
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/template"
+	"time"
+)
+
+// bodyTemplate is the --body-template used by the template payload format
+var bodyTemplate *template.Template
+
+// requestInfo describes the request a batch is sent in
+type requestInfo struct {
+	id      string
+	labels  []int // one per sample, -1 when the dataset has no labels
+	indexes []int // position of each sample in its source, -1 if generated
+	sent    time.Time
+}
+
+type requestInfoKey struct{}
+
+// withRequestInfo attaches the request's details to ctx
+func withRequestInfo(ctx context.Context, info requestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// requestInfoFrom returns the request details attached to ctx
+func requestInfoFrom(ctx context.Context) requestInfo {
+	if info, ok := ctx.Value(requestInfoKey{}).(requestInfo); ok {
+		return info
+	}
+	return requestInfo{}
+}
+
+// newRequestID returns a random (version 4) UUID
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// pixelList and pixelRows print as JSON arrays inside templates, so
+// {{.Pixels}} expands to a JSON array while index, len and range still work
+type (
+	pixelList []float64
+	pixelRows [][]float64
+)
+
+func (p pixelList) String() string { return toJSON(p) }
+func (p pixelRows) String() string { return toJSON(p) }
+
+// toJSON encodes a template value as JSON
+func toJSON(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%q", err.Error())
+	}
+	return string(data)
+}
+
+// templateData is what a body template sees. Pixels, Image and Label describe
+// the first sample of the batch.
+type templateData struct {
+	Pixels    pixelList   // flat list of pixels
+	Image     pixelRows   // pixels as 28 rows of 28
+	Label     int         // ground-truth digit, or -1
+	Batch     []pixelList // every sample of the batch
+	Labels    []int       // the label of every sample, or -1
+	Indexes   []int       // the position of every sample in its source, or -1
+	RequestID string
+	Model     string
+	Version   string
+	Signature string
+	Input     string
+}
+
+// loadBodyTemplate parses a request body template file
+func loadBodyTemplate(filename string) (*template.Template, error) {
+	text, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %v", err)
+	}
+	tmpl, err := template.New(filename).Funcs(template.FuncMap{
+		"json": toJSON,
+	}).Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// templateEncoder renders request bodies from a body template
+type templateEncoder struct {
+	tmpl *template.Template
+}
+
+// ContentType is the target type's, as templates usually mimic its format
+func (e templateEncoder) ContentType() string {
+	return target.contentType
+}
+
+// Encode renders the template for one batch
+func (e templateEncoder) Encode(ctx context.Context, batch [][]float64) ([]byte, error) {
+	info := requestInfoFrom(ctx)
+	sample := batch[0]
+	data := templateData{
+		Pixels:    sample,
+		Label:     -1,
+		Labels:    info.labels,
+		Indexes:   info.indexes,
+		RequestID: info.id,
+		Model:     modelName,
+		Version:   modelVersion,
+		Signature: signatureName,
+		Input:     inputName,
+	}
+	for _, pixels := range batch {
+		data.Batch = append(data.Batch, pixels)
+	}
+	if len(info.labels) > 0 {
+		data.Label = info.labels[0]
+	}
+	if len(sample) == mnistSide*mnistSide {
+		for row := 0; row < mnistSide; row++ {
+			data.Image = append(data.Image, sample[row*mnistSide:(row+1)*mnistSide])
+		}
+	} else {
+		data.Image = pixelRows{sample}
+	}
+
+	var body bytes.Buffer
+	if err := e.tmpl.Execute(&body, data); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
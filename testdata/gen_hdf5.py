@@ -0,0 +1,159 @@
+#!/usr/bin/env python3
+"""Writes mnist.h5, the HDF5 fixture of hdf5_test.go.
+
+The file is assembled from the HDF5 file format specification, not written
+by h5py. It only imitates the layout h5py writes with its default (earliest)
+format settings: a version 0 superblock, version 1 object headers and a root
+group indexed by a symbol table (v1 B-tree, SNOD and local heap). It holds
+
+  x_test   uint8 (3, 28, 28), contiguous
+  y_test   int64 (3,), contiguous
+  x_train  float32 (3, 784), chunked (2, 784) with shuffle and gzip
+
+Pixel (i, j) of x_test is (31*i + 7*j) % 256, x_train holds
+((784*i + j) % 256) / 255 and y_test is [7, 2, 1]. gen_hdf5_reference.py
+writes the same datasets with h5py itself, for the reference file
+hdf5_test.go also reads.
+"""
+import struct
+import zlib
+
+UNDEF = 0xFFFFFFFFFFFFFFFF
+
+
+def pad8(b):
+    return b + b"\0" * (-len(b) % 8)
+
+
+def message(typ, data, flags=0):
+    data = pad8(data)
+    return struct.pack("<HHB3x", typ, len(data), flags) + data
+
+
+def object_header(messages):
+    body = b"".join(messages)
+    return struct.pack("<BBHII4x", 1, 0, len(messages), 1, len(body)) + body
+
+
+def dataspace(dims):
+    data = struct.pack("<BBB5x", 1, len(dims), 1)
+    data += b"".join(struct.pack("<Q", d) for d in dims)  # dimensions
+    data += b"".join(struct.pack("<Q", d) for d in dims)  # maximum dimensions
+    return message(0x01, data)
+
+
+def fixed_type(size, signed):
+    bits = 0x08 if signed else 0
+    return message(0x03, struct.pack("<BBBBIHH", 0x10, bits, 0, 0, size, 0, 8 * size), flags=1)
+
+
+def float32_type():
+    # little-endian, implied mantissa bit, sign at bit 31
+    return message(0x03, struct.pack("<BBBBIHHBBBBI", 0x11, 0x20, 31, 0, 4, 0, 32, 23, 8, 0, 23, 127), flags=1)
+
+
+def fill_value(alloc_time):
+    return message(0x05, struct.pack("<BBBB", 2, alloc_time, 2, 0), flags=1)
+
+
+def mtime():
+    return message(0x12, struct.pack("<B3xI", 1, 1700000000))
+
+
+def contiguous_layout(addr, size):
+    return message(0x08, struct.pack("<BBQQ", 3, 1, addr, size))
+
+
+def chunked_layout(btree, chunk_dims, elem_size):
+    dims = list(chunk_dims) + [elem_size]
+    data = struct.pack("<BBBQ", 3, 2, len(dims), btree) + b"".join(struct.pack("<I", d) for d in dims)
+    return message(0x08, data)
+
+
+def filter_pipeline():
+    shuffle = struct.pack("<HHHH", 2, 8, 1, 1) + b"shuffle\0" + struct.pack("<I4x", 4)
+    deflate = struct.pack("<HHHH", 1, 8, 0, 1) + b"deflate\0" + struct.pack("<I4x", 4)
+    return message(0x0B, struct.pack("<BB6x", 1, 2) + shuffle + deflate)
+
+
+def shuffle(data, size):
+    n = len(data) // size
+    return bytes(data[i * size + j] for j in range(size) for i in range(n))
+
+
+class Writer:
+    def __init__(self):
+        self.buf = bytearray(96)  # superblock, written last
+
+    def alloc(self, data):
+        addr = len(self.buf)
+        self.buf += pad8(data)
+        return addr
+
+    def patch(self, addr, data):
+        self.buf[addr:addr + len(data)] = data
+
+
+def main():
+    w = Writer()
+
+    x_test = bytes((31 * i + 7 * j) % 256 for i in range(3) for j in range(784))
+    y_test = struct.pack("<3q", 7, 2, 1)
+    x_train = [((784 * i + j) % 256) / 255 for i in range(3) for j in range(784)]
+
+    datasets = {}
+
+    addr = w.alloc(x_test)
+    datasets["x_test"] = w.alloc(object_header([
+        dataspace([3, 28, 28]), fixed_type(1, False), fill_value(2), contiguous_layout(addr, len(x_test)), mtime(),
+    ]))
+
+    addr = w.alloc(y_test)
+    datasets["y_test"] = w.alloc(object_header([
+        dataspace([3]), fixed_type(8, True), fill_value(2), contiguous_layout(addr, len(y_test)), mtime(),
+    ]))
+
+    # Two chunks of two rows, the second only half inside the dataset
+    tree = b"TREE" + struct.pack("<BBHQQ", 1, 0, 2, UNDEF, UNDEF)
+    for row in (0, 2):
+        values = x_train[row * 784:(row + 2) * 784]
+        values += [0.0] * (2 * 784 - len(values))
+        chunk = zlib.compress(shuffle(struct.pack("<%df" % len(values), *values), 4))
+        tree += struct.pack("<IIQQQQ", len(chunk), 0, row, 0, 0, w.alloc(chunk))
+    tree += struct.pack("<IIQQQ", 0, 0, 3, 784, 0)
+    btree = w.alloc(tree)
+    datasets["x_train"] = w.alloc(object_header([
+        dataspace([3, 784]), float32_type(), fill_value(3), chunked_layout(btree, [2, 784], 4), filter_pipeline(), mtime(),
+    ]))
+
+    # Root group: local heap of names, one SNOD and a B-tree pointing at it
+    heap_data = b"\0" * 8
+    offsets = {}
+    for name in sorted(datasets):
+        offsets[name] = len(heap_data)
+        heap_data += pad8(name.encode() + b"\0")
+    heap_data_addr = w.alloc(heap_data)
+    heap = w.alloc(b"HEAP" + struct.pack("<B3xQQQ", 0, len(heap_data), UNDEF, heap_data_addr))
+
+    entries = b""
+    for name in sorted(datasets):
+        entries += struct.pack("<QQII16x", offsets[name], datasets[name], 0, 0)
+    entries += b"\0" * (40 * (8 - len(datasets)))
+    snod = w.alloc(b"SNOD" + struct.pack("<BBH", 1, 0, len(datasets)) + entries)
+
+    last = offsets[sorted(datasets)[-1]]
+    root_tree = w.alloc(b"TREE" + struct.pack("<BBHQQ", 0, 0, 1, UNDEF, UNDEF) + struct.pack("<QQQ", 0, snod, last))
+    root = w.alloc(object_header([message(0x11, struct.pack("<QQ", root_tree, heap))]))
+
+    superblock = b"\x89HDF\r\n\x1a\n" + struct.pack("<BBBBBBBBHHI", 0, 0, 0, 0, 0, 8, 8, 0, 4, 16, 0)
+    superblock += struct.pack("<QQQQ", 0, UNDEF, len(w.buf), UNDEF)
+    superblock += struct.pack("<QQII", 0, root, 1, 0) + struct.pack("<QQ", root_tree, heap)
+    assert len(superblock) == 96
+    w.patch(0, superblock)
+
+    with open("mnist.h5", "wb") as f:
+        f.write(w.buf)
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,36 @@
+#!/usr/bin/env python3
+"""Writes reference/mnist-h5py.h5.
+
+Unlike mnist.h5, which gen_hdf5.py assembles byte by byte after the layout
+h5py writes, this file comes from h5py itself, with its default format
+settings. It holds the same datasets:
+
+  x_test   uint8 (3, 28, 28), contiguous
+  y_test   int64 (3,), contiguous
+  x_train  float32 (3, 784), chunked (2, 784) with shuffle and gzip
+
+hdf5_test.go reads every file of testdata/reference it finds, so run this
+where h5py is installed and commit the file it writes.
+"""
+import os
+
+import h5py
+import numpy as np
+
+HERE = os.path.dirname(os.path.abspath(__file__))
+OUT = os.path.join(HERE, "reference")
+
+
+def main():
+    i, j = np.ogrid[:3, :784]
+    x_test = ((31 * i + 7 * j) % 256).astype(np.uint8).reshape(3, 28, 28)
+    x_train = (((784 * i + j) % 256) / 255).astype(np.float32)
+    os.makedirs(OUT, exist_ok=True)
+    with h5py.File(os.path.join(OUT, "mnist-h5py.h5"), "w") as f:
+        f["x_test"] = x_test
+        f["y_test"] = np.array([7, 2, 1], dtype=np.int64)
+        f.create_dataset("x_train", data=x_train, chunks=(2, 784), shuffle=True, compression="gzip")
+
+
+if __name__ == "__main__":
+    main()
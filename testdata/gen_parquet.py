@@ -0,0 +1,205 @@
+#!/usr/bin/env python3
+"""Writes mnist.parquet, the Parquet fixture of parquet_test.go.
+
+The file is assembled from the Parquet specification, not written by
+pyarrow. It only imitates the layout pq.write_table writes by default:
+Snappy-compressed column chunks, each a PLAIN dictionary page followed by a
+v1 data page of RLE_DICTIONARY indices with RLE levels, and the three-level
+LIST structure for list columns. It holds three rows in two row groups:
+
+  pixels  list<double>  pixel j of row i is ((31*i + 7*j) % 256) / 255
+  label   int64         [7, 2, 1]
+
+The Snappy blocks hold literals only, which every Snappy decoder reads.
+gen_parquet_reference.py writes the same rows with pyarrow and Spark
+themselves, for the reference files parquet_test.go also reads.
+"""
+import struct
+
+ROWS = [[((31 * i + 7 * j) % 256) / 255 for j in range(784)] for i in range(3)]
+LABELS = [7, 2, 1]
+
+# Thrift compact protocol types
+T_TRUE, T_FALSE, T_I16, T_I32, T_I64, T_BINARY, T_LIST, T_STRUCT = 1, 2, 4, 5, 6, 8, 9, 12
+
+
+def uvarint(n):
+    out = bytearray()
+    while True:
+        if n < 0x80:
+            out.append(n)
+            return bytes(out)
+        out.append(n & 0x7F | 0x80)
+        n >>= 7
+
+
+def zigzag(n):
+    return uvarint((n << 1) ^ (n >> 63))
+
+
+def struct_(*fields):
+    """Encodes a struct from (id, type, value) fields in increasing id order."""
+    out, last = bytearray(), 0
+    for fid, typ, value in fields:
+        if typ in (T_TRUE, T_FALSE):
+            typ, value = (T_TRUE if value else T_FALSE), None
+        delta = fid - last
+        out += bytes([delta << 4 | typ]) if 0 < delta <= 15 else bytes([typ]) + zigzag(fid)
+        last = fid
+        if value is not None:
+            out += value
+    return bytes(out) + b"\0"
+
+
+def i32(n):
+    return zigzag(n)
+
+
+def string(s):
+    b = s.encode() if isinstance(s, str) else s
+    return uvarint(len(b)) + b
+
+
+def list_(typ, items):
+    header = bytes([len(items) << 4 | typ]) if len(items) < 15 else bytes([0xF0 | typ]) + uvarint(len(items))
+    return header + b"".join(items)
+
+
+def snappy(data):
+    out = bytearray(uvarint(len(data)))
+    for start in range(0, len(data), 65536):
+        chunk = data[start:start + 65536]
+        n = len(chunk) - 1
+        if n < 60:
+            out.append(n << 2)
+        elif n < 256:
+            out += bytes([60 << 2, n])
+        else:
+            out += bytes([61 << 2]) + struct.pack("<H", n)
+        out += chunk
+    return bytes(out)
+
+
+def rle_runs(values, bit_width):
+    """RLE/bit-packing hybrid with RLE runs only, as used for levels."""
+    out, i, width = bytearray(), 0, (bit_width + 7) // 8
+    while i < len(values):
+        j = i
+        while j < len(values) and values[j] == values[i]:
+            j += 1
+        out += uvarint((j - i) << 1) + values[i].to_bytes(width, "little")
+        i = j
+    return bytes(out)
+
+
+def bit_packed(values, bit_width):
+    """RLE/bit-packing hybrid with one bit-packed run, as used for indices."""
+    padded = values + [0] * (-len(values) % 8)
+    bits = 0
+    for k, value in enumerate(padded):
+        bits |= value << (k * bit_width)
+    return uvarint((len(padded) // 8) << 1 | 1) + bits.to_bytes(len(padded) * bit_width // 8, "little")
+
+
+def levels(values, max_level):
+    encoded = rle_runs(values, max_level.bit_length())
+    return struct.pack("<I", len(encoded)) + encoded
+
+
+class Writer:
+    def __init__(self):
+        self.buf = bytearray(b"PAR1")
+
+    def page(self, header, body):
+        compressed = snappy(body)
+        offset = len(self.buf)
+        self.buf += struct_((1, T_I32, i32(header[0])), (2, T_I32, i32(len(body))),
+                            (3, T_I32, i32(len(compressed))), header[1]) + compressed
+        return offset, len(body), len(compressed)
+
+    def column(self, values, rep, defs, max_rep, max_def, physical, fmt, path):
+        dictionary = sorted(set(values))
+        index = {value: i for i, value in enumerate(dictionary)}
+        bit_width = max(1, (len(dictionary) - 1).bit_length())
+
+        dict_body = struct.pack("<%d%s" % (len(dictionary), fmt), *dictionary)
+        dict_offset, dict_raw, dict_size = self.page(
+            (2, (7, T_STRUCT, struct_((1, T_I32, i32(len(dictionary))), (2, T_I32, i32(0)), (3, T_FALSE, False)))),
+            dict_body)
+
+        body = b""
+        if max_rep:
+            body += levels(rep, max_rep)
+        body += levels(defs, max_def)
+        body += bytes([bit_width]) + bit_packed([index[v] for v in values], bit_width)
+        data_offset, data_raw, data_size = self.page(
+            (0, (5, T_STRUCT, struct_((1, T_I32, i32(len(defs))), (2, T_I32, i32(8)),
+                                      (3, T_I32, i32(3)), (4, T_I32, i32(3))))),
+            body)
+
+        meta = struct_(
+            (1, T_I32, i32(physical)),
+            (2, T_LIST, list_(T_I32, [i32(0), i32(3), i32(8)])),
+            (3, T_LIST, list_(T_BINARY, [string(p) for p in path])),
+            (4, T_I32, i32(1)),  # SNAPPY
+            (5, T_I64, zigzag(len(defs))),
+            (6, T_I64, zigzag(data_offset + data_raw - dict_offset)),
+            (7, T_I64, zigzag(len(self.buf) - dict_offset)),
+            (9, T_I64, zigzag(data_offset)),
+            (11, T_I64, zigzag(dict_offset)),
+        )
+        size = len(self.buf) - dict_offset
+        chunk = struct_((2, T_I64, zigzag(dict_offset)), (3, T_STRUCT, meta))
+        return chunk, size, dict_offset
+
+
+def main():
+    w = Writer()
+    row_groups = []
+    for first in (0, 2):
+        rows = ROWS[first:first + 2]
+        labels = LABELS[first:first + 2]
+
+        values, rep, defs = [], [], []
+        for row in rows:
+            for j, value in enumerate(row):
+                values.append(value)
+                rep.append(0 if j == 0 else 1)
+                defs.append(3)
+        pixels, pixels_size, offset = w.column(values, rep, defs, 1, 3, 5, "d", ["pixels", "list", "element"])
+        label, label_size, _ = w.column(labels, [], [1] * len(labels), 0, 1, 2, "q", ["label"])
+
+        total = pixels_size + label_size
+        row_groups.append(struct_(
+            (1, T_LIST, list_(T_STRUCT, [pixels, label])),
+            (2, T_I64, zigzag(total)),
+            (3, T_I64, zigzag(len(rows))),
+            (5, T_I64, zigzag(offset)),
+            (6, T_I64, zigzag(total)),
+            (7, T_I16, zigzag(len(row_groups))),
+        ))
+
+    schema = [
+        struct_((4, T_BINARY, string("schema")), (5, T_I32, i32(2))),
+        struct_((3, T_I32, i32(1)), (4, T_BINARY, string("pixels")), (5, T_I32, i32(1)), (6, T_I32, i32(3)),
+                (10, T_STRUCT, struct_((3, T_STRUCT, struct_())))),
+        struct_((3, T_I32, i32(2)), (4, T_BINARY, string("list")), (5, T_I32, i32(1))),
+        struct_((1, T_I32, i32(5)), (3, T_I32, i32(1)), (4, T_BINARY, string("element"))),
+        struct_((1, T_I32, i32(2)), (3, T_I32, i32(1)), (4, T_BINARY, string("label"))),
+    ]
+    footer = struct_(
+        (1, T_I32, i32(2)),
+        (2, T_LIST, list_(T_STRUCT, schema)),
+        (3, T_I64, zigzag(len(ROWS))),
+        (4, T_LIST, list_(T_STRUCT, row_groups)),
+        (6, T_BINARY, string("parquet-cpp-arrow version 15.0.0")),
+        (7, T_LIST, list_(T_STRUCT, [struct_((1, T_STRUCT, struct_()))] * 2)),
+    )
+    w.buf += footer + struct.pack("<I", len(footer)) + b"PAR1"
+
+    with open("mnist.parquet", "wb") as f:
+        f.write(w.buf)
+
+
+if __name__ == "__main__":
+    main()
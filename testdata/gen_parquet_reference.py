@@ -0,0 +1,72 @@
+#!/usr/bin/env python3
+"""Writes reference/mnist-pyarrow.parquet, reference/mnist-spark.parquet and
+reference/samples-pyarrow.parquet.
+
+Unlike mnist.parquet, which gen_parquet.py assembles byte by byte after the
+layout pyarrow writes, these files come from the writers themselves, with
+their default settings. They hold the same three rows:
+
+  pixels  list<double>  pixel j of row i is ((31*i + 7*j) % 256) / 255
+  label   int64         [7, 2, 1]
+
+samples-pyarrow.parquet holds the table parquet_writer_test.go writes with
+writeParquetSamples, for comparing the two files' footers:
+
+  label               int32, required  [3, 9]
+  pixel0 to pixel2    double, required [0, 0.5, 1] and [0.25, 0.75, 0.125]
+
+The tests read the files of testdata/reference they find, so run this
+where pyarrow (and, for the Spark file, pyspark with a JVM) is installed and
+commit the files it writes. Either writer is skipped when missing.
+"""
+import glob
+import os
+import shutil
+import tempfile
+
+HERE = os.path.dirname(os.path.abspath(__file__))
+OUT = os.path.join(HERE, "reference")
+ROWS = [[((31 * i + 7 * j) % 256) / 255 for j in range(784)] for i in range(3)]
+LABELS = [7, 2, 1]
+
+
+def write_pyarrow():
+    import pyarrow as pa
+    import pyarrow.parquet as pq
+
+    table = pa.table({"pixels": pa.array(ROWS, pa.list_(pa.float64())), "label": pa.array(LABELS, pa.int64())})
+    pq.write_table(table, os.path.join(OUT, "mnist-pyarrow.parquet"), row_group_size=2)
+
+    samples = [[0, 0.5, 1], [0.25, 0.75, 0.125]]
+    fields = [pa.field("label", pa.int32(), nullable=False)]
+    fields += [pa.field(f"pixel{j}", pa.float64(), nullable=False) for j in range(3)]
+    columns = [pa.array([3, 9], pa.int32())]
+    columns += [pa.array([sample[j] for sample in samples], pa.float64()) for j in range(3)]
+    pq.write_table(pa.Table.from_arrays(columns, schema=pa.schema(fields)), os.path.join(OUT, "samples-pyarrow.parquet"))
+
+
+def write_spark():
+    from pyspark.sql import SparkSession
+    from pyspark.sql.types import ArrayType, DoubleType, LongType, StructField, StructType
+
+    spark = SparkSession.builder.master("local[1]").getOrCreate()
+    schema = StructType([StructField("pixels", ArrayType(DoubleType())), StructField("label", LongType())])
+    frame = spark.createDataFrame(list(zip(ROWS, LABELS)), schema)
+    with tempfile.TemporaryDirectory() as tmp:
+        frame.coalesce(1).write.parquet(os.path.join(tmp, "out"))
+        (part,) = glob.glob(os.path.join(tmp, "out", "part-*.parquet"))
+        shutil.copy(part, os.path.join(OUT, "mnist-spark.parquet"))
+    spark.stop()
+
+
+def main():
+    os.makedirs(OUT, exist_ok=True)
+    for name, write in [("pyarrow", write_pyarrow), ("Spark", write_spark)]:
+        try:
+            write()
+        except ImportError as e:
+            print(f"skipping the {name} file: {e}")
+
+
+if __name__ == "__main__":
+    main()
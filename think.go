@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// thinkTime is the distribution of the pause each bot takes between two
+// requests of its own
+type thinkTime struct {
+	kind     string        // fixed, uniform or lognormal
+	min, max time.Duration // max only for uniform
+	sigma    float64       // lognormal: spread around the median, min
+}
+
+// botThinkTime, when set, replaces the --interval ticker of each bot
+var botThinkTime *thinkTime
+
+// parseThinkTime parses --think-time: a fixed pause ("2s"), a uniform range
+// ("1s-5s") or a lognormal distribution by its median and sigma
+// ("lognormal:2s:0.5")
+func parseThinkTime(spec string) (*thinkTime, error) {
+	if rest, ok := strings.CutPrefix(spec, "lognormal:"); ok {
+		medianText, sigmaText, ok := strings.Cut(rest, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid think time %q (expected lognormal:MEDIAN:SIGMA)", spec)
+		}
+		median, err := time.ParseDuration(medianText)
+		if err != nil || median <= 0 {
+			return nil, fmt.Errorf("invalid median %q in think time %q", medianText, spec)
+		}
+		sigma, err := strconv.ParseFloat(sigmaText, 64)
+		if err != nil || sigma < 0 {
+			return nil, fmt.Errorf("invalid sigma %q in think time %q", sigmaText, spec)
+		}
+		return &thinkTime{kind: "lognormal", min: median, sigma: sigma}, nil
+	}
+	if low, high, ok := strings.Cut(spec, "-"); ok {
+		from, err := time.ParseDuration(low)
+		if err != nil || from < 0 {
+			return nil, fmt.Errorf("invalid think time %q", spec)
+		}
+		to, err := time.ParseDuration(high)
+		if err != nil || to <= from {
+			return nil, fmt.Errorf("invalid think time range %q", spec)
+		}
+		return &thinkTime{kind: "uniform", min: from, max: to}, nil
+	}
+	pause, err := time.ParseDuration(spec)
+	if err != nil || pause <= 0 {
+		return nil, fmt.Errorf("invalid think time %q", spec)
+	}
+	return &thinkTime{kind: "fixed", min: pause}, nil
+}
+
+// draw returns the next pause
+func (t *thinkTime) draw(random *rand.Rand) time.Duration {
+	switch t.kind {
+	case "uniform":
+		return t.min + time.Duration(random.Int63n(int64(t.max-t.min)+1))
+	case "lognormal":
+		return time.Duration(float64(t.min) * math.Exp(t.sigma*random.NormFloat64()))
+	default:
+		return t.min
+	}
+}
+
+// String describes the distribution for the log
+func (t *thinkTime) String() string {
+	switch t.kind {
+	case "uniform":
+		return fmt.Sprintf("%s to %s", t.min, t.max)
+	case "lognormal":
+		return fmt.Sprintf("a median of %s (sigma %g)", t.min, t.sigma)
+	default:
+		return t.min.String()
+	}
+}
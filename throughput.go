@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// currentRateSeconds is how many seconds the current achieved rate is
+// averaged over
+const currentRateSeconds = 5
+
+// runStarted and runStopped are when the bots started and stopped sending,
+// zero until then
+var runStarted, runStopped time.Time
+
+// runEnd is when the bots stopped sending, or now while they run
+func runEnd() time.Time {
+	if runStopped.IsZero() {
+		return time.Now()
+	}
+	return runStopped
+}
+
+// throughputRows is the metrics row of the rate requests finish at: now,
+// over the run, and against the scheduled rate if there is one. Callers must
+// hold metricsMutex.
+func throughputRows() [][]string {
+	if runStarted.IsZero() {
+		return nil
+	}
+	end := runEnd()
+	now := recentRate(currentRateSeconds, end)
+	average := float64(successRequests+failedRequests) / end.Sub(runStarted).Seconds()
+	value := fmt.Sprintf("%.1f rps now", now)
+	if currentRate > 0 {
+		value += fmt.Sprintf(" (%+.1f%% off the target)", 100*(now-currentRate)/currentRate)
+	}
+	value += fmt.Sprintf(", %.1f rps average", average)
+	return [][]string{{"Achieved Rate", value}}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// requestTimeout bounds each request, from sending it to reading the
+	// whole response (0 means no limit)
+	requestTimeout time.Duration
+	// runCtx is the parent of every request's context. cancelRun aborts the
+	// requests still in flight once the --duration deadline has passed or
+	// --drain-timeout has run out.
+	runCtx, cancelRun = context.WithCancel(context.Background())
+	// timedOutRequests counts requests cut off by --request-timeout
+	timedOutRequests int
+	// drainTimeout is how long requests still in flight when the bots stop
+	// get to finish before they're abandoned
+	drainTimeout time.Duration
+	// abandonedRequests counts requests cancelled because the run ended
+	// before they finished
+	abandonedRequests int
+)
+
+// requestContext returns the context a request is sent with
+func requestContext(info requestInfo) (context.Context, context.CancelFunc) {
+	ctx := withRequestInfo(runCtx, info)
+	if requestTimeout > 0 {
+		return context.WithTimeout(ctx, requestTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// recordTimeout counts a request that ran out of time, or was abandoned
+// when the run ended
+func recordTimeout() {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	if runCtx.Err() != nil {
+		abandonedRequests++
+	} else {
+		timedOutRequests++
+	}
+}
+
+// waitDone returns a channel closed once wg is done
+func waitDone(wg *sync.WaitGroup) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// timeoutRows are the metrics rows of requests cut off. Callers must hold
+// metricsMutex.
+func timeoutRows() [][]string {
+	var rows [][]string
+	if timedOutRequests > 0 {
+		rows = append(rows, []string{"Timed Out", fmt.Sprintf("%d", timedOutRequests)})
+	}
+	if abandonedRequests > 0 {
+		rows = append(rows, []string{"Abandoned", fmt.Sprintf("%d", abandonedRequests)})
+	}
+	return rows
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// tlsOptions holds the TLS flags shared by the REST and gRPC clients
+type tlsOptions struct {
+	certFile           string
+	keyFile            string
+	caFile             string
+	serverName         string
+	minVersion         string
+	insecureSkipVerify bool
+}
+
+// tlsVersions maps --tls-min-version values to crypto/tls constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig builds the client TLS configuration. It returns nil when no
+// TLS flags were given so the transports keep their defaults.
+func newTLSConfig(opts tlsOptions) (*tls.Config, error) {
+	if opts == (tlsOptions{}) {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         opts.serverName,
+		InsecureSkipVerify: opts.insecureSkipVerify,
+	}
+
+	if opts.minVersion != "" {
+		version, ok := tlsVersions[opts.minVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q (expected 1.0, 1.1, 1.2 or 1.3)", opts.minVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if opts.certFile != "" || opts.keyFile != "" {
+		if opts.certFile == "" || opts.keyFile == "" {
+			return nil, fmt.Errorf("--tls-cert and --tls-key must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.certFile, opts.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if opts.caFile != "" {
+		pem, err := os.ReadFile(opts.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
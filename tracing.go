@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// traceRatio is the share of requests traced
+	traceRatio float64
+	// tracerProvider is nil while tracing is off
+	tracerProvider *sdktrace.TracerProvider
+)
+
+// setupTracing exports a span per request to --otlp-endpoint and makes the
+// targets pass the W3C trace context on to the server
+func setupTracing() error {
+	if traceRatio < 0 || traceRatio > 1 {
+		return fmt.Errorf("--trace-ratio must be between 0 and 1")
+	}
+	host, insecure, urlPath, err := otlpTarget("traces")
+	if err != nil {
+		return err
+	}
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(host), otlptracehttp.WithURLPath(urlPath)}
+	if insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return fmt.Errorf("error creating the OTLP exporter: %v", err)
+	}
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.TraceIDRatioBased(traceRatio)),
+		sdktrace.WithResource(otlpResource()),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return nil
+}
+
+// shutdownTracing flushes the spans not exported yet
+func shutdownTracing() {
+	if tracerProvider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		logger.Errorf("Failed to flush the traces: %v", err)
+	}
+}
+
+// startRequestSpan starts the span of a request, a no-op one while tracing
+// is off
+func startRequestSpan(ctx context.Context, endpoint string, info requestInfo) (context.Context, trace.Span) {
+	return otel.Tracer("mnist-bot").Start(ctx, "predict",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("mnist.endpoint", endpoint),
+			attribute.String("mnist.request_id", info.id),
+			attribute.Int("mnist.batch_size", len(info.indexes)),
+			attribute.IntSlice("mnist.sample_indexes", info.indexes),
+			attribute.IntSlice("mnist.labels", info.labels),
+		))
+}
+
+// endRequestSpan records the outcome of a request on its span: the status
+// code, and for failures the error and its kind
+func endRequestSpan(span trace.Span, result Result, kind string, err error) {
+	if result.Status != "" {
+		span.SetAttributes(attribute.String("mnist.status", result.Status))
+	}
+	if err != nil {
+		span.SetAttributes(attribute.String("mnist.failure", kind))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, kind)
+	}
+	span.End()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+)
+
+// httpClient is the client shared by all REST bots
+var httpClient = http.DefaultClient
+
+// transportOptions holds the connection tuning flags for REST requests
+type transportOptions struct {
+	httpVersion         string
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	maxConnsPerHost     int
+	idleConnTimeout     time.Duration
+	tlsConfig           *tls.Config
+}
+
+// newHTTPClient builds the REST client for the requested HTTP version.
+// "auto" negotiates HTTP/2 over TLS and falls back to HTTP/1.1, "1.1" never
+// upgrades, "2" forces HTTP/2 over TLS, "h2c" speaks HTTP/2 over plaintext
+// and "3" uses HTTP/3 over QUIC.
+func newHTTPClient(opts transportOptions) (*http.Client, error) {
+	if opts.httpVersion == "3" {
+		transport := &http3.Transport{
+			TLSClientConfig: opts.tlsConfig,
+			QUICConfig:      &quic.Config{MaxIdleTimeout: opts.idleConnTimeout},
+		}
+		return &http.Client{Transport: transport}, nil
+	}
+
+	if opts.httpVersion == "h2c" {
+		// h2c has no connection pool limits of its own, only an idle timeout
+		transport := &http2.Transport{
+			AllowHTTP:       true,
+			IdleConnTimeout: opts.idleConnTimeout,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, addr)
+			},
+		}
+		return &http.Client{Transport: transport}, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = opts.maxIdleConns
+	transport.MaxIdleConnsPerHost = opts.maxIdleConnsPerHost
+	transport.MaxConnsPerHost = opts.maxConnsPerHost
+	transport.IdleConnTimeout = opts.idleConnTimeout
+	if opts.tlsConfig != nil {
+		transport.TLSClientConfig = opts.tlsConfig.Clone()
+	}
+
+	switch opts.httpVersion {
+	case "auto":
+	case "1.1":
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	case "2":
+		transport.ForceAttemptHTTP2 = true
+		if err := http2.ConfigureTransport(transport); err != nil {
+			return nil, fmt.Errorf("failed to enable HTTP/2: %v", err)
+		}
+		transport.TLSClientConfig.NextProtos = []string{http2.NextProtoTLS}
+	default:
+		return nil, fmt.Errorf("unknown HTTP version %q (expected auto, 1.1, 2, h2c or 3)", opts.httpVersion)
+	}
+	return &http.Client{Transport: transport}, nil
+}
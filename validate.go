@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+var (
+	// validateData checks every sample before it is sent
+	validateData bool
+	// badRows is what happens to samples failing validation: fail, skip or clamp
+	badRows string
+	// maxPixel is the largest valid pixel value
+	maxPixel float64
+)
+
+// Problems found by sampleProblems
+const (
+	problemLength  = "wrong number of values"
+	problemNaN     = "NaN values"
+	problemRange   = "values out of range"
+	reportExamples = 5
+)
+
+// sampleProblems returns the problems of one sample. NaNs and out-of-range
+// values are reported once per sample.
+func sampleProblems(pixels []float64) []string {
+	var problems []string
+	if len(pixels) != mnistSide*mnistSide {
+		problems = append(problems, problemLength)
+	}
+	var nan, outOfRange bool
+	for _, pixel := range pixels {
+		if math.IsNaN(pixel) {
+			nan = true
+		} else if pixel < 0 || pixel > maxPixel {
+			outOfRange = true
+		}
+	}
+	if nan {
+		problems = append(problems, problemNaN)
+	}
+	if outOfRange {
+		problems = append(problems, problemRange)
+	}
+	return problems
+}
+
+// repairSample clamps values into range and replaces NaNs with zero. It
+// returns false for samples that cannot be repaired (wrong length).
+func repairSample(pixels []float64) ([]float64, bool) {
+	if len(pixels) != mnistSide*mnistSide {
+		return nil, false
+	}
+	repaired := make([]float64, len(pixels))
+	for i, pixel := range pixels {
+		switch {
+		case math.IsNaN(pixel), pixel < 0:
+			repaired[i] = 0
+		case pixel > maxPixel:
+			repaired[i] = maxPixel
+		default:
+			repaired[i] = pixel
+		}
+	}
+	return repaired, true
+}
+
+// dataReport counts the samples affected by each problem
+type dataReport struct {
+	total    int
+	bad      int
+	dropped  int
+	kept     []int            // indexes of the samples kept
+	problems map[string][]int // sample indexes per problem
+	order    []string
+}
+
+// add records the problems of the sample at index
+func (r *dataReport) add(index int, problems []string) {
+	if r.problems == nil {
+		r.problems = map[string][]int{}
+	}
+	r.bad++
+	for _, problem := range problems {
+		if _, ok := r.problems[problem]; !ok {
+			r.order = append(r.order, problem)
+		}
+		r.problems[problem] = append(r.problems[problem], index)
+	}
+}
+
+// lines formats the report, one line per problem
+func (r *dataReport) lines() []string {
+	lines := []string{fmt.Sprintf("Validated %d samples: %d with problems", r.total, r.bad)}
+	for _, problem := range r.order {
+		indexes := r.problems[problem]
+		examples := make([]string, 0, reportExamples)
+		for _, index := range indexes {
+			if len(examples) == reportExamples {
+				examples = append(examples, "...")
+				break
+			}
+			examples = append(examples, fmt.Sprint(index))
+		}
+		lines = append(lines, fmt.Sprintf("  %s: %d (samples %s)", problem, len(indexes), strings.Join(examples, ", ")))
+	}
+	if r.dropped > 0 {
+		lines = append(lines, fmt.Sprintf("  skipped: %d", r.dropped))
+	}
+	return lines
+}
+
+// validateSamples checks every sample and, depending on --bad-rows, fails,
+// drops bad samples or repairs them. labels may be nil.
+func validateSamples(samples [][]float64, labels []int) ([][]float64, []int, *dataReport, error) {
+	report := &dataReport{total: len(samples)}
+	kept := samples[:0:0]
+	var keptLabels []int
+	for i, sample := range samples {
+		problems := sampleProblems(sample)
+		if len(problems) > 0 {
+			report.add(i, problems)
+			if badRows == "fail" {
+				continue
+			}
+			if sample = fixSample(sample); sample == nil {
+				report.dropped++
+				continue
+			}
+		}
+		kept = append(kept, sample)
+		report.kept = append(report.kept, i)
+		if labels != nil {
+			keptLabels = append(keptLabels, labels[i])
+		}
+	}
+	if report.bad > 0 && badRows == "fail" {
+		return nil, nil, report, fmt.Errorf("%d of %d samples failed validation (use --bad-rows skip or clamp)", report.bad, report.total)
+	}
+	if len(kept) == 0 {
+		return nil, nil, report, fmt.Errorf("no valid samples left")
+	}
+	return kept, keptLabels, report, nil
+}
+
+// fixSample applies --bad-rows to a sample with problems, returning nil when
+// the sample is to be skipped
+func fixSample(sample []float64) []float64 {
+	if badRows == "clamp" {
+		if repaired, ok := repairSample(sample); ok {
+			return repaired
+		}
+	}
+	return nil
+}
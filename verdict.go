@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// sloErrorRate, sloP99 and sloAccuracy are the pass criteria of a run: the
+	// highest share of failed requests, the highest p99 latency and the
+	// lowest share of correct predictions (0 means not checked)
+	sloErrorRate float64
+	sloP99       time.Duration
+	sloAccuracy  float64
+)
+
+// checkCriteria validates --slo-error-rate, --slo-p99 and --slo-accuracy
+func checkCriteria() error {
+	if sloErrorRate < 0 || sloErrorRate > 1 || sloAccuracy < 0 || sloAccuracy > 1 {
+		return fmt.Errorf("--slo-error-rate and --slo-accuracy must be between 0 and 1")
+	}
+	if sloP99 < 0 {
+		return fmt.Errorf("--slo-p99 must not be negative")
+	}
+	return nil
+}
+
+// criteriaSet reports whether any pass criteria were given
+func criteriaSet() bool {
+	return sloErrorRate > 0 || sloP99 > 0 || sloAccuracy > 0
+}
+
+// criteriaBreaches describes the pass criteria the run missed. Callers must
+// hold metricsMutex.
+func criteriaBreaches() []string {
+	var breaches []string
+	finished := successRequests + failedRequests
+	if sloErrorRate > 0 && finished > 0 {
+		if rate := float64(failedRequests) / float64(finished); rate > sloErrorRate {
+			breaches = append(breaches, fmt.Sprintf("error rate %.2f%% > %.2f%%", 100*rate, 100*sloErrorRate))
+		}
+	}
+	if sloP99 > 0 {
+		limit := float64(sloP99) / float64(time.Millisecond)
+		if p99 := histogramPercentile(99); latencyHistogram.TotalCount() == 0 || p99 > limit {
+			breaches = append(breaches, fmt.Sprintf("p99 %.1f ms > %.1f ms", p99, limit))
+		}
+	}
+	if sloAccuracy > 0 {
+		if predictions == 0 {
+			breaches = append(breaches, "no labelled predictions to check the accuracy of")
+		} else if accuracy := float64(correctPredictions) / float64(predictions); accuracy < sloAccuracy {
+			breaches = append(breaches, fmt.Sprintf("accuracy %.2f%% < %.2f%%", 100*accuracy, 100*sloAccuracy))
+		}
+	}
+	return breaches
+}
+
+// verdictRows is the metrics row of the pass criteria. Callers must hold
+// metricsMutex.
+func verdictRows() [][]string {
+	if !criteriaSet() {
+		return nil
+	}
+	verdict := "pass"
+	if breaches := criteriaBreaches(); breaches != nil {
+		verdict = "fail: " + strings.Join(breaches, ", ")
+	}
+	return [][]string{{"Verdict", verdict}}
+}
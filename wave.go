@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// sineWave is the rate profile of --sine-period: the rate swings between min
+// and max once every period, starting at min
+type sineWave struct {
+	min, max float64
+	period   time.Duration
+}
+
+// loadWave, when set, replaces --rps with a rate following the wave
+var loadWave *sineWave
+
+// checkSineWave validates the --sine-* flags
+func checkSineWave(wave *sineWave) error {
+	if wave.period < 0 {
+		return fmt.Errorf("--sine-period must not be negative")
+	}
+	if wave.min < 0 || wave.max <= wave.min {
+		return fmt.Errorf("--sine-max must be above --sine-min, and --sine-min must not be negative")
+	}
+	return nil
+}
+
+// rate is the rate elapsed into the run
+func (w *sineWave) rate(elapsed time.Duration) float64 {
+	phase := 2 * math.Pi * float64(elapsed) / float64(w.period)
+	return w.min + (w.max-w.min)*(1-math.Cos(phase))/2
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsDialer is shared by all WebSocket bots
+var wsDialer = websocket.DefaultDialer
+
+// configureWebSocket applies the TLS settings to the WebSocket dialer
+func configureWebSocket(tlsConfig *tls.Config) {
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = tlsConfig
+	wsDialer = &dialer
+}
+
+// webSocketTarget holds one bot's persistent connection to an endpoint.
+// Sends are serialized so every response can be matched to its request and
+// timed as a round trip.
+type webSocketTarget struct {
+	mu   sync.Mutex
+	url  string
+	conn *websocket.Conn
+}
+
+// newWebSocketTarget returns a Target with its own connection, so each bot
+// keeps a separate WebSocket
+func newWebSocketTarget(endpoint string) (Target, error) {
+	return &webSocketTarget{url: endpoint}, nil
+}
+
+// connect dials the endpoint if there is no open connection. Callers must
+// hold mu.
+func (t *webSocketTarget) connect(ctx context.Context) error {
+	if t.conn != nil {
+		return nil
+	}
+
+	// Build a throwaway request so headers and auth apply to the handshake
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.url, nil)
+	if err != nil {
+		return err
+	}
+	for key, values := range extraHeaders {
+		req.Header[key] = values
+	}
+	if signRequest != nil {
+		if err := signRequest(req, nil); err != nil {
+			return err
+		}
+	}
+
+	conn, _, err := wsDialer.DialContext(ctx, t.url, req.Header)
+	if err != nil {
+		return err
+	}
+	t.conn = conn
+	return nil
+}
+
+// drop closes a broken connection so the next send reconnects. Callers must
+// hold mu.
+func (t *webSocketTarget) drop() {
+	if t.conn != nil {
+		t.conn.Close()
+		t.conn = nil
+	}
+}
+
+// Send frames one batch on the connection and waits for the reply
+func (t *webSocketTarget) Send(ctx context.Context, batch [][]float64) (Result, error) {
+	var result Result
+
+	payload, err := encoder.Encode(ctx, batch)
+	if err != nil {
+		return result, fmt.Errorf("error building payload: %v", err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.connect(ctx); err != nil {
+		return result, &sendError{fmt.Errorf("error connecting WebSocket: %w", err)}
+	}
+
+	messageType := websocket.TextMessage
+	if isBinaryContentType(encoder.ContentType()) {
+		messageType = websocket.BinaryMessage
+	}
+
+	// gorilla/websocket ignores ctx, so apply its deadline to the socket and
+	// close the connection if it is cancelled, unblocking the write or read
+	deadline, _ := ctx.Deadline()
+	t.conn.SetWriteDeadline(deadline)
+	t.conn.SetReadDeadline(deadline)
+	conn := t.conn
+	stopWatching := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stopWatching()
+
+	startTime := time.Now()
+	if err := t.conn.WriteMessage(messageType, payload); err != nil {
+		t.drop()
+		return result, &sendError{fmt.Errorf("error sending WebSocket message: %w", contextError(ctx, err))}
+	}
+	recordBodyBytes(len(payload), len(payload))
+	_, body, err := t.conn.ReadMessage()
+	result.Latency = time.Since(startTime).Seconds() * 1000
+	if err != nil {
+		t.drop()
+		return result, &sendError{fmt.Errorf("error reading WebSocket reply: %w", contextError(ctx, err))}
+	}
+	recordResponseBytes(len(body))
+	result.Protocol = "WebSocket"
+
+	scores, err := target.parseResponse(body)
+	if err != nil {
+		return result, fmt.Errorf("invalid response: %v", err)
+	}
+	result.Predicted = predictedDigits(scores)
+	return result, nil
+}
+
+// contextError reports ctx's error in place of err when ctx ended the send, so
+// a connection closed on cancellation counts as a timeout rather than a
+// network failure
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}